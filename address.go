@@ -17,10 +17,13 @@
 package transport
 
 import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
 	"fmt"
-	"io"
 	"math"
 	"net"
+	"os"
 	"strconv"
 	"strings"
 	"time"
@@ -38,6 +41,22 @@ const (
 
 	KeyHandshakeTimeout       = "handshakeTimeout"
 	KeyCachedHandshakeTimeout = "cachedHandshakeTimeout"
+
+	// KeyTLSHandshakeTimeout is the handshake timeout for a handler of a shared tls listener
+	KeyTLSHandshakeTimeout = "tlsHandshakeTimeout"
+
+	// KeyHandshakeRateLimiter holds a rate.AdaptiveRateLimitTracker for the handshakes of a tls listener
+	KeyHandshakeRateLimiter = "handshakeRateLimiter"
+
+	KeySocket              = "socket"
+	KeyCachedSocketOptions = "cachedSocketOptions"
+
+	KeyProxyProtocol       = "proxyProtocol"
+	KeyCachedProxyProtocol = "cachedProxyProtocol"
+
+	KeyServerNames        = "serverNames"
+	KeyFallback           = "fallback"
+	KeyProtocolPreference = "protocolPreference"
 )
 
 type Configuration map[interface{}]interface{}
@@ -62,6 +81,67 @@ func (self Configuration) Protocols() []string {
 	return nil
 }
 
+// ServerNames returns the server names (SNI) which a tls listener should handle connections for. Names may start with
+// a *. wildcard label. If none are configured, the listener handles connections for any server name
+func (self Configuration) ServerNames() ([]string, error) {
+	return self.getStringList(KeyServerNames)
+}
+
+// ProtocolPreference returns the application protocols (ALPN) a tls listener prefers, most preferred first. When
+// set, the listener chooses among the protocols offered by a client in this order, rather than by the client's list
+func (self Configuration) ProtocolPreference() ([]string, error) {
+	return self.getStringList(KeyProtocolPreference)
+}
+
+// IsFallback returns true if a tls listener handler should be selected for connections which no other handler accepts,
+// such as those requesting unknown application protocols
+func (self Configuration) IsFallback() (bool, error) {
+	if self == nil {
+		return false, nil
+	}
+
+	val, found := self[KeyFallback]
+	if !found {
+		return false, nil
+	}
+
+	result, ok := val.(bool)
+	if !ok {
+		return false, fmt.Errorf("invalid value for %s [%v], must be bool", KeyFallback, val)
+	}
+	return result, nil
+}
+
+func (self Configuration) getStringList(key string) ([]string, error) {
+	if self == nil {
+		return nil, nil
+	}
+
+	val, found := self[key]
+	if !found {
+		return nil, nil
+	}
+
+	switch v := val.(type) {
+	case string:
+		return []string{v}, nil
+	case []string:
+		return v, nil
+	case []interface{}:
+		var result []string
+		for _, elem := range v {
+			s, ok := elem.(string)
+			if !ok {
+				return nil, fmt.Errorf("invalid value in %s [%v], must be string", key, elem)
+			}
+			result = append(result, s)
+		}
+		return result, nil
+	default:
+		return nil, fmt.Errorf("invalid value for %s [%v], must be string or list of strings", key, val)
+	}
+}
+
 func (self Configuration) GetProxyConfiguration() (*ProxyConfiguration, error) {
 	if self == nil {
 		return nil, nil
@@ -113,6 +193,22 @@ func (self Configuration) GetHandshakeTimeout() (time.Duration, error) {
 	return 0, nil
 }
 
+// GetTLSHandshakeTimeout returns the handshake timeout for a handler of a shared tls listener, or 0 if it isn't set
+func (self Configuration) GetTLSHandshakeTimeout() (time.Duration, error) {
+	val, ok := self[KeyTLSHandshakeTimeout]
+	if !ok {
+		return 0, nil
+	}
+
+	strVal, ok := val.(string)
+	if !ok {
+		return 0, errors.Errorf("invalid %s, must be string value", KeyTLSHandshakeTimeout)
+	}
+
+	timeout, err := time.ParseDuration(strVal)
+	return timeout, errors.Wrapf(err, "unable to parse %s '%s' to duration", KeyTLSHandshakeTimeout, strVal)
+}
+
 func (self Configuration) GetUIntValue(first string, rest ...string) (uint, bool, error) {
 	val, err := self.GetValue(first, rest...)
 	if val == nil {
@@ -190,12 +286,25 @@ type ProxyType string
 const (
 	ProxyTypeNone        ProxyType = "none"
 	ProxyTypeHttpConnect ProxyType = "http"
+	ProxyTypeSocks5      ProxyType = "socks5"
+
+	// ProxyTypeHttpsConnect establishes TLS to the proxy server before issuing the CONNECT request, so that proxy
+	// credentials and destination addresses are not sent in the clear
+	ProxyTypeHttpsConnect ProxyType = "https"
+
+	// ProxyTypeEnvironment selects the proxy per destination from the standard proxy environment variables. See
+	// ProxyConfiguration.ForDestination
+	ProxyTypeEnvironment ProxyType = "env"
 )
 
 type ProxyConfiguration struct {
 	Type    ProxyType
 	Address string
 	Auth    *proxy.Auth
+
+	// TLSConfig is used to connect to ProxyTypeHttpsConnect proxies. If nil, or if no server name is set, the proxy
+	// certificate is verified against the system roots and the host name from the proxy address
+	TLSConfig *tls.Config
 }
 
 func LoadProxyConfiguration(cfg map[interface{}]interface{}) (*ProxyConfiguration, error) {
@@ -209,9 +318,9 @@ func LoadProxyConfiguration(cfg map[interface{}]interface{}) (*ProxyConfiguratio
 		return nil, errors.New("proxy type must be a string")
 	}
 
-	if proxyType == string(ProxyTypeNone) {
+	if proxyType == string(ProxyTypeNone) || proxyType == string(ProxyTypeEnvironment) {
 		return &ProxyConfiguration{
-			Type: ProxyTypeNone,
+			Type: ProxyType(proxyType),
 		}, nil
 	}
 
@@ -220,6 +329,10 @@ func LoadProxyConfiguration(cfg map[interface{}]interface{}) (*ProxyConfiguratio
 	switch proxyType {
 	case string(ProxyTypeHttpConnect):
 		result.Type = ProxyTypeHttpConnect
+	case string(ProxyTypeSocks5):
+		result.Type = ProxyTypeSocks5
+	case string(ProxyTypeHttpsConnect):
+		result.Type = ProxyTypeHttpsConnect
 	default:
 		return nil, errors.Errorf("invalid proxy type %s", proxyType)
 	}
@@ -253,15 +366,65 @@ func LoadProxyConfiguration(cfg map[interface{}]interface{}) (*ProxyConfiguratio
 		}
 	}
 
+	if result.Type == ProxyTypeHttpsConnect {
+		tlsConfig, err := loadProxyTLSConfig(cfg)
+		if err != nil {
+			return nil, err
+		}
+		result.TLSConfig = tlsConfig
+	}
+
+	return result, nil
+}
+
+// loadProxyTLSConfig reads the optional caFile and serverName settings of an https proxy. caFile is a PEM bundle
+// which replaces the system roots when verifying the proxy certificate. serverName overrides the name used for
+// SNI and certificate verification, which otherwise defaults to the host name of the proxy address
+func loadProxyTLSConfig(cfg map[interface{}]interface{}) (*tls.Config, error) {
+	result := &tls.Config{
+		MinVersion: tls.VersionTLS12,
+	}
+
+	if val, found := cfg["serverName"]; found {
+		serverName, ok := val.(string)
+		if !ok {
+			return nil, errors.Errorf("invalid value for %s proxy serverName [%v], must be string", string(ProxyTypeHttpsConnect), val)
+		}
+		result.ServerName = serverName
+	}
+
+	if val, found := cfg["caFile"]; found {
+		caFile, ok := val.(string)
+		if !ok {
+			return nil, errors.Errorf("invalid value for %s proxy caFile [%v], must be string", string(ProxyTypeHttpsConnect), val)
+		}
+
+		pem, err := os.ReadFile(caFile)
+		if err != nil {
+			return nil, errors.Wrapf(err, "unable to read %s proxy caFile %s", string(ProxyTypeHttpsConnect), caFile)
+		}
+
+		result.RootCAs = x509.NewCertPool()
+		if !result.RootCAs.AppendCertsFromPEM(pem) {
+			return nil, errors.Errorf("no certificates found in %s proxy caFile %s", string(ProxyTypeHttpsConnect), caFile)
+		}
+	}
+
 	return result, nil
 }
 
 // Address implements the functionality provided by a generic "address".
+//
+// DialContext and DialWithLocalBindingContext behave like Dial and DialWithLocalBinding, except that the deadline
+// and cancellation of the given context apply to the whole dial, including name resolution, proxy negotiation and
+// any TLS/DTLS handshake. Once the connection is returned, the context no longer has any effect on it.
 type Address interface {
 	Dial(name string, i *identity.TokenId, timeout time.Duration, tcfg Configuration) (Conn, error)
 	DialWithLocalBinding(name string, binding string, i *identity.TokenId, timeout time.Duration, tcfg Configuration) (Conn, error)
-	Listen(name string, i *identity.TokenId, acceptF func(Conn), tcfg Configuration) (io.Closer, error)
-	MustListen(name string, i *identity.TokenId, acceptF func(Conn), tcfg Configuration) io.Closer
+	DialContext(ctx context.Context, name string, i *identity.TokenId, tcfg Configuration) (Conn, error)
+	DialWithLocalBindingContext(ctx context.Context, name string, binding string, i *identity.TokenId, tcfg Configuration) (Conn, error)
+	Listen(name string, i *identity.TokenId, acceptF func(Conn), tcfg Configuration) (Listener, error)
+	MustListen(name string, i *identity.TokenId, acceptF func(Conn), tcfg Configuration) Listener
 	String() string
 	Type() string
 }
@@ -1,7 +1,17 @@
 package transport
 
 import (
+	"crypto/tls"
+	"encoding/pem"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
 	"testing"
+
+	"github.com/stretchr/testify/require"
+	"golang.org/x/net/proxy"
 )
 
 func TestParseAddressHostPort(t *testing.T) {
@@ -69,3 +79,88 @@ func TestHostPortString(t *testing.T) {
 		})
 	}
 }
+
+func TestLoadProxyConfiguration(t *testing.T) {
+	tests := []struct {
+		name    string
+		input   map[interface{}]interface{}
+		want    ProxyConfiguration
+		wantErr bool
+	}{
+		{"none", map[interface{}]interface{}{"type": "none"}, ProxyConfiguration{Type: ProxyTypeNone}, false},
+		{"http", map[interface{}]interface{}{"type": "http", "address": "proxy:3128"},
+			ProxyConfiguration{Type: ProxyTypeHttpConnect, Address: "proxy:3128"}, false},
+		{"socks5", map[interface{}]interface{}{"type": "socks5", "address": "proxy:1080"},
+			ProxyConfiguration{Type: ProxyTypeSocks5, Address: "proxy:1080"}, false},
+		{"socks5 with auth", map[interface{}]interface{}{"type": "socks5", "address": "proxy:1080", "username": "user", "password": "pass"},
+			ProxyConfiguration{Type: ProxyTypeSocks5, Address: "proxy:1080", Auth: &proxy.Auth{User: "user", Password: "pass"}}, false},
+		{"missing type", map[interface{}]interface{}{"address": "proxy:1080"}, ProxyConfiguration{}, true},
+		{"invalid type", map[interface{}]interface{}{"type": "socks4", "address": "proxy:1080"}, ProxyConfiguration{}, true},
+		{"missing address", map[interface{}]interface{}{"type": "socks5"}, ProxyConfiguration{}, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := LoadProxyConfiguration(tt.input)
+			if tt.wantErr {
+				if err == nil {
+					t.Errorf("LoadProxyConfiguration(%v) expected error, got nil", tt.input)
+				}
+				return
+			}
+			if err != nil {
+				t.Errorf("LoadProxyConfiguration(%v) unexpected error: %v", tt.input, err)
+				return
+			}
+			if !reflect.DeepEqual(*got, tt.want) {
+				t.Errorf("LoadProxyConfiguration(%v) = %+v, want %+v", tt.input, *got, tt.want)
+			}
+		})
+	}
+}
+
+func TestLoadHttpsProxyConfiguration(t *testing.T) {
+	server := httptest.NewTLSServer(nil)
+	defer server.Close()
+
+	dir := t.TempDir()
+	caFile := filepath.Join(dir, "ca.pem")
+	require.NoError(t, os.WriteFile(caFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: server.Certificate().Raw}), 0600))
+	emptyFile := filepath.Join(dir, "empty.pem")
+	require.NoError(t, os.WriteFile(emptyFile, nil, 0600))
+
+	t.Run("defaults", func(t *testing.T) {
+		got, err := LoadProxyConfiguration(map[interface{}]interface{}{"type": "https", "address": "proxy:3129", "username": "user", "password": "pass"})
+		require.NoError(t, err)
+		require.Equal(t, ProxyTypeHttpsConnect, got.Type)
+		require.Equal(t, "proxy:3129", got.Address)
+		require.Equal(t, &proxy.Auth{User: "user", Password: "pass"}, got.Auth)
+		require.NotNil(t, got.TLSConfig)
+		require.Nil(t, got.TLSConfig.RootCAs)
+		require.Equal(t, "", got.TLSConfig.ServerName)
+		require.Equal(t, uint16(tls.VersionTLS12), got.TLSConfig.MinVersion)
+	})
+
+	t.Run("ca file and server name", func(t *testing.T) {
+		got, err := LoadProxyConfiguration(map[interface{}]interface{}{"type": "https", "address": "10.0.0.1:3129", "caFile": caFile, "serverName": "proxy.corp"})
+		require.NoError(t, err)
+		require.NotNil(t, got.TLSConfig.RootCAs)
+		require.True(t, got.TLSConfig.RootCAs.Equal(server.Client().Transport.(*http.Transport).TLSClientConfig.RootCAs))
+		require.Equal(t, "proxy.corp", got.TLSConfig.ServerName)
+	})
+
+	t.Run("missing ca file", func(t *testing.T) {
+		_, err := LoadProxyConfiguration(map[interface{}]interface{}{"type": "https", "address": "proxy:3129", "caFile": filepath.Join(dir, "missing.pem")})
+		require.Error(t, err)
+	})
+
+	t.Run("ca file without certificates", func(t *testing.T) {
+		_, err := LoadProxyConfiguration(map[interface{}]interface{}{"type": "https", "address": "proxy:3129", "caFile": emptyFile})
+		require.Error(t, err)
+	})
+
+	t.Run("invalid server name", func(t *testing.T) {
+		_, err := LoadProxyConfiguration(map[interface{}]interface{}{"type": "https", "address": "proxy:3129", "serverName": 5})
+		require.Error(t, err)
+	})
+}
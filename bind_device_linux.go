@@ -0,0 +1,46 @@
+//go:build linux
+
+/*
+	Copyright NetFoundry Inc.
+
+	Licensed under the Apache License, Version 2.0 (the "License");
+	you may not use this file except in compliance with the License.
+	You may obtain a copy of the License at
+
+	https://www.apache.org/licenses/LICENSE-2.0
+
+	Unless required by applicable law or agreed to in writing, software
+	distributed under the License is distributed on an "AS IS" BASIS,
+	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+	See the License for the specific language governing permissions and
+	limitations under the License.
+*/
+
+package transport
+
+import (
+	"errors"
+	"fmt"
+	"syscall"
+)
+
+func checkBindToDeviceSupported() error {
+	return nil
+}
+
+func bindToDevice(c syscall.RawConn, device string) error {
+	var sockErr error
+	if err := c.Control(func(fd uintptr) {
+		sockErr = syscall.SetsockoptString(int(fd), syscall.SOL_SOCKET, syscall.SO_BINDTODEVICE, device)
+	}); err != nil {
+		return err
+	}
+
+	if errors.Is(sockErr, syscall.EPERM) {
+		return fmt.Errorf("unable to bind socket to device %s, the process lacks the CAP_NET_RAW capability: %w", device, sockErr)
+	}
+	if sockErr != nil {
+		return fmt.Errorf("unable to bind socket to device %s: %w", device, sockErr)
+	}
+	return nil
+}
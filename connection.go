@@ -18,19 +18,49 @@ package transport
 
 import (
 	"crypto/x509"
+	"fmt"
 	"net"
 )
 
 type ConnectionDetail struct {
-	Address string
-	InBound bool
-	Name    string
+	Address         string
+	InBound         bool
+	Name            string
+	PeerCredentials *PeerCredentials
+
+	// ResolvedAddress is the ip:port an outbound connection was established to. When a host name resolves to
+	// several addresses, this is the address which won the connection race. When connecting through a proxy, this
+	// is the address of the proxy server
+	ResolvedAddress string
+
+	// MultipathTCP is true if a tcp connection negotiated MPTCP, rather than falling back to plain tcp. See
+	// SocketOptions.MultipathTCP
+	MultipathTCP bool
+
+	// ProxiedBy is the ip:port of the load balancer which passed on an inbound connection using the PROXY protocol,
+	// in which case Address is the address of the client. See ProxyProtocolConfig
+	ProxiedBy string
+}
+
+// PeerCredentials identifies the process on the other end of a local (unix domain socket) connection, as reported
+// by the kernel at connect time
+type PeerCredentials struct {
+	Pid int32
+	Uid uint32
+	Gid uint32
+}
+
+func (pc *PeerCredentials) String() string {
+	return fmt.Sprintf("pid=%d uid=%d gid=%d", pc.Pid, pc.Uid, pc.Gid)
 }
 
 func (cd *ConnectionDetail) String() string {
 	out := ""
 	if cd.InBound {
 		out += cd.Address + " <-"
+		if len(cd.ProxiedBy) > 0 {
+			out += " (via " + cd.ProxiedBy + ")"
+		}
 		if len(cd.Name) > 0 {
 			out += " {" + cd.Name + "}"
 		}
@@ -17,69 +17,71 @@
 package transport
 
 import (
-	"fmt"
+	"context"
 	"net"
 	"time"
-
-	"github.com/pkg/errors"
 )
 
-// NewDialerWithLocalBinding creates a dialer and sets the local ip used for dialing
+// TimeoutContext returns a context which expires after the given timeout. As with net.Dialer.Timeout, a timeout
+// of zero means no timeout
+func TimeoutContext(timeout time.Duration) (context.Context, context.CancelFunc) {
+	if timeout > 0 {
+		return context.WithTimeout(context.Background(), timeout)
+	}
+	return context.WithCancel(context.Background())
+}
+
+// NewDialerWithLocalBinding creates a dialer and sets the local ip used for dialing. See
+// NewDialerWithLocalBindingForDestination
 func NewDialerWithLocalBinding(addressType string, timeout time.Duration, localBinding string) (*net.Dialer, error) {
+	return NewDialerWithLocalBindingForDestination(addressType, timeout, localBinding, nil)
+}
+
+// NewDialerWithLocalBindingForDestination creates a dialer and sets the local address used for dialing to the
+// given destination. If the binding names an interface, an address of the interface matching the family and scope
+// of the destination is used. See LocalBinding for the supported binding formats. If the binding has a port range,
+// the dialer uses the first port of the range; use BindLocalAddr to try each port of the range.
+func NewDialerWithLocalBindingForDestination(addressType string, timeout time.Duration, localBinding string, destination net.IP) (*net.Dialer, error) {
 	dialer := &net.Dialer{
 		Timeout: timeout,
 	}
 
-	ip, err := ResolveLocalBinding(localBinding)
+	binding, err := ParseLocalBinding(localBinding)
 	if err != nil {
 		return nil, err
 	}
 
-	if ip != nil {
-		switch addressType {
-		case "udp":
-			dialer.LocalAddr = &net.UDPAddr{
-				IP: ip,
-			}
-		case "tcp", "tls":
-			dialer.LocalAddr = &net.TCPAddr{
-				IP: ip,
-			}
-		default:
-			return nil, errors.New(fmt.Sprintf("Unsupported addressType: %s", addressType))
+	if binding != nil {
+		if dialer.LocalAddr, err = binding.LocalAddr(addressType, destination); err != nil {
+			return nil, err
 		}
+		dialer.Control = binding.Control(dialer.LocalAddr)
 	}
 
 	return dialer, nil
 }
 
+// ResolveLocalBinding returns the local ip to dial from for the given binding, preferring global IPv4 addresses.
+// Use ResolveLocalBindingForDestination when the destination is known
 func ResolveLocalBinding(localBinding string) (net.IP, error) {
-	if localBinding != "" {
-		iface, err := ResolveInterface(localBinding)
-
-		if err != nil {
-			return nil, err
-		}
-
-		addrs, err := iface.Addrs()
-
-		if err != nil {
-			return nil, err
-		}
-
-		if len(addrs) == 0 {
-			return nil, errors.New(fmt.Sprintf("no ip addresses assigned to interface %s", localBinding))
-		}
+	addr, err := ResolveLocalBindingForDestination(localBinding, nil)
+	if addr == nil {
+		return nil, err
+	}
+	return addr.IP, nil
+}
 
-		switch addr := addrs[0].(type) {
-		case *net.IPNet:
-			return addr.IP, nil
-		case *net.IPAddr:
-			return addr.IP, nil
-		default:
-			return nil, fmt.Errorf("unexpected address type %T on interface %s", addrs[0], localBinding)
-		}
+// ResolveLocalBindingForDestination returns the local address to dial the given destination from, matching the
+// family and scope of the destination. If localBinding is empty, nil is returned
+func ResolveLocalBindingForDestination(localBinding string, destination net.IP) (*net.IPAddr, error) {
+	binding, err := ParseLocalBinding(localBinding)
+	if binding == nil {
+		return nil, err
 	}
 
-	return nil, nil
+	ip, zone, err := binding.LocalIP(destination)
+	if err != nil {
+		return nil, err
+	}
+	return &net.IPAddr{IP: ip, Zone: zone}, nil
 }
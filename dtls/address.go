@@ -17,7 +17,7 @@
 package dtls
 
 import (
-	"io"
+	"context"
 	"math"
 	"net"
 	"strconv"
@@ -34,9 +34,13 @@ var _ transport.Address = &address{} // enforce that address implements transpor
 const Type = "dtls"
 
 type address struct {
-	net.UDPAddr
+	hostname string
+	port     uint16
 	original string
 	err      error
+
+	// inherited is set to the bind address of an inherited socket, such as fd:3, which can only be listened on
+	inherited string
 }
 
 func (a *address) Dial(name string, i *identity.TokenId, timeout time.Duration, tcfg transport.Configuration) (transport.Conn, error) {
@@ -47,11 +51,19 @@ func (a *address) DialWithLocalBinding(name string, localBinding string, i *iden
 	return DialWithLocalBinding(a, name, localBinding, i, timeout, tcfg)
 }
 
-func (a *address) Listen(name string, i *identity.TokenId, acceptF func(transport.Conn), tcfg transport.Configuration) (io.Closer, error) {
+func (a *address) DialContext(ctx context.Context, name string, i *identity.TokenId, tcfg transport.Configuration) (transport.Conn, error) {
+	return DialContext(ctx, a, name, i, tcfg)
+}
+
+func (a *address) DialWithLocalBindingContext(ctx context.Context, name string, localBinding string, i *identity.TokenId, tcfg transport.Configuration) (transport.Conn, error) {
+	return DialWithLocalBindingContext(ctx, a, name, localBinding, i, tcfg)
+}
+
+func (a *address) Listen(name string, i *identity.TokenId, acceptF func(transport.Conn), tcfg transport.Configuration) (transport.Listener, error) {
 	return Listen(a, name, i, tcfg, acceptF)
 }
 
-func (a *address) MustListen(name string, i *identity.TokenId, acceptF func(transport.Conn), tcfg transport.Configuration) io.Closer {
+func (a *address) MustListen(name string, i *identity.TokenId, acceptF func(transport.Conn), tcfg transport.Configuration) transport.Listener {
 	closer, err := a.Listen(name, i, acceptF, tcfg)
 	if err != nil {
 		panic(err)
@@ -73,13 +85,15 @@ func (a *address) withError(err error) (*address, error) {
 }
 
 func (a *address) Hostname() string {
-	return a.IP.String()
+	return a.hostname
 }
 
 func (a *address) Port() uint16 {
-	return uint16(a.UDPAddr.Port)
+	return a.port
 }
 
+// AddressParser parses dtls addresses. Host names are kept as is and are resolved each time the address is dialed
+// or listened on, so parsing never blocks on DNS
 type AddressParser struct{}
 
 func (ap AddressParser) Parse(s string) (transport.Address, error) {
@@ -90,6 +104,12 @@ func (ap AddressParser) Parse(s string) (transport.Address, error) {
 	addr := &address{
 		original: s,
 	}
+
+	if inherited, ok := transport.ParseInheritedAddress(s, Type); ok {
+		addr.inherited = inherited
+		return addr, nil
+	}
+
 	hostPort := s[len(Type+":"):]
 
 	host, portStr, err := net.SplitHostPort(hostPort)
@@ -106,21 +126,7 @@ func (ap AddressParser) Parse(s string) (transport.Address, error) {
 		return addr.withError(errors.Wrapf(err, "invalid port value %v", portStr))
 	}
 
-	ipAddr := net.ParseIP(host)
-	if ipAddr == nil {
-		ips, err := net.LookupHost(host)
-		if err != nil {
-			return addr.withError(errors.Wrapf(err, "unable to resolve host %v", host))
-		}
-		if len(ips) == 0 {
-			return addr.withError(errors.Wrapf(err, "no IPs found when resolving host %v", host))
-		}
-		ipAddr = net.ParseIP(ips[0])
-	}
-
-	addr.UDPAddr = net.UDPAddr{
-		IP:   ipAddr,
-		Port: port,
-	}
+	addr.hostname = host
+	addr.port = uint16(port)
 	return addr, nil
 }
@@ -19,6 +19,8 @@ func TestParseAndString(t *testing.T) {
 		{"ipv6 loopback", "dtls:[::1]:8080", "dtls:[::1]:8080", "::1", 8080, false},
 		{"ipv6 full", "dtls:[fe80::1]:443", "dtls:[fe80::1]:443", "fe80::1", 443, false},
 		{"ipv6 all zeros", "dtls:[::]:9090", "dtls:[::]:9090", "::", 9090, false},
+		{"hostname is not resolved", "dtls:ctrl.invalid:443", "dtls:ctrl.invalid:443", "ctrl.invalid", 443, false},
+		{"inherited fd", "dtls:fd:3", "dtls:fd:3", "", 0, false},
 		{"wrong prefix", "tcp:127.0.0.1:8080", "", "", 0, true},
 	}
 
@@ -40,36 +40,30 @@ func Dial(addr *address, name string, i *identity.TokenId, timeout time.Duration
 }
 
 func DialWithLocalBinding(addr *address, name, localBinding string, i *identity.TokenId, timeout time.Duration, tcfg transport.Configuration) (transport.Conn, error) {
+	ctx, cancelF := transport.TimeoutContext(timeout)
+	defer cancelF()
+	return DialWithLocalBindingContext(ctx, addr, name, localBinding, i, tcfg)
+}
+
+func DialContext(ctx context.Context, addr *address, name string, i *identity.TokenId, tcfg transport.Configuration) (transport.Conn, error) {
+	return DialWithLocalBindingContext(ctx, addr, name, "", i, tcfg)
+}
+
+func DialWithLocalBindingContext(ctx context.Context, addr *address, name, localBinding string, i *identity.TokenId, tcfg transport.Configuration) (transport.Conn, error) {
 	log := pfxlog.Logger()
 	log.WithField("address", addr.String()).Debug("dialing")
 
 	if addr.err != nil {
 		return nil, addr.err
 	}
-	ip, err := transport.ResolveLocalBinding(localBinding)
-	if err != nil {
-		return nil, err
-	}
-
-	var localAddr *net.UDPAddr
-	if ip != nil {
-		localAddr = &net.UDPAddr{IP: ip}
+	if addr.inherited != "" {
+		return nil, errors.Errorf("unable to dial %v, inherited sockets can only be listened on", addr)
 	}
-
-	udpConn, err := net.ListenUDP("udp", localAddr)
+	binding, err := transport.ParseLocalBinding(localBinding)
 	if err != nil {
 		return nil, err
 	}
 
-	closeUdpConn := true
-	defer func() {
-		if closeUdpConn {
-			if closeErr := udpConn.Close(); closeErr != nil {
-				log.WithError(closeErr).Error("error closing udp connection")
-			}
-		}
-	}()
-
 	writeBufferSize := DefaultBufferSize
 	bufferSize, found, err := tcfg.GetUIntValue("dtls", "writeBufferSize")
 	if err != nil {
@@ -79,10 +73,6 @@ func DialWithLocalBinding(addr *address, name, localBinding string, i *identity.
 		writeBufferSize = int(bufferSize)
 	}
 
-	if err = udpConn.SetWriteBuffer(writeBufferSize); err != nil {
-		return nil, fmt.Errorf("unable to set udp write buffer size to %d (%w)", writeBufferSize, err)
-	}
-
 	readBufferSize := DefaultBufferSize
 	bufferSize, found, err = tcfg.GetUIntValue("dtls", "readBufferSize")
 	if err != nil {
@@ -91,20 +81,30 @@ func DialWithLocalBinding(addr *address, name, localBinding string, i *identity.
 	if found {
 		readBufferSize = int(bufferSize)
 	}
-	if err = udpConn.SetReadBuffer(readBufferSize); err != nil {
-		return nil, fmt.Errorf("unable to set udp read buffer size to %d (%w)", readBufferSize, err)
+
+	// the host name is resolved on every dial, so that DNS changes are picked up by long-running processes
+	candidates, err := transport.ResolveCandidates(ctx, nil, "udp", addr.hostname)
+	if err != nil {
+		return nil, errors.Wrapf(err, "unable to resolve host %v", addr.hostname)
 	}
 
-	conn, err := dtls.ClientWithOptions(udpConn, &addr.UDPAddr,
-		dtls.WithCertificates(*i.Cert()),
-		dtls.WithRootCAs(i.CA()),
-	)
+	// udp has no connection setup, so the dtls handshake is what tells us whether an address is reachable
+	conn, err := transport.RaceCandidates(ctx, candidates, transport.DefaultConnectionAttemptDelay, func(ctx context.Context, ip net.IPAddr) (*dtls.Conn, error) {
+		dest := &net.UDPAddr{IP: ip.IP, Port: int(addr.port), Zone: ip.Zone}
+
+		udpConn, err := transport.BindLocalAddr(binding, "udp", ip.IP, func(localAddr net.Addr) (*net.UDPConn, error) {
+			return binding.ListenUDP(ctx, localAddr)
+		})
+		if err != nil {
+			return nil, err
+		}
+
+		return handshake(ctx, udpConn, dest, readBufferSize, writeBufferSize, i)
+	})
 	if err != nil {
 		return nil, err
 	}
 
-	// from here on, closing conn will also close udpConn
-	closeUdpConn = false
 	closeConn := true
 	defer func() {
 		if closeConn {
@@ -114,17 +114,6 @@ func DialWithLocalBinding(addr *address, name, localBinding string, i *identity.
 		}
 	}()
 
-	ctx := context.Background()
-	cancelF := func() {}
-	if timeout > 0 {
-		ctx, cancelF = context.WithTimeout(ctx, timeout)
-	}
-	err = conn.HandshakeContext(ctx)
-	cancelF()
-	if err != nil {
-		return nil, fmt.Errorf("dtls handshake error: %w", err)
-	}
-
 	certs, err := getPeerCerts(conn)
 	if err != nil {
 		return nil, errors.Wrap(err, "error getting peer certificates")
@@ -145,12 +134,54 @@ func DialWithLocalBinding(addr *address, name, localBinding string, i *identity.
 	closeConn = false
 	return &Connection{
 		detail: &transport.ConnectionDetail{
-			Address: addr.String(),
-			InBound: false,
-			Name:    name,
+			Address:         addr.String(),
+			InBound:         false,
+			Name:            name,
+			ResolvedAddress: conn.RemoteAddr().String(),
 		},
 		Conn:  conn,
 		certs: certs,
 		w:     w,
 	}, nil
 }
+
+func handshake(ctx context.Context, udpConn *net.UDPConn, dest *net.UDPAddr, readBufferSize, writeBufferSize int, i *identity.TokenId) (*dtls.Conn, error) {
+	log := pfxlog.Logger().WithField("dest", dest.String())
+
+	closeUdpConn := true
+	defer func() {
+		if closeUdpConn {
+			if closeErr := udpConn.Close(); closeErr != nil {
+				log.WithError(closeErr).Error("error closing udp connection")
+			}
+		}
+	}()
+
+	if err := udpConn.SetWriteBuffer(writeBufferSize); err != nil {
+		return nil, fmt.Errorf("unable to set udp write buffer size to %d (%w)", writeBufferSize, err)
+	}
+
+	if err := udpConn.SetReadBuffer(readBufferSize); err != nil {
+		return nil, fmt.Errorf("unable to set udp read buffer size to %d (%w)", readBufferSize, err)
+	}
+
+	conn, err := dtls.ClientWithOptions(udpConn, dest,
+		dtls.WithCertificates(*i.Cert()),
+		dtls.WithRootCAs(i.CA()),
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	// from here on, closing conn will also close udpConn
+	closeUdpConn = false
+
+	if err = conn.HandshakeContext(ctx); err != nil {
+		if closeErr := conn.Close(); closeErr != nil {
+			log.WithError(closeErr).Error("error closing dtls connection")
+		}
+		return nil, fmt.Errorf("dtls handshake error: %w", err)
+	}
+
+	return conn, nil
+}
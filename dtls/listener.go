@@ -21,6 +21,7 @@ import (
 	"crypto/tls"
 	"io"
 	"net"
+	"sync"
 	"sync/atomic"
 	"time"
 
@@ -28,13 +29,17 @@ import (
 	"github.com/openziti/identity"
 	"github.com/openziti/transport/v2"
 	"github.com/openziti/transport/v2/shaper"
+	"github.com/openziti/transport/v2/udpconn"
 	"github.com/pion/dtls/v3"
+	dtlsnet "github.com/pion/dtls/v3/pkg/net"
+	"github.com/pion/dtls/v3/pkg/protocol"
+	"github.com/pion/dtls/v3/pkg/protocol/recordlayer"
 	"github.com/sirupsen/logrus"
 )
 
 const DefaultHandshakeTimeout = 30 * time.Second
 
-func Listen(addr *address, name string, i *identity.TokenId, tcfg transport.Configuration, acceptF func(transport.Conn)) (io.Closer, error) {
+func Listen(addr *address, name string, i *identity.TokenId, tcfg transport.Configuration, acceptF func(transport.Conn)) (transport.Listener, error) {
 	if addr.err != nil {
 		return nil, addr.err
 	}
@@ -56,12 +61,30 @@ func Listen(addr *address, name string, i *identity.TokenId, tcfg transport.Conf
 		certs = append(certs, *ptrCert)
 	}
 
-	listener, err := dtls.ListenWithOptions("udp", &addr.UDPAddr,
+	opts := []dtls.ServerOption{
 		dtls.WithCertificates(certs...),
 		dtls.WithClientAuth(dtls.RequireAnyClientCert),
 		dtls.WithRootCAs(i.CA()),
-	)
+	}
+
+	// the udp socket is managed by udpconn rather than by pion, so that it can be handed to another process
+	var inner net.Listener
+	if addr.inherited != "" {
+		inner, err = udpconn.ListenInherited(addr.inherited, handshakePolicy{}, udpconn.NewNoExpirationPolicy())
+	} else {
+		var bindAddr *net.UDPAddr
+		if bindAddr, err = transport.ResolveUDPAddress(context.Background(), addr.hostname, addr.port); err != nil {
+			return nil, err
+		}
+		inner, err = udpconn.ListenWithPolicies("udp", bindAddr, handshakePolicy{}, udpconn.NewNoExpirationPolicy())
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	listener, err := dtls.NewListenerWithOptions(dtlsnet.PacketListenerFromListener(inner), opts...)
 	if err != nil {
+		_ = inner.Close()
 		return nil, err
 	}
 
@@ -88,18 +111,41 @@ func Listen(addr *address, name string, i *identity.TokenId, tcfg transport.Conf
 		wf:       wf,
 	}
 
+	result.acceptLoops.Add(1)
 	go result.acceptLoop(log)
 
 	return result, nil
 }
 
+// handshakePolicy only creates connections for remote addresses which start with a dtls handshake record, so that
+// stray packets don't tie up the accept loop in a handshake
+type handshakePolicy struct{}
+
+func (self handshakePolicy) NewConnection(uint32) udpconn.NewConnAcceptResult {
+	return udpconn.Allow
+}
+
+func (self handshakePolicy) AcceptFirstPacket(payload []byte) bool {
+	pkts, err := recordlayer.UnpackDatagram(payload)
+	if err != nil || len(pkts) < 1 {
+		return false
+	}
+	header := &recordlayer.Header{}
+	if err = header.Unmarshal(pkts[0]); err != nil {
+		return false
+	}
+	return header.ContentType == protocol.ContentTypeHandshake
+}
+
 type acceptor struct {
-	name     string
-	listener net.Listener
-	acceptF  func(transport.Conn)
-	closed   atomic.Bool
-	timeout  time.Duration
-	wf       func(io.Writer) io.Writer
+	transport.ListenerCounters
+	name        string
+	listener    net.Listener
+	acceptF     func(transport.Conn)
+	closed      atomic.Bool
+	timeout     time.Duration
+	wf          func(io.Writer) io.Writer
+	acceptLoops sync.WaitGroup
 }
 
 func (self *acceptor) Close() error {
@@ -109,7 +155,21 @@ func (self *acceptor) Close() error {
 	return nil
 }
 
+// Shutdown stops accepting new connections and waits for the accept loop to exit. Handshakes are run by the accept
+// loop, so one which is in progress finishes or times out first
+func (self *acceptor) Shutdown(ctx context.Context) error {
+	if err := self.Close(); err != nil {
+		return err
+	}
+	return transport.WaitForShutdown(ctx, &self.acceptLoops)
+}
+
+func (self *acceptor) Addr() string {
+	return Type + ":" + self.listener.Addr().String()
+}
+
 func (self *acceptor) acceptLoop(log *logrus.Entry) {
+	defer self.acceptLoops.Done()
 	defer log.Info("exited")
 
 	for !self.closed.Load() {
@@ -137,6 +197,7 @@ func (self *acceptor) acceptLoop(log *logrus.Entry) {
 			if err = conn.Close(); err != nil {
 				log.WithError(err).Error("error closing connection")
 			}
+			self.IncrementFailed()
 			continue
 		}
 
@@ -146,6 +207,7 @@ func (self *acceptor) acceptLoop(log *logrus.Entry) {
 			if err = conn.Close(); err != nil {
 				log.WithError(err).Error("error closing connection")
 			}
+			self.IncrementFailed()
 			continue
 		}
 
@@ -159,6 +221,7 @@ func (self *acceptor) acceptLoop(log *logrus.Entry) {
 			Conn:  conn,
 			w:     self.wf(conn),
 		}
+		self.IncrementAccepted()
 		self.acceptF(connection)
 	}
 }
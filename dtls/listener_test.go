@@ -0,0 +1,168 @@
+package dtls
+
+import (
+	"testing"
+	"time"
+
+	"github.com/openziti/transport/v2"
+	"github.com/openziti/transport/v2/internal/testutil"
+	"github.com/stretchr/testify/require"
+)
+
+func TestListenAndDial(t *testing.T) {
+	req := require.New(t)
+
+	serverId, clientId := testutil.NewIdentities(t)
+
+	bindAddr, err := AddressParser{}.Parse("dtls:127.0.0.1:0")
+	req.NoError(err)
+
+	accepted := make(chan transport.Conn, 1)
+	listener, err := bindAddr.Listen("test", serverId, func(conn transport.Conn) {
+		accepted <- conn
+	}, nil)
+	req.NoError(err)
+	defer func() { _ = listener.Close() }()
+
+	addr, err := AddressParser{}.Parse(listener.Addr())
+	req.NoError(err)
+	req.NotZero(addr.(*address).port)
+
+	conn, err := addr.Dial("test", clientId, 5*time.Second, nil)
+	req.NoError(err)
+	defer func() { _ = conn.Close() }()
+
+	var inbound transport.Conn
+	select {
+	case inbound = <-accepted:
+	case <-time.After(5 * time.Second):
+		req.Fail("connection not accepted")
+	}
+	defer func() { _ = inbound.Close() }()
+
+	req.True(inbound.Detail().InBound)
+	req.Equal(transport.ListenerStats{Accepted: 1}, listener.Stats())
+	req.Len(inbound.PeerCertificates(), 1)
+	req.Equal("testClient", inbound.PeerCertificates()[0].Subject.CommonName)
+
+	_, err = conn.Write([]byte("ping"))
+	req.NoError(err)
+
+	buf := make([]byte, 4)
+	_, err = inbound.Read(buf)
+	req.NoError(err)
+	req.Equal("ping", string(buf))
+
+	_, err = inbound.Write([]byte("pong"))
+	req.NoError(err)
+	_, err = conn.Read(buf)
+	req.NoError(err)
+	req.Equal("pong", string(buf))
+}
+
+func TestStrayPacketsDoNotStartHandshake(t *testing.T) {
+	req := require.New(t)
+
+	policy := handshakePolicy{}
+	req.False(policy.AcceptFirstPacket([]byte("hello")))
+	req.False(policy.AcceptFirstPacket(nil))
+
+	// a record header for an application data record, which can't start a connection
+	req.False(policy.AcceptFirstPacket([]byte{23, 0xfe, 0xfd, 0, 0, 0, 0, 0, 0, 0, 1, 0, 1, 0}))
+	req.True(policy.AcceptFirstPacket([]byte{22, 0xfe, 0xfd, 0, 0, 0, 0, 0, 0, 0, 1, 0, 1, 0}))
+}
+
+func TestConcurrentDials(t *testing.T) {
+	req := require.New(t)
+
+	serverId, clientId := testutil.NewIdentities(t)
+
+	bindAddr, err := AddressParser{}.Parse("dtls:127.0.0.1:0")
+	req.NoError(err)
+
+	const dialers = 8
+
+	accepted := make(chan transport.Conn, dialers)
+	listener, err := bindAddr.Listen("test", serverId, func(conn transport.Conn) {
+		accepted <- conn
+	}, nil)
+	req.NoError(err)
+	defer func() { _ = listener.Close() }()
+
+	addr, err := AddressParser{}.Parse(listener.Addr())
+	req.NoError(err)
+
+	errs := make(chan error, dialers)
+	for range dialers {
+		go func() {
+			conn, err := addr.Dial("test", clientId, 5*time.Second, nil)
+			if err == nil {
+				t.Cleanup(func() { _ = conn.Close() })
+			}
+			errs <- err
+		}()
+	}
+
+	for range dialers {
+		req.NoError(<-errs)
+	}
+
+	for range dialers {
+		select {
+		case conn := <-accepted:
+			_ = conn.Close()
+		case <-time.After(5 * time.Second):
+			req.Fail("connection not accepted")
+		}
+	}
+	req.Equal(transport.ListenerStats{Accepted: dialers}, listener.Stats())
+}
+
+func TestConnSurvivesListenerClose(t *testing.T) {
+	req := require.New(t)
+
+	serverId, clientId := testutil.NewIdentities(t)
+
+	bindAddr, err := AddressParser{}.Parse("dtls:127.0.0.1:0")
+	req.NoError(err)
+
+	accepted := make(chan transport.Conn, 1)
+	listener, err := bindAddr.Listen("test", serverId, func(conn transport.Conn) {
+		accepted <- conn
+	}, nil)
+	req.NoError(err)
+	defer func() { _ = listener.Close() }()
+
+	addr, err := AddressParser{}.Parse(listener.Addr())
+	req.NoError(err)
+
+	conn, err := addr.Dial("test", clientId, 5*time.Second, nil)
+	req.NoError(err)
+	defer func() { _ = conn.Close() }()
+
+	var inbound transport.Conn
+	select {
+	case inbound = <-accepted:
+	case <-time.After(5 * time.Second):
+		req.Fail("connection not accepted")
+	}
+	defer func() { _ = inbound.Close() }()
+
+	req.NoError(listener.Close())
+
+	_, err = conn.Write([]byte("ping"))
+	req.NoError(err)
+
+	buf := make([]byte, 4)
+	req.NoError(inbound.SetReadDeadline(time.Now().Add(5 * time.Second)))
+	_, err = inbound.Read(buf)
+	req.NoError(err)
+	req.Equal("ping", string(buf))
+
+	_, err = inbound.Write([]byte("pong"))
+	req.NoError(err)
+	req.NoError(conn.SetReadDeadline(time.Now().Add(5 * time.Second)))
+	_, err = conn.Read(buf)
+	req.NoError(err)
+	req.Equal("pong", string(buf))
+}
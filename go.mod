@@ -10,9 +10,11 @@ require (
 	github.com/openziti/identity v1.0.127
 	github.com/pion/dtls/v3 v3.1.2
 	github.com/pkg/errors v0.9.1
+	github.com/quic-go/quic-go v0.59.0
 	github.com/sirupsen/logrus v1.9.4
 	github.com/stretchr/testify v1.11.1
 	golang.org/x/net v0.52.0
+	golang.org/x/sys v0.42.0
 	nhooyr.io/websocket v1.8.17
 )
 
@@ -24,7 +26,6 @@ require (
 	github.com/nxadm/tail v1.4.8 // indirect
 	github.com/pion/logging v0.2.4 // indirect
 	golang.org/x/crypto v0.49.0 // indirect
-	golang.org/x/sys v0.42.0 // indirect
 	golang.org/x/term v0.41.0 // indirect
 )
 
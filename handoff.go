@@ -0,0 +1,166 @@
+/*
+	Copyright NetFoundry Inc.
+
+	Licensed under the Apache License, Version 2.0 (the "License");
+	you may not use this file except in compliance with the License.
+	You may obtain a copy of the License at
+
+	https://www.apache.org/licenses/LICENSE-2.0
+
+	Unless required by applicable law or agreed to in writing, software
+	distributed under the License is distributed on an "AS IS" BASIS,
+	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+	See the License for the specific language governing permissions and
+	limitations under the License.
+*/
+
+package transport
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"sync"
+	"syscall"
+)
+
+// handoffSocket is an active listening socket, along with the network and bind address it was listened on, which
+// the process adopting it uses to match it to its own listeners
+type handoffSocket struct {
+	network string
+	address string
+	sock    syscall.Conn
+}
+
+var handoff = struct {
+	sync.Mutex
+	active  []*handoffSocket
+	adopted map[string][]*os.File
+}{
+	adopted: map[string][]*os.File{},
+}
+
+func handoffKey(network, address string) string {
+	return network + ":" + address
+}
+
+// RegisterListenerSocket adds the socket of an active listener to the sockets sent by ExportListeners. The returned
+// function removes it again, and should be called when the listener is closed. The socket is usually a
+// *net.TCPListener or *net.UDPConn
+func RegisterListenerSocket(network, address string, sock syscall.Conn) func() {
+	entry := &handoffSocket{
+		network: network,
+		address: address,
+		sock:    sock,
+	}
+
+	handoff.Lock()
+	handoff.active = append(handoff.active, entry)
+	handoff.Unlock()
+
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			handoff.Lock()
+			defer handoff.Unlock()
+			for i, s := range handoff.active {
+				if s == entry {
+					handoff.active = append(handoff.active[:i], handoff.active[i+1:]...)
+					return
+				}
+			}
+		})
+	}
+}
+
+// AdoptedListeners returns listeners for the stream sockets adopted from another process for the given network and
+// bind address, if there are any. Each adopted socket is only returned once
+func AdoptedListeners(network, address string) ([]net.Listener, error) {
+	var result []net.Listener
+	for _, f := range takeAdopted(network, address) {
+		listener, err := net.FileListener(f)
+		_ = f.Close()
+		if err != nil {
+			for _, l := range result {
+				_ = l.Close()
+			}
+			return nil, fmt.Errorf("unable to listen on socket adopted for %s: %w", handoffKey(network, address), err)
+		}
+		result = append(result, listener)
+	}
+	return result, nil
+}
+
+// AdoptedUDPConn returns the udp socket adopted from another process for the given network and bind address, or nil
+// if there isn't one
+func AdoptedUDPConn(network, address string) (*net.UDPConn, error) {
+	files := takeAdopted(network, address)
+	if len(files) == 0 {
+		return nil, nil
+	}
+
+	for _, f := range files[1:] {
+		_ = f.Close()
+	}
+
+	conn, err := net.FilePacketConn(files[0])
+	_ = files[0].Close()
+	if err != nil {
+		return nil, fmt.Errorf("unable to use socket adopted for %s: %w", handoffKey(network, address), err)
+	}
+
+	udpConn, ok := conn.(*net.UDPConn)
+	if !ok {
+		_ = conn.Close()
+		return nil, fmt.Errorf("socket adopted for %s is a %s socket, not udp", handoffKey(network, address), conn.LocalAddr().Network())
+	}
+	return udpConn, nil
+}
+
+func takeAdopted(network, address string) []*os.File {
+	key := handoffKey(network, address)
+
+	handoff.Lock()
+	defer handoff.Unlock()
+
+	files := handoff.adopted[key]
+	delete(handoff.adopted, key)
+	return files
+}
+
+func addAdopted(network, address string, f *os.File) {
+	key := handoffKey(network, address)
+
+	handoff.Lock()
+	defer handoff.Unlock()
+
+	handoff.adopted[key] = append(handoff.adopted[key], f)
+}
+
+func activeHandoffSockets() []*handoffSocket {
+	handoff.Lock()
+	defer handoff.Unlock()
+	return append([]*handoffSocket(nil), handoff.active...)
+}
+
+// registeredListener removes its socket from the handoff registry when closed
+type registeredListener struct {
+	net.Listener
+	unregister func()
+}
+
+func (self *registeredListener) Close() error {
+	self.unregister()
+	return self.Listener.Close()
+}
+
+// registerListener registers the socket of a listener with the handoff registry, if it can be handed over
+func registerListener(network, address string, listener net.Listener) net.Listener {
+	if sock, ok := listener.(syscall.Conn); ok {
+		return &registeredListener{
+			Listener:   listener,
+			unregister: RegisterListenerSocket(network, address, sock),
+		}
+	}
+	return listener
+}
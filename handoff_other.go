@@ -0,0 +1,36 @@
+//go:build !unix
+
+/*
+	Copyright NetFoundry Inc.
+
+	Licensed under the Apache License, Version 2.0 (the "License");
+	you may not use this file except in compliance with the License.
+	You may obtain a copy of the License at
+
+	https://www.apache.org/licenses/LICENSE-2.0
+
+	Unless required by applicable law or agreed to in writing, software
+	distributed under the License is distributed on an "AS IS" BASIS,
+	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+	See the License for the specific language governing permissions and
+	limitations under the License.
+*/
+
+package transport
+
+import (
+	"errors"
+	"net"
+)
+
+var errHandoffNotSupported = errors.New("listener handoff is not supported on this platform")
+
+// ExportListeners is not supported on this platform
+func ExportListeners(*net.UnixConn) (int, error) {
+	return 0, errHandoffNotSupported
+}
+
+// AdoptListeners is not supported on this platform
+func AdoptListeners(*net.UnixConn) (int, error) {
+	return 0, errHandoffNotSupported
+}
@@ -0,0 +1,174 @@
+//go:build unix
+
+/*
+	Copyright NetFoundry Inc.
+
+	Licensed under the Apache License, Version 2.0 (the "License");
+	you may not use this file except in compliance with the License.
+	You may obtain a copy of the License at
+
+	https://www.apache.org/licenses/LICENSE-2.0
+
+	Unless required by applicable law or agreed to in writing, software
+	distributed under the License is distributed on an "AS IS" BASIS,
+	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+	See the License for the specific language governing permissions and
+	limitations under the License.
+*/
+
+package transport
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"strings"
+	"syscall"
+)
+
+// maxHandoffKeyLen limits the size of the network and bind address sent along with each socket
+const maxHandoffKeyLen = 4096
+
+// ExportListeners sends the sockets of all active tcp and udp listeners to another process over a unix domain socket
+// connection, using SCM_RIGHTS. The listeners in this process keep running, so that connections are accepted by both
+// processes until this one shuts its listeners down. Returns the number of sockets which were sent
+//
+// Each socket is sent as a message holding the length and value of its network and bind address, with the socket
+// attached. A message with a zero length marks the end.
+func ExportListeners(conn *net.UnixConn) (int, error) {
+	sockets := activeHandoffSockets()
+	for _, s := range sockets {
+		if err := sendHandoffSocket(conn, s); err != nil {
+			return 0, err
+		}
+	}
+
+	if _, err := conn.Write(make([]byte, 2)); err != nil {
+		return 0, fmt.Errorf("unable to complete listener handoff: %w", err)
+	}
+
+	return len(sockets), nil
+}
+
+func sendHandoffSocket(conn *net.UnixConn, s *handoffSocket) error {
+	key := handoffKey(s.network, s.address)
+
+	rawConn, err := s.sock.SyscallConn()
+	if err != nil {
+		return fmt.Errorf("unable to get socket of listener for %s: %w", key, err)
+	}
+
+	msg := binary.BigEndian.AppendUint16(nil, uint16(len(key)))
+	msg = append(msg, key...)
+
+	// the descriptor is passed from within Control, rather than from a dup made by File, since getting the
+	// descriptor of an os.File switches the shared socket to blocking mode
+	var sendErr error
+	if err = rawConn.Control(func(fd uintptr) {
+		_, _, sendErr = conn.WriteMsgUnix(msg, syscall.UnixRights(int(fd)), nil)
+	}); err == nil {
+		err = sendErr
+	}
+	if err != nil {
+		return fmt.Errorf("unable to send socket of listener for %s: %w", key, err)
+	}
+	return nil
+}
+
+// AdoptListeners receives the sockets sent by ExportListeners in another process. Listening on the same network and
+// bind address in this process, with the same configuration, then uses the adopted sockets instead of binding new
+// ones. Returns the number of sockets which were adopted
+func AdoptListeners(conn *net.UnixConn) (int, error) {
+	count := 0
+	for {
+		network, address, f, err := receiveHandoffSocket(conn)
+		if err != nil {
+			return count, err
+		}
+		if f == nil {
+			return count, nil
+		}
+		addAdopted(network, address, f)
+		count++
+	}
+}
+
+func receiveHandoffSocket(conn *net.UnixConn) (string, string, *os.File, error) {
+	header := make([]byte, 2)
+	oob := make([]byte, syscall.CmsgSpace(4))
+
+	n, oobn, _, _, err := conn.ReadMsgUnix(header, oob)
+	if err != nil {
+		return "", "", nil, fmt.Errorf("unable to receive listener socket: %w", err)
+	}
+
+	var f *os.File
+	if oobn > 0 {
+		if f, err = parseHandoffRights(oob[:oobn]); err != nil {
+			return "", "", nil, err
+		}
+	}
+
+	fail := func(err error) (string, string, *os.File, error) {
+		if f != nil {
+			_ = f.Close()
+		}
+		return "", "", nil, err
+	}
+
+	if _, err = io.ReadFull(conn, header[n:]); err != nil {
+		return fail(fmt.Errorf("unable to receive listener socket: %w", err))
+	}
+
+	keyLen := int(binary.BigEndian.Uint16(header))
+	if keyLen == 0 {
+		return fail(nil)
+	}
+	if keyLen > maxHandoffKeyLen {
+		return fail(fmt.Errorf("invalid listener socket address length %d", keyLen))
+	}
+
+	key := make([]byte, keyLen)
+	if _, err = io.ReadFull(conn, key); err != nil {
+		return fail(fmt.Errorf("unable to receive listener socket: %w", err))
+	}
+
+	if f == nil {
+		return "", "", nil, fmt.Errorf("no socket received for listener %s", key)
+	}
+
+	network, address, found := strings.Cut(string(key), ":")
+	if !found {
+		return fail(fmt.Errorf("invalid listener socket address '%s'", key))
+	}
+
+	return network, address, f, nil
+}
+
+func parseHandoffRights(oob []byte) (*os.File, error) {
+	msgs, err := syscall.ParseSocketControlMessage(oob)
+	if err != nil {
+		return nil, fmt.Errorf("unable to parse received listener socket: %w", err)
+	}
+
+	var fds []int
+	for _, msg := range msgs {
+		rights, err := syscall.ParseUnixRights(&msg)
+		if err != nil {
+			continue
+		}
+		fds = append(fds, rights...)
+	}
+
+	if len(fds) != 1 {
+		for _, fd := range fds {
+			_ = syscall.Close(fd)
+		}
+		return nil, fmt.Errorf("expected one listener socket, received %d", len(fds))
+	}
+
+	syscall.CloseOnExec(fds[0])
+	return os.NewFile(uintptr(fds[0]), "adopted listener socket"), nil
+}
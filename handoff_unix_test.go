@@ -0,0 +1,109 @@
+//go:build unix
+
+package transport
+
+import (
+	"context"
+	"net"
+	"os"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func newUnixConnPair(t *testing.T) (*net.UnixConn, *net.UnixConn) {
+	fds, err := syscall.Socketpair(syscall.AF_UNIX, syscall.SOCK_STREAM, 0)
+	require.NoError(t, err)
+
+	var result []*net.UnixConn
+	for _, fd := range fds {
+		f := os.NewFile(uintptr(fd), "handoff")
+		conn, err := net.FileConn(f)
+		_ = f.Close()
+		require.NoError(t, err)
+		t.Cleanup(func() { _ = conn.Close() })
+		result = append(result, conn.(*net.UnixConn))
+	}
+	return result[0], result[1]
+}
+
+func TestExportAndAdoptListeners(t *testing.T) {
+	req := require.New(t)
+
+	listeners, err := DefaultSocketOptions().Listen(context.Background(), "tcp", "127.0.0.1:0")
+	req.NoError(err)
+	req.Len(listeners, 1)
+	original := listeners[0]
+	defer func() { _ = original.Close() }()
+
+	udpSocket, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	req.NoError(err)
+	defer func() { _ = udpSocket.Close() }()
+	unregister := RegisterListenerSocket("udp", "127.0.0.1:0", udpSocket)
+	defer unregister()
+
+	parent, child := newUnixConnPair(t)
+
+	exported := make(chan error, 1)
+	go func() {
+		_, err := ExportListeners(parent)
+		exported <- err
+	}()
+
+	adopted, err := AdoptListeners(child)
+	req.NoError(err)
+	req.NoError(<-exported)
+	req.Equal(2, adopted)
+
+	// once the original listener is closed, connections are accepted by the adopted one
+	req.NoError(original.Close())
+
+	listeners, err = DefaultSocketOptions().Listen(context.Background(), "tcp", "127.0.0.1:0")
+	req.NoError(err)
+	req.Len(listeners, 1)
+	defer func() { _ = CloseListeners(listeners) }()
+	req.Equal(original.Addr().String(), listeners[0].Addr().String())
+
+	accepted := make(chan net.Conn, 1)
+	go func() {
+		if conn, err := listeners[0].Accept(); err == nil {
+			accepted <- conn
+		}
+	}()
+
+	conn, err := net.DialTimeout("tcp", original.Addr().String(), time.Second)
+	req.NoError(err)
+	defer func() { _ = conn.Close() }()
+
+	select {
+	case inbound := <-accepted:
+		_ = inbound.Close()
+	case <-time.After(5 * time.Second):
+		req.Fail("connection not accepted by adopted listener")
+	}
+
+	adoptedUdp, err := AdoptedUDPConn("udp", "127.0.0.1:0")
+	req.NoError(err)
+	req.NotNil(adoptedUdp)
+	defer func() { _ = adoptedUdp.Close() }()
+	req.Equal(udpSocket.LocalAddr().String(), adoptedUdp.LocalAddr().String())
+
+	// adopted sockets are only used once
+	again, err := AdoptedUDPConn("udp", "127.0.0.1:0")
+	req.NoError(err)
+	req.Nil(again)
+}
+
+func TestClosedListenersAreNotExported(t *testing.T) {
+	req := require.New(t)
+
+	listeners, err := DefaultSocketOptions().Listen(context.Background(), "tcp", "127.0.0.1:0")
+	req.NoError(err)
+	req.NoError(CloseListeners(listeners))
+
+	for _, s := range activeHandoffSockets() {
+		req.NotEqual(handoffKey("tcp", "127.0.0.1:0"), handoffKey(s.network, s.address))
+	}
+}
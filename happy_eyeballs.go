@@ -0,0 +1,261 @@
+/*
+	Copyright NetFoundry Inc.
+
+	Licensed under the Apache License, Version 2.0 (the "License");
+	you may not use this file except in compliance with the License.
+	You may obtain a copy of the License at
+
+	https://www.apache.org/licenses/LICENSE-2.0
+
+	Unless required by applicable law or agreed to in writing, software
+	distributed under the License is distributed on an "AS IS" BASIS,
+	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+	See the License for the specific language governing permissions and
+	limitations under the License.
+*/
+
+package transport
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+	"time"
+
+	"github.com/michaelquigley/pfxlog"
+)
+
+// DefaultConnectionAttemptDelay is the time to wait for a connection attempt before starting an attempt to the next
+// candidate address, as recommended by RFC 8305
+const DefaultConnectionAttemptDelay = 250 * time.Millisecond
+
+// ResolveCandidates looks up the addresses of host, keeping only those matching the family of the given network
+// (e.g. tcp4 or udp6), and returns them in the order in which connections should be attempted. If host is an IP
+// address, it is returned as the only candidate. If resolver is nil, the resolver set with SetResolver is used
+func ResolveCandidates(ctx context.Context, resolver Resolver, network, host string) ([]net.IPAddr, error) {
+	var ips []net.IPAddr
+	if ip, zone, _ := strings.Cut(host, "%"); net.ParseIP(ip) != nil {
+		ips = []net.IPAddr{{IP: net.ParseIP(ip), Zone: zone}}
+	} else {
+		if resolver == nil {
+			resolver = GetResolver()
+		}
+		var err error
+		if ips, err = resolver.LookupIPAddr(ctx, host); err != nil {
+			return nil, err
+		}
+	}
+
+	var result []net.IPAddr
+	for _, ip := range ips {
+		isIPv4 := ip.IP.To4() != nil
+		if (strings.HasSuffix(network, "4") && !isIPv4) || (strings.HasSuffix(network, "6") && isIPv4) {
+			continue
+		}
+		result = append(result, ip)
+	}
+
+	if len(result) == 0 {
+		return nil, fmt.Errorf("no %s addresses found for host %s", network, host)
+	}
+
+	return SortCandidates(result), nil
+}
+
+// SortCandidates orders addresses for connection attempts as described in RFC 8305, section 4. Addresses are
+// interleaved by family, starting with IPv6, while keeping the order of addresses within each family
+func SortCandidates(ips []net.IPAddr) []net.IPAddr {
+	var ipv4, ipv6 []net.IPAddr
+	for _, ip := range ips {
+		if ip.IP.To4() != nil {
+			ipv4 = append(ipv4, ip)
+		} else {
+			ipv6 = append(ipv6, ip)
+		}
+	}
+
+	result := make([]net.IPAddr, 0, len(ips))
+	for i := 0; i < len(ipv4) || i < len(ipv6); i++ {
+		if i < len(ipv6) {
+			result = append(result, ipv6[i])
+		}
+		if i < len(ipv4) {
+			result = append(result, ipv4[i])
+		}
+	}
+	return result
+}
+
+// RaceCandidates attempts connections to the candidate addresses in order, starting the next attempt when the
+// previous one fails or hasn't succeeded within delay, while letting earlier attempts continue. The first successful
+// connection is returned. Outstanding attempts are cancelled, and any connections they still establish are closed.
+// If all attempts fail, the returned error includes the error of each attempt.
+func RaceCandidates[T io.Closer](ctx context.Context, candidates []net.IPAddr, delay time.Duration, attempt func(ctx context.Context, ip net.IPAddr) (T, error)) (T, error) {
+	var zero T
+
+	if len(candidates) == 0 {
+		return zero, errors.New("no addresses to connect to")
+	}
+
+	if len(candidates) == 1 {
+		return attempt(ctx, candidates[0])
+	}
+
+	if delay <= 0 {
+		delay = DefaultConnectionAttemptDelay
+	}
+
+	ctx, cancelF := context.WithCancel(ctx)
+	defer cancelF()
+
+	type result struct {
+		conn T
+		ip   net.IPAddr
+		err  error
+	}
+
+	results := make(chan result, len(candidates))
+	next := 0
+	pending := 0
+
+	startNext := func() {
+		ip := candidates[next]
+		next++
+		pending++
+		go func() {
+			conn, err := attempt(ctx, ip)
+			results <- result{conn: conn, ip: ip, err: err}
+		}()
+	}
+
+	startNext()
+
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+
+	var errs []error
+	for pending > 0 {
+		select {
+		case <-timer.C:
+			if next < len(candidates) && ctx.Err() == nil {
+				startNext()
+				timer.Reset(delay)
+			}
+		case r := <-results:
+			pending--
+			if r.err == nil {
+				cancelF()
+				go func(pending int) {
+					for ; pending > 0; pending-- {
+						if late := <-results; late.err == nil {
+							_ = late.conn.Close()
+						}
+					}
+				}(pending)
+				return r.conn, nil
+			}
+
+			pfxlog.Logger().WithError(r.err).Debugf("connection attempt to %s failed", r.ip.String())
+			errs = append(errs, fmt.Errorf("%s: %w", r.ip.String(), r.err))
+
+			if next < len(candidates) && ctx.Err() == nil {
+				startNext()
+				timer.Reset(delay)
+			}
+		}
+	}
+
+	return zero, fmt.Errorf("unable to connect to any of %d addresses: %w", len(candidates), errors.Join(errs...))
+}
+
+// HappyEyeballsDialer connects to host:port destinations by racing connection attempts to the resolved addresses of
+// the host, as described in RFC 8305. Dialer is used for the individual attempts, and may be used to set the timeout
+// of each attempt. If LocalBinding is set, each attempt is made from a local address matching the candidate address,
+// trying the ports of the binding's port range, if any. If SocketOptions is set, they are applied to each attempt.
+type HappyEyeballsDialer struct {
+	Dialer        *net.Dialer
+	Resolver      Resolver
+	Delay         time.Duration
+	LocalBinding  *LocalBinding
+	SocketOptions *SocketOptions
+}
+
+// NewHappyEyeballsDialer returns a HappyEyeballsDialer using the given dialer for the individual connection attempts
+func NewHappyEyeballsDialer(dialer *net.Dialer) *HappyEyeballsDialer {
+	return &HappyEyeballsDialer{
+		Dialer: dialer,
+		Delay:  DefaultConnectionAttemptDelay,
+	}
+}
+
+func (self *HappyEyeballsDialer) Dial(network, address string) (net.Conn, error) {
+	return self.DialContext(context.Background(), network, address)
+}
+
+func (self *HappyEyeballsDialer) DialContext(ctx context.Context, network, address string) (net.Conn, error) {
+	host, port, err := net.SplitHostPort(address)
+	if err != nil {
+		return nil, err
+	}
+
+	dialer := self.Dialer
+	if dialer == nil {
+		dialer = &net.Dialer{}
+	}
+
+	if dialer.Timeout > 0 {
+		var cancelF context.CancelFunc
+		ctx, cancelF = context.WithTimeout(ctx, dialer.Timeout)
+		defer cancelF()
+	}
+
+	candidates, err := ResolveCandidates(ctx, self.Resolver, network, host)
+	if err != nil {
+		return nil, &net.OpError{Op: "dial", Net: network, Err: err}
+	}
+
+	return RaceCandidates(ctx, candidates, self.Delay, func(ctx context.Context, ip net.IPAddr) (net.Conn, error) {
+		destination := net.JoinHostPort(ip.String(), port)
+		if self.LocalBinding == nil {
+			return self.dial(ctx, dialer, nil, network, destination)
+		}
+
+		return BindLocalAddr(self.LocalBinding, network, ip.IP, func(localAddr net.Addr) (net.Conn, error) {
+			return self.dial(ctx, dialer, localAddr, network, destination)
+		})
+	})
+}
+
+// dial makes a single connection attempt from the given local address, which may be nil. The socket options of the
+// local binding and the configured socket options are applied on top of any set by the dialer
+func (self *HappyEyeballsDialer) dial(ctx context.Context, dialer *net.Dialer, localAddr net.Addr, network, destination string) (net.Conn, error) {
+	bindingControl := self.LocalBinding.Control(localAddr)
+	if localAddr == nil && bindingControl == nil && self.SocketOptions == nil {
+		return dialer.DialContext(ctx, network, destination)
+	}
+
+	attemptDialer := &net.Dialer{}
+	*attemptDialer = *dialer
+	if localAddr != nil {
+		attemptDialer.LocalAddr = localAddr
+	}
+	attemptDialer.Control = chainControl(dialer.Control, bindingControl, self.SocketOptions.Control())
+	if self.SocketOptions != nil && self.SocketOptions.MultipathTCP {
+		attemptDialer.SetMultipathTCP(true)
+	}
+
+	conn, err := attemptDialer.DialContext(ctx, network, destination)
+	if err != nil {
+		return nil, err
+	}
+
+	if err = self.SocketOptions.Apply(conn); err != nil {
+		_ = conn.Close()
+		return nil, err
+	}
+
+	return conn, nil
+}
@@ -0,0 +1,239 @@
+package transport
+
+import (
+	"context"
+	"errors"
+	"net"
+	"strconv"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+type staticResolver map[string][]net.IPAddr
+
+func (self staticResolver) LookupIPAddr(_ context.Context, host string) ([]net.IPAddr, error) {
+	if ips, found := self[host]; found {
+		return ips, nil
+	}
+	return nil, &net.DNSError{Err: "no such host", Name: host, IsNotFound: true}
+}
+
+func ipAddrs(ips ...string) []net.IPAddr {
+	var result []net.IPAddr
+	for _, ip := range ips {
+		result = append(result, net.IPAddr{IP: net.ParseIP(ip)})
+	}
+	return result
+}
+
+func TestSortCandidates(t *testing.T) {
+	tests := []struct {
+		name  string
+		input []net.IPAddr
+		want  []net.IPAddr
+	}{
+		{"empty", nil, []net.IPAddr{}},
+		{"ipv4 only", ipAddrs("10.0.0.1", "10.0.0.2"), ipAddrs("10.0.0.1", "10.0.0.2")},
+		{"ipv6 first", ipAddrs("10.0.0.1", "10.0.0.2", "2001:db8::1", "2001:db8::2"),
+			ipAddrs("2001:db8::1", "10.0.0.1", "2001:db8::2", "10.0.0.2")},
+		{"uneven families", ipAddrs("2001:db8::1", "10.0.0.1", "10.0.0.2", "10.0.0.3"),
+			ipAddrs("2001:db8::1", "10.0.0.1", "10.0.0.2", "10.0.0.3")},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			require.Equal(t, tt.want, SortCandidates(tt.input))
+		})
+	}
+}
+
+func TestResolveCandidates(t *testing.T) {
+	resolver := staticResolver{
+		"dual.example.com": ipAddrs("10.0.0.1", "2001:db8::1", "10.0.0.2"),
+	}
+
+	tests := []struct {
+		name    string
+		network string
+		host    string
+		want    []net.IPAddr
+		wantErr bool
+	}{
+		{"dual stack", "tcp", "dual.example.com", ipAddrs("2001:db8::1", "10.0.0.1", "10.0.0.2"), false},
+		{"ipv4 only", "tcp4", "dual.example.com", ipAddrs("10.0.0.1", "10.0.0.2"), false},
+		{"ipv6 only", "udp6", "dual.example.com", ipAddrs("2001:db8::1"), false},
+		{"ip literal", "tcp", "192.168.1.1", ipAddrs("192.168.1.1"), false},
+		{"ip literal with zone", "tcp", "fe80::1%eth0", []net.IPAddr{{IP: net.ParseIP("fe80::1"), Zone: "eth0"}}, false},
+		{"ip literal family mismatch", "tcp6", "192.168.1.1", nil, true},
+		{"unknown host", "tcp", "missing.example.com", nil, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ResolveCandidates(context.Background(), resolver, tt.network, tt.host)
+			if tt.wantErr {
+				require.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			require.Equal(t, tt.want, got)
+		})
+	}
+}
+
+type testConn struct {
+	ip     string
+	closed atomic.Bool
+}
+
+func (self *testConn) Close() error {
+	self.closed.Store(true)
+	return nil
+}
+
+func TestRaceCandidates(t *testing.T) {
+	candidates := ipAddrs("2001:db8::1", "10.0.0.1", "2001:db8::2")
+
+	t.Run("slow first candidate", func(t *testing.T) {
+		req := require.New(t)
+
+		var cancelled atomic.Bool
+		start := time.Now()
+		conn, err := RaceCandidates(context.Background(), candidates, 20*time.Millisecond, func(ctx context.Context, ip net.IPAddr) (*testConn, error) {
+			if ip.String() == "2001:db8::1" {
+				<-ctx.Done()
+				cancelled.Store(true)
+				return nil, ctx.Err()
+			}
+			return &testConn{ip: ip.String()}, nil
+		})
+		req.NoError(err)
+		req.Equal("10.0.0.1", conn.ip)
+		req.GreaterOrEqual(time.Since(start), 20*time.Millisecond)
+		req.Eventually(cancelled.Load, time.Second, 5*time.Millisecond)
+	})
+
+	t.Run("failure starts next attempt immediately", func(t *testing.T) {
+		req := require.New(t)
+
+		start := time.Now()
+		conn, err := RaceCandidates(context.Background(), candidates, time.Minute, func(ctx context.Context, ip net.IPAddr) (*testConn, error) {
+			if ip.String() != "2001:db8::2" {
+				return nil, errors.New("connection refused")
+			}
+			return &testConn{ip: ip.String()}, nil
+		})
+		req.NoError(err)
+		req.Equal("2001:db8::2", conn.ip)
+		req.Less(time.Since(start), time.Second)
+	})
+
+	t.Run("late connections are closed", func(t *testing.T) {
+		req := require.New(t)
+
+		late := &testConn{ip: "2001:db8::1"}
+		release := make(chan struct{})
+		conn, err := RaceCandidates(context.Background(), candidates, 10*time.Millisecond, func(ctx context.Context, ip net.IPAddr) (*testConn, error) {
+			switch ip.String() {
+			case "2001:db8::1":
+				<-release
+				return late, nil
+			case "10.0.0.1":
+				return &testConn{ip: ip.String()}, nil
+			default:
+				<-ctx.Done()
+				return nil, ctx.Err()
+			}
+		})
+		req.NoError(err)
+		req.Equal("10.0.0.1", conn.ip)
+		req.False(conn.closed.Load())
+
+		close(release)
+		req.Eventually(late.closed.Load, time.Second, 5*time.Millisecond)
+	})
+
+	t.Run("all attempts fail", func(t *testing.T) {
+		req := require.New(t)
+
+		_, err := RaceCandidates(context.Background(), candidates, 10*time.Millisecond, func(ctx context.Context, ip net.IPAddr) (*testConn, error) {
+			return nil, errors.New("unreachable " + ip.String())
+		})
+		req.Error(err)
+		for _, candidate := range candidates {
+			req.ErrorContains(err, "unreachable "+candidate.String())
+		}
+	})
+
+	t.Run("context cancelled", func(t *testing.T) {
+		ctx, cancelF := context.WithTimeout(context.Background(), 50*time.Millisecond)
+		defer cancelF()
+
+		_, err := RaceCandidates(ctx, candidates, 10*time.Millisecond, func(ctx context.Context, ip net.IPAddr) (*testConn, error) {
+			<-ctx.Done()
+			return nil, ctx.Err()
+		})
+		require.ErrorIs(t, err, context.DeadlineExceeded)
+	})
+}
+
+func TestHappyEyeballsDialer(t *testing.T) {
+	req := require.New(t)
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	req.NoError(err)
+	defer func() { _ = listener.Close() }()
+
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			_ = conn.Close()
+		}
+	}()
+
+	port := strconv.Itoa(listener.Addr().(*net.TCPAddr).Port)
+
+	// nothing listens on 127.0.0.2, so the first attempt is refused and the dialer falls back to 127.0.0.1
+	dialer := NewHappyEyeballsDialer(&net.Dialer{})
+	dialer.Resolver = staticResolver{"ctrl.example.com": ipAddrs("127.0.0.2", "127.0.0.1")}
+
+	conn, err := dialer.DialContext(context.Background(), "tcp", net.JoinHostPort("ctrl.example.com", port))
+	req.NoError(err)
+	req.Equal(listener.Addr().String(), conn.RemoteAddr().String())
+	req.NoError(conn.Close())
+
+	_, err = dialer.DialContext(context.Background(), "tcp", net.JoinHostPort("missing.example.com", port))
+	req.Error(err)
+
+	// attempts to candidates which don't match the family of the local binding fail, leaving the matching ones
+	dialer.Resolver = staticResolver{"ctrl.example.com": ipAddrs("2001:db8::1", "127.0.0.1")}
+	dialer.LocalBinding, err = ParseLocalBinding("127.0.0.1")
+	req.NoError(err)
+
+	conn, err = dialer.DialContext(context.Background(), "tcp", net.JoinHostPort("ctrl.example.com", port))
+	req.NoError(err)
+	req.Equal("127.0.0.1", conn.LocalAddr().(*net.TCPAddr).IP.String())
+	req.NoError(conn.Close())
+}
+
+func TestHappyEyeballsDialerAppliesDeviceBinding(t *testing.T) {
+	req := require.New(t)
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	req.NoError(err)
+	defer func() { _ = listener.Close() }()
+
+	// a device binding has no local address, but the device must still be bound, which fails for a missing device
+	dialer := NewHappyEyeballsDialer(&net.Dialer{})
+	dialer.LocalBinding = &LocalBinding{Device: "ziti-missing0"}
+
+	_, err = dialer.DialContext(context.Background(), "tcp", listener.Addr().String())
+	req.Error(err)
+	req.Contains(err.Error(), "device")
+}
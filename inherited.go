@@ -0,0 +1,213 @@
+/*
+	Copyright NetFoundry Inc.
+
+	Licensed under the Apache License, Version 2.0 (the "License");
+	you may not use this file except in compliance with the License.
+	You may obtain a copy of the License at
+
+	https://www.apache.org/licenses/LICENSE-2.0
+
+	Unless required by applicable law or agreed to in writing, software
+	distributed under the License is distributed on an "AS IS" BASIS,
+	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+	See the License for the specific language governing permissions and
+	limitations under the License.
+*/
+
+package transport
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+)
+
+const (
+	// InheritedAddressPrefix starts bind addresses which refer to a socket inherited from the parent process, either
+	// by file descriptor number, as in fd:3, or by the name given to it in LISTEN_FDNAMES, as in fd:router
+	InheritedAddressPrefix = "fd:"
+
+	// listenFdsStart is the first file descriptor passed using the systemd socket activation protocol
+	listenFdsStart = 3
+)
+
+// inheritedFiles tracks the inherited descriptors in use. Each is closed once the last listener using it is closed, after
+// which its number may be reused for other files, so it can't be listened on again
+var inheritedFiles = struct {
+	sync.Mutex
+	files  map[int]*inheritedFile
+	closed map[int]struct{}
+}{
+	files:  map[int]*inheritedFile{},
+	closed: map[int]struct{}{},
+}
+
+type inheritedFile struct {
+	*os.File
+	refs int
+}
+
+// IsInheritedAddress returns true if the bind address refers to an inherited socket
+func IsInheritedAddress(address string) bool {
+	return strings.HasPrefix(address, InheritedAddressPrefix) && len(address) > len(InheritedAddressPrefix)
+}
+
+// ParseInheritedAddress returns the bind address of an inherited socket, such as fd:3, from an address of the given
+// type, such as tcp:fd:3. Returns false if the address doesn't refer to an inherited socket
+func ParseInheritedAddress(s, typeName string) (string, bool) {
+	prefix := typeName + ":"
+	if !strings.HasPrefix(s, prefix) {
+		return "", false
+	}
+	address := s[len(prefix):]
+	return address, IsInheritedAddress(address)
+}
+
+// InheritedListener returns a listener for an inherited stream socket. The same socket may be listened on by several
+// listeners at once. The inherited descriptor is closed when the last of them is closed, which stops the socket
+// from accepting connections, so it can't be listened on again afterward
+func InheritedListener(network, address string) (net.Listener, error) {
+	f, release, err := acquireInheritedFile(address)
+	if err != nil {
+		return nil, err
+	}
+
+	listener, err := net.FileListener(f)
+	if err != nil {
+		release()
+		return nil, fmt.Errorf("unable to listen on inherited socket %s: %w", address, err)
+	}
+
+	if !strings.HasPrefix(network, listener.Addr().Network()) {
+		_ = listener.Close()
+		release()
+		return nil, fmt.Errorf("inherited socket %s is a %s socket, not %s", address, listener.Addr().Network(), network)
+	}
+
+	return &inheritedListener{Listener: listener, release: release}, nil
+}
+
+// InheritedUDPConn returns the connection for an inherited udp socket. As with InheritedListener, the inherited
+// descriptor is closed once it is no longer used, which the caller signals by calling the returned release function
+// after closing the connection
+func InheritedUDPConn(address string) (*net.UDPConn, func(), error) {
+	f, release, err := acquireInheritedFile(address)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	conn, err := net.FilePacketConn(f)
+	if err != nil {
+		release()
+		return nil, nil, fmt.Errorf("unable to use inherited socket %s: %w", address, err)
+	}
+
+	udpConn, ok := conn.(*net.UDPConn)
+	if !ok {
+		_ = conn.Close()
+		release()
+		return nil, nil, fmt.Errorf("inherited socket %s is a %s socket, not udp", address, conn.LocalAddr().Network())
+	}
+
+	return udpConn, release, nil
+}
+
+// inheritedListener releases the inherited descriptor it was created from when closed
+type inheritedListener struct {
+	net.Listener
+	release func()
+}
+
+func (self *inheritedListener) Close() error {
+	err := self.Listener.Close()
+	self.release()
+	return err
+}
+
+// SyscallConn exposes the socket of the wrapped listener, so that it can be handed over by ExportListeners
+func (self *inheritedListener) SyscallConn() (syscall.RawConn, error) {
+	if sock, ok := self.Listener.(syscall.Conn); ok {
+		return sock.SyscallConn()
+	}
+	return nil, fmt.Errorf("listener on %s does not expose its socket", self.Addr())
+}
+
+// acquireInheritedFile returns the file for an inherited socket address, along with a function to call once it is
+// no longer used. The file is closed when every user has released it
+func acquireInheritedFile(address string) (*os.File, func(), error) {
+	fd, err := resolveInheritedFd(address, os.Getenv)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	inheritedFiles.Lock()
+	defer inheritedFiles.Unlock()
+
+	if _, closed := inheritedFiles.closed[fd]; closed {
+		return nil, nil, fmt.Errorf("inherited socket %s was closed when its last listener was closed", address)
+	}
+
+	f, found := inheritedFiles.files[fd]
+	if !found {
+		file := os.NewFile(uintptr(fd), address)
+		if file == nil {
+			return nil, nil, fmt.Errorf("inherited socket %s is not a valid file descriptor", address)
+		}
+		f = &inheritedFile{File: file}
+		inheritedFiles.files[fd] = f
+	}
+	f.refs++
+
+	var once sync.Once
+	release := func() {
+		once.Do(func() {
+			inheritedFiles.Lock()
+			defer inheritedFiles.Unlock()
+
+			if f.refs--; f.refs == 0 {
+				delete(inheritedFiles.files, fd)
+				inheritedFiles.closed[fd] = struct{}{}
+				_ = f.Close()
+			}
+		})
+	}
+
+	return f.File, release, nil
+}
+
+// resolveInheritedFd returns the file descriptor number for an inherited socket address. Names are looked up in
+// LISTEN_FDNAMES, which is only used if LISTEN_PID, when set, matches this process
+func resolveInheritedFd(address string, getenv func(string) string) (int, error) {
+	if !IsInheritedAddress(address) {
+		return 0, fmt.Errorf("invalid inherited socket address '%s', doesn't start with %s", address, InheritedAddressPrefix)
+	}
+	spec := address[len(InheritedAddressPrefix):]
+
+	if fd, err := strconv.Atoi(spec); err == nil {
+		if fd < listenFdsStart {
+			return 0, fmt.Errorf("invalid inherited socket address '%s', file descriptors below %d can't be listened on", address, listenFdsStart)
+		}
+		return fd, nil
+	}
+
+	if pid := getenv("LISTEN_PID"); pid != "" && pid != strconv.Itoa(os.Getpid()) {
+		return 0, fmt.Errorf("unable to find inherited socket %s, LISTEN_PID is for another process", address)
+	}
+
+	count, err := strconv.Atoi(getenv("LISTEN_FDS"))
+	if err != nil || count < 1 {
+		return 0, fmt.Errorf("unable to find inherited socket %s, no sockets were passed in LISTEN_FDS", address)
+	}
+
+	for i, name := range strings.Split(getenv("LISTEN_FDNAMES"), ":") {
+		if i < count && name == spec {
+			return listenFdsStart + i, nil
+		}
+	}
+
+	return 0, fmt.Errorf("unable to find inherited socket %s, no socket with that name in LISTEN_FDNAMES", address)
+}
@@ -0,0 +1,69 @@
+package transport
+
+import (
+	"os"
+	"strconv"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseInheritedAddress(t *testing.T) {
+	req := require.New(t)
+
+	address, ok := ParseInheritedAddress("tls:fd:3", "tls")
+	req.True(ok)
+	req.Equal("fd:3", address)
+
+	address, ok = ParseInheritedAddress("tcp:fd:router", "tcp")
+	req.True(ok)
+	req.Equal("fd:router", address)
+
+	_, ok = ParseInheritedAddress("tcp:fd:", "tcp")
+	req.False(ok)
+
+	_, ok = ParseInheritedAddress("tcp:127.0.0.1:3", "tcp")
+	req.False(ok)
+
+	_, ok = ParseInheritedAddress("tls:fd:3", "tcp")
+	req.False(ok)
+}
+
+func TestResolveInheritedFd(t *testing.T) {
+	pid := strconv.Itoa(os.Getpid())
+
+	tests := []struct {
+		name    string
+		address string
+		env     map[string]string
+		want    int
+		wantErr bool
+	}{
+		{"explicit fd", "fd:3", nil, 3, false},
+		{"explicit fd outside LISTEN_FDS", "fd:12", map[string]string{"LISTEN_FDS": "1"}, 12, false},
+		{"stdio fd", "fd:2", nil, 0, true},
+		{"first name", "fd:router", map[string]string{"LISTEN_PID": pid, "LISTEN_FDS": "2", "LISTEN_FDNAMES": "router:edge"}, 3, false},
+		{"second name", "fd:edge", map[string]string{"LISTEN_PID": pid, "LISTEN_FDS": "2", "LISTEN_FDNAMES": "router:edge"}, 4, false},
+		{"no LISTEN_PID", "fd:edge", map[string]string{"LISTEN_FDS": "2", "LISTEN_FDNAMES": "router:edge"}, 4, false},
+		{"other process", "fd:router", map[string]string{"LISTEN_PID": "1", "LISTEN_FDS": "1", "LISTEN_FDNAMES": "router"}, 0, true},
+		{"name beyond LISTEN_FDS", "fd:edge", map[string]string{"LISTEN_FDS": "1", "LISTEN_FDNAMES": "router:edge"}, 0, true},
+		{"unknown name", "fd:ctrl", map[string]string{"LISTEN_FDS": "2", "LISTEN_FDNAMES": "router:edge"}, 0, true},
+		{"no sockets passed", "fd:router", nil, 0, true},
+		{"not inherited", "127.0.0.1:3", nil, 0, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := require.New(t)
+			fd, err := resolveInheritedFd(tt.address, func(key string) string {
+				return tt.env[key]
+			})
+			if tt.wantErr {
+				req.Error(err)
+				return
+			}
+			req.NoError(err)
+			req.Equal(tt.want, fd)
+		})
+	}
+}
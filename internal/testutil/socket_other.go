@@ -0,0 +1,30 @@
+//go:build !unix
+
+/*
+	Copyright NetFoundry Inc.
+
+	Licensed under the Apache License, Version 2.0 (the "License");
+	you may not use this file except in compliance with the License.
+	You may obtain a copy of the License at
+
+	https://www.apache.org/licenses/LICENSE-2.0
+
+	Unless required by applicable law or agreed to in writing, software
+	distributed under the License is distributed on an "AS IS" BASIS,
+	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+	See the License for the specific language governing permissions and
+	limitations under the License.
+*/
+
+package testutil
+
+import (
+	"syscall"
+	"testing"
+)
+
+// InheritableFd skips the test, as inherited sockets are only supported on unix platforms
+func InheritableFd(t *testing.T, _ syscall.Conn) int {
+	t.Skip("inherited sockets are only supported on unix platforms")
+	return -1
+}
@@ -0,0 +1,41 @@
+//go:build unix
+
+/*
+	Copyright NetFoundry Inc.
+
+	Licensed under the Apache License, Version 2.0 (the "License");
+	you may not use this file except in compliance with the License.
+	You may obtain a copy of the License at
+
+	https://www.apache.org/licenses/LICENSE-2.0
+
+	Unless required by applicable law or agreed to in writing, software
+	distributed under the License is distributed on an "AS IS" BASIS,
+	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+	See the License for the specific language governing permissions and
+	limitations under the License.
+*/
+
+package testutil
+
+import (
+	"syscall"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+// InheritableFd duplicates the descriptor of a socket, standing in for one passed by a supervisor. Ownership of the
+// returned descriptor passes to the listener it is given to
+func InheritableFd(t *testing.T, sock syscall.Conn) int {
+	rawConn, err := sock.SyscallConn()
+	require.NoError(t, err)
+
+	var fd int
+	var dupErr error
+	require.NoError(t, rawConn.Control(func(sockFd uintptr) {
+		fd, dupErr = syscall.Dup(int(sockFd))
+	}))
+	require.NoError(t, dupErr)
+	return fd
+}
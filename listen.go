@@ -0,0 +1,104 @@
+/*
+	Copyright NetFoundry Inc.
+
+	Licensed under the Apache License, Version 2.0 (the "License");
+	you may not use this file except in compliance with the License.
+	You may obtain a copy of the License at
+
+	https://www.apache.org/licenses/LICENSE-2.0
+
+	Unless required by applicable law or agreed to in writing, software
+	distributed under the License is distributed on an "AS IS" BASIS,
+	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+	See the License for the specific language governing permissions and
+	limitations under the License.
+*/
+
+package transport
+
+import (
+	"context"
+	"errors"
+	"net"
+	"strings"
+)
+
+// Listen opens the listening sockets for a bind address, one per ListenerSockets, each of which should be served by
+// its own accept loop. If the bind address has no port, the port picked for the first socket is used for the others.
+// Sockets which aren't tcp are opened once. A nil receiver opens a single socket with the go defaults. If the bind
+// address refers to an inherited socket, or sockets for it were adopted from another process, those are used
+// instead, and the listener options don't apply. tcp sockets are registered to be handed over by ExportListeners
+// until they're closed
+func (self *SocketOptions) Listen(ctx context.Context, network, address string) ([]net.Listener, error) {
+	result, err := self.listen(ctx, network, address)
+	if err != nil {
+		return nil, err
+	}
+
+	if strings.HasPrefix(network, "tcp") {
+		for i, listener := range result {
+			result[i] = registerListener(network, address, listener)
+		}
+	}
+
+	return result, nil
+}
+
+func (self *SocketOptions) listen(ctx context.Context, network, address string) ([]net.Listener, error) {
+	if adopted, err := AdoptedListeners(network, address); err != nil || len(adopted) > 0 {
+		return adopted, err
+	}
+
+	if IsInheritedAddress(address) {
+		listener, err := InheritedListener(network, address)
+		if err != nil {
+			return nil, err
+		}
+		return []net.Listener{listener}, nil
+	}
+
+	count := 1
+	if self != nil && self.ListenerSockets > 1 && strings.HasPrefix(network, "tcp") {
+		count = self.ListenerSockets
+	}
+
+	listenConfig := self.ListenConfig()
+	if count > 1 {
+		listenConfig.Control = chainControl(setReusePort, listenConfig.Control)
+	}
+
+	var result []net.Listener
+	for len(result) < count {
+		listener, err := listenConfig.Listen(ctx, network, address)
+		if err != nil {
+			for _, l := range result {
+				_ = l.Close()
+			}
+			return nil, err
+		}
+		result = append(result, listener)
+
+		if len(result) == 1 && count > 1 {
+			host, _, err := net.SplitHostPort(address)
+			if err != nil {
+				_ = listener.Close()
+				return nil, err
+			}
+			_, port, _ := net.SplitHostPort(listener.Addr().String())
+			address = net.JoinHostPort(host, port)
+		}
+	}
+
+	return result, nil
+}
+
+// CloseListeners closes all of the given listeners, returning any errors
+func CloseListeners(listeners []net.Listener) error {
+	var errs []error
+	for _, listener := range listeners {
+		if err := listener.Close(); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
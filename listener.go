@@ -0,0 +1,90 @@
+/*
+	Copyright NetFoundry Inc.
+
+	Licensed under the Apache License, Version 2.0 (the "License");
+	you may not use this file except in compliance with the License.
+	You may obtain a copy of the License at
+
+	https://www.apache.org/licenses/LICENSE-2.0
+
+	Unless required by applicable law or agreed to in writing, software
+	distributed under the License is distributed on an "AS IS" BASIS,
+	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+	See the License for the specific language governing permissions and
+	limitations under the License.
+*/
+
+package transport
+
+import (
+	"context"
+	"io"
+	"sync"
+	"sync/atomic"
+)
+
+// Listener is returned by Address.Listen. Closing it stops accepting new connections. Connections which have
+// already been passed to the accept function are not affected by Close or Shutdown.
+type Listener interface {
+	io.Closer
+
+	// Shutdown stops accepting new connections, then waits until connections which were still being set up, for
+	// example with a tls or dtls handshake in progress, have been passed to the accept function or have failed, and
+	// until the accept goroutines of the listener have exited. If the context ends first, its error is returned
+	Shutdown(ctx context.Context) error
+
+	// Addr returns the transport address the listener is bound to, for example tls:127.0.0.1:41331. If the
+	// listener was bound to port 0, this contains the port which was picked
+	Addr() string
+
+	// Stats returns the connection counters of the listener
+	Stats() ListenerStats
+}
+
+// ListenerStats counts the incoming connections seen by a listener
+type ListenerStats struct {
+	// Accepted is the number of connections which were passed to the accept function
+	Accepted uint64
+
+	// Failed is the number of incoming connections which were dropped before being passed to the accept function,
+	// for example because the tls handshake failed
+	Failed uint64
+}
+
+// ListenerCounters tracks ListenerStats. It may be embedded by Listener implementations to provide Stats
+type ListenerCounters struct {
+	accepted atomic.Uint64
+	failed   atomic.Uint64
+}
+
+func (self *ListenerCounters) IncrementAccepted() {
+	self.accepted.Add(1)
+}
+
+func (self *ListenerCounters) IncrementFailed() {
+	self.failed.Add(1)
+}
+
+func (self *ListenerCounters) Stats() ListenerStats {
+	return ListenerStats{
+		Accepted: self.accepted.Load(),
+		Failed:   self.failed.Load(),
+	}
+}
+
+// WaitForShutdown waits for the wait group, returning the error of the context if it ends first. It is meant for
+// implementing Listener.Shutdown
+func WaitForShutdown(ctx context.Context, wg *sync.WaitGroup) error {
+	done := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
@@ -0,0 +1,419 @@
+/*
+	Copyright NetFoundry Inc.
+
+	Licensed under the Apache License, Version 2.0 (the "License");
+	you may not use this file except in compliance with the License.
+	You may obtain a copy of the License at
+
+	https://www.apache.org/licenses/LICENSE-2.0
+
+	Unless required by applicable law or agreed to in writing, software
+	distributed under the License is distributed on an "AS IS" BASIS,
+	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+	See the License for the specific language governing permissions and
+	limitations under the License.
+*/
+
+package transport
+
+import (
+	"context"
+	"fmt"
+	"math/rand/v2"
+	"net"
+	"strconv"
+	"strings"
+	"syscall"
+
+	"github.com/pkg/errors"
+)
+
+// DeviceBindingPrefix marks local bindings which bind to a network device, rather than to a local address
+const DeviceBindingPrefix = "dev:"
+
+// LocalBinding is the parsed form of a local binding string, which selects the local address connections are
+// dialed from. The following forms are supported:
+//
+//	eth0              any address of the interface, chosen to match the destination
+//	eth0:1            interface names containing colons, such as aliases, take precedence over the forms below
+//	eth0/10.0.0.5     a specific address of the interface
+//	10.0.0.5          a specific address
+//	fe80::1%eth0      a specific link-local address, with zone
+//	10.0.0.5:40000    a specific address and port
+//	[fe80::1%eth0]:0  a specific IPv6 address, with optional zone and port
+//	eth0:40000        any address of the interface, with a specific port
+//	eth0:40000-40100  any address of the interface, with the first free port of the range
+//	dev:wg0           bind sockets to the device with SO_BINDTODEVICE (linux only), leaving the choice of
+//	                  source address to the kernel
+//
+// When a port range is given, dialers try the ports of the range, starting at a random port, until one is found
+// which isn't in use.
+type LocalBinding struct {
+	// Interface is set if the binding names an interface
+	Interface *net.Interface
+
+	// Device is set if sockets should be bound to a network device
+	Device string
+
+	// IP and Zone are set if the binding pins a specific address
+	IP   net.IP
+	Zone string
+
+	// Port is the local port to bind to, or 0 to let the operating system choose. If LastPort is set, Port and
+	// LastPort are the first and last ports of a port range
+	Port     int
+	LastPort int
+
+	original string
+}
+
+// ParseLocalBinding parses a local binding string. An empty string results in a nil binding, meaning the operating
+// system chooses the local address
+func ParseLocalBinding(localBinding string) (*LocalBinding, error) {
+	if localBinding == "" {
+		return nil, nil
+	}
+
+	result := &LocalBinding{original: localBinding}
+
+	if device, found := strings.CutPrefix(localBinding, DeviceBindingPrefix); found {
+		if err := checkBindToDeviceSupported(); err != nil {
+			return nil, err
+		}
+		if _, err := net.InterfaceByName(device); err != nil {
+			return nil, errors.Wrapf(err, "unable to find device %s for local binding %s", device, localBinding)
+		}
+		result.Device = device
+		return result, nil
+	}
+
+	// check for a full interface name first, as aliases such as eth0:1 would otherwise look like host:port
+	if iface, err := net.InterfaceByName(localBinding); err == nil {
+		result.Interface = iface
+		return result, nil
+	}
+
+	host := localBinding
+	if h, port, err := net.SplitHostPort(localBinding); err == nil {
+		if err = result.setPorts(port); err != nil {
+			return nil, err
+		}
+		host = h
+	}
+
+	if ifaceName, ip, found := strings.Cut(host, "/"); found {
+		iface, err := net.InterfaceByName(ifaceName)
+		if err != nil {
+			return nil, errors.Wrapf(err, "unable to find interface %s for local binding %s", ifaceName, localBinding)
+		}
+		result.Interface = iface
+		if err = result.setIP(ip); err != nil {
+			return nil, err
+		}
+		if !result.hasAddress(iface) {
+			return nil, errors.Errorf("address %s is not assigned to interface %s", result.IP, iface.Name)
+		}
+		if result.Zone == "" && result.IP.IsLinkLocalUnicast() && result.IP.To4() == nil {
+			result.Zone = iface.Name
+		}
+		return result, nil
+	}
+
+	if err := result.setIP(host); err == nil {
+		return result, nil
+	}
+
+	iface, err := ResolveInterface(host)
+	if err != nil {
+		return nil, err
+	}
+	result.Interface = iface
+	return result, nil
+}
+
+func (self *LocalBinding) setPorts(val string) error {
+	first, last, isRange := strings.Cut(val, "-")
+
+	port, err := strconv.ParseUint(first, 10, 16)
+	if err != nil {
+		return errors.Errorf("invalid port '%s' in local binding %s", val, self.original)
+	}
+	self.Port = int(port)
+
+	if isRange {
+		lastPort, err := strconv.ParseUint(last, 10, 16)
+		if err != nil || lastPort < port || port == 0 {
+			return errors.Errorf("invalid port range '%s' in local binding %s", val, self.original)
+		}
+		self.LastPort = int(lastPort)
+	}
+
+	return nil
+}
+
+// ports returns the ports to try, starting at a random port of the range, if a range is set
+func (self *LocalBinding) ports() []int {
+	if self.LastPort == 0 {
+		return []int{self.Port}
+	}
+
+	count := self.LastPort - self.Port + 1
+	offset := rand.IntN(count)
+	result := make([]int, count)
+	for i := range result {
+		result[i] = self.Port + (offset+i)%count
+	}
+	return result
+}
+
+func (self *LocalBinding) setIP(val string) error {
+	ip, zone, _ := strings.Cut(val, "%")
+	if self.IP = net.ParseIP(ip); self.IP == nil {
+		return errors.Errorf("invalid ip address '%s' in local binding %s", val, self.original)
+	}
+	self.Zone = zone
+	return nil
+}
+
+func (self *LocalBinding) hasAddress(iface *net.Interface) bool {
+	addrs, err := interfaceIPs(iface)
+	if err != nil {
+		return false
+	}
+	for _, addr := range addrs {
+		if addr.Equal(self.IP) {
+			return true
+		}
+	}
+	return false
+}
+
+func (self *LocalBinding) String() string {
+	return self.original
+}
+
+// LocalIP returns the local address to use when connecting to destination. If the binding names an interface, the
+// address is chosen from the addresses of the interface, matching the family and scope of the destination. Link
+// local IPv6 addresses are returned with the interface as zone. If destination is nil, a global IPv4 address is
+// preferred. An error is returned if no suitable address is found. Device bindings return a nil address.
+func (self *LocalBinding) LocalIP(destination net.IP) (net.IP, string, error) {
+	if self.Device != "" {
+		return nil, "", nil
+	}
+
+	if self.IP != nil {
+		if destination != nil && (self.IP.To4() == nil) != (destination.To4() == nil) {
+			return nil, "", errors.Errorf("local binding %s can't be used to connect to %s, address families differ", self.original, destination)
+		}
+		return self.IP, self.Zone, nil
+	}
+
+	addrs, err := interfaceIPs(self.Interface)
+	if err != nil {
+		return nil, "", err
+	}
+
+	if len(addrs) == 0 {
+		return nil, "", errors.New(fmt.Sprintf("no ip addresses assigned to interface %s", self.Interface.Name))
+	}
+
+	best := selectLocalAddress(addrs, destination)
+	if best == nil {
+		return nil, "", errors.Errorf("no address on interface %s can be used to connect to %s", self.Interface.Name, destination)
+	}
+
+	var zone string
+	if best.To4() == nil && best.IsLinkLocalUnicast() {
+		zone = self.Interface.Name
+	}
+	return best, zone, nil
+}
+
+// LocalAddr returns the local address to use when connecting to destination over the given network, as a
+// *net.TCPAddr for tcp networks and as a *net.UDPAddr for udp networks. Returns nil if the binding doesn't restrict
+// the local address, as is the case for device bindings
+func (self *LocalBinding) LocalAddr(network string, destination net.IP) (net.Addr, error) {
+	ip, zone, err := self.LocalIP(destination)
+	if err != nil {
+		return nil, err
+	}
+
+	if ip == nil && self.Port == 0 {
+		return nil, nil
+	}
+
+	switch {
+	case strings.HasPrefix(network, "udp"):
+		return &net.UDPAddr{IP: ip, Zone: zone, Port: self.Port}, nil
+	case strings.HasPrefix(network, "tcp"), network == "tls":
+		return &net.TCPAddr{IP: ip, Zone: zone, Port: self.Port}, nil
+	default:
+		return nil, errors.New(fmt.Sprintf("Unsupported addressType: %s", network))
+	}
+}
+
+// BindLocalAddr calls bind with the local address to use when connecting to destination over the given network. If
+// the binding has a port range, bind is called with each port of the range until it succeeds or fails for a reason
+// other than the local address being in use or unavailable. If binding is nil, bind is called with a nil address.
+func BindLocalAddr[T any](binding *LocalBinding, network string, destination net.IP, bind func(localAddr net.Addr) (T, error)) (T, error) {
+	if binding == nil {
+		return bind(nil)
+	}
+
+	var zero T
+	localAddr, err := binding.LocalAddr(network, destination)
+	if err != nil {
+		return zero, err
+	}
+
+	ports := binding.ports()
+	for _, port := range ports {
+		switch addr := localAddr.(type) {
+		case *net.TCPAddr:
+			addr.Port = port
+		case *net.UDPAddr:
+			addr.Port = port
+		}
+
+		result, err := bind(localAddr)
+		if err == nil || len(ports) == 1 || !isLocalAddressUnavailable(err) {
+			return result, err
+		}
+	}
+
+	return zero, errors.Errorf("no free local port in range %d-%d for local binding %s", binding.Port, binding.LastPort, binding.original)
+}
+
+// Control returns a Control function for net.Dialer or net.ListenConfig, for sockets bound to the given local
+// address. If the binding names a device, the socket is bound to the device with SO_BINDTODEVICE. When binding tcp
+// sockets to a specific local port, SO_REUSEADDR is set, so that ports still held by connections in TIME_WAIT can be
+// reused for connections to other destinations. Returns nil if no socket options need to be set.
+func (self *LocalBinding) Control(localAddr net.Addr) func(network, address string, c syscall.RawConn) error {
+	var device string
+	if self != nil {
+		device = self.Device
+	}
+
+	addr, isTcp := localAddr.(*net.TCPAddr)
+	reuseAddr := isTcp && addr.Port != 0
+
+	if device == "" && !reuseAddr {
+		return nil
+	}
+
+	return func(network, address string, c syscall.RawConn) error {
+		if reuseAddr {
+			if err := setReuseAddr(c); err != nil {
+				return err
+			}
+		}
+		if device != "" {
+			return bindToDevice(c, device)
+		}
+		return nil
+	}
+}
+
+// ListenUDP opens a udp socket for dialing from the given local address, which should come from BindLocalAddr. The
+// socket options of the binding are applied, see Control. The binding may be nil
+func (self *LocalBinding) ListenUDP(ctx context.Context, localAddr net.Addr) (*net.UDPConn, error) {
+	address := ":0"
+	if localAddr != nil {
+		address = localAddr.String()
+	}
+
+	listenConfig := &net.ListenConfig{
+		Control: self.Control(localAddr),
+	}
+
+	conn, err := listenConfig.ListenPacket(ctx, "udp", address)
+	if err != nil {
+		return nil, err
+	}
+	return conn.(*net.UDPConn), nil
+}
+
+// selectLocalAddress returns the first of the best suited local addresses for destination, or nil if none can
+// be used
+func selectLocalAddress(addrs []net.IP, destination net.IP) net.IP {
+	var best net.IP
+	bestScore := 0
+	for _, addr := range addrs {
+		if score := localAddressScore(addr, destination); score > bestScore {
+			best = addr
+			bestScore = score
+		}
+	}
+	return best
+}
+
+// localAddressScore rates how well a local address suits a destination, with 0 meaning it can't be used. Addresses
+// must match the family of the destination. Addresses of the same scope as the destination are preferred, and link
+// local addresses are only used for link local destinations. Without a destination, global IPv4 addresses are
+// preferred, followed by global IPv6 addresses.
+func localAddressScore(local, destination net.IP) int {
+	localScope := addressScope(local)
+
+	if destination == nil {
+		score := 1
+		if localScope != scopeLinkLocal {
+			score += 2
+		}
+		if local.To4() != nil {
+			score++
+		}
+		return score
+	}
+
+	if (local.To4() == nil) != (destination.To4() == nil) {
+		return 0
+	}
+
+	destinationScope := addressScope(destination)
+	if localScope == destinationScope {
+		return 2
+	}
+	if localScope == scopeLinkLocal {
+		return 0
+	}
+	return 1
+}
+
+type scope int
+
+const (
+	scopeGlobal scope = iota
+	scopeLinkLocal
+	scopeLoopback
+)
+
+func addressScope(ip net.IP) scope {
+	switch {
+	case ip.IsLoopback():
+		return scopeLoopback
+	case ip.IsLinkLocalUnicast():
+		return scopeLinkLocal
+	default:
+		return scopeGlobal
+	}
+}
+
+func interfaceIPs(iface *net.Interface) ([]net.IP, error) {
+	addrs, err := iface.Addrs()
+	if err != nil {
+		return nil, err
+	}
+
+	var result []net.IP
+	for _, addr := range addrs {
+		switch addr := addr.(type) {
+		case *net.IPNet:
+			result = append(result, addr.IP)
+		case *net.IPAddr:
+			result = append(result, addr.IP)
+		default:
+			return nil, fmt.Errorf("unexpected address type %T on interface %s", addr, iface.Name)
+		}
+	}
+	return result, nil
+}
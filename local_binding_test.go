@@ -0,0 +1,304 @@
+package transport
+
+import (
+	"context"
+	"io"
+	"net"
+	"runtime"
+	"slices"
+	"strconv"
+	"strings"
+	"syscall"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func ips(vals ...string) []net.IP {
+	var result []net.IP
+	for _, val := range vals {
+		result = append(result, net.ParseIP(val))
+	}
+	return result
+}
+
+func TestSelectLocalAddress(t *testing.T) {
+	dualStack := ips("fe80::1", "169.254.1.1", "10.0.0.5", "2001:db8::5", "127.0.0.1")
+
+	tests := []struct {
+		name        string
+		addrs       []net.IP
+		destination string
+		want        string
+	}{
+		{"global ipv4", dualStack, "10.1.1.1", "10.0.0.5"},
+		{"global ipv6 skips link local", dualStack, "2001:db8::9", "2001:db8::5"},
+		{"link local ipv6", dualStack, "fe80::9", "fe80::1"},
+		{"link local ipv4", dualStack, "169.254.9.9", "169.254.1.1"},
+		{"loopback", dualStack, "127.0.0.1", "127.0.0.1"},
+		{"no destination prefers global ipv4", dualStack, "", "10.0.0.5"},
+		{"no destination falls back to global ipv6", ips("fe80::1", "2001:db8::5"), "", "2001:db8::5"},
+		{"no global ipv6", ips("fe80::1", "10.0.0.5"), "2001:db8::9", ""},
+		{"no ipv4", ips("fe80::1", "2001:db8::5"), "10.1.1.1", ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := selectLocalAddress(tt.addrs, net.ParseIP(tt.destination))
+			if tt.want == "" {
+				require.Nil(t, got)
+			} else {
+				require.Equal(t, tt.want, got.String())
+			}
+		})
+	}
+}
+
+func loopbackInterface(t *testing.T) *net.Interface {
+	ifaces, err := net.Interfaces()
+	require.NoError(t, err)
+	for _, iface := range ifaces {
+		if iface.Flags&net.FlagLoopback != 0 && iface.Flags&net.FlagUp != 0 {
+			return &iface
+		}
+	}
+	t.Skip("no loopback interface found")
+	return nil
+}
+
+func TestParseLocalBinding(t *testing.T) {
+	lo := loopbackInterface(t)
+
+	tests := []struct {
+		name      string
+		input     string
+		wantIface string
+		wantIP    string
+		wantZone  string
+		wantPort  int
+		wantErr   bool
+	}{
+		{"interface", lo.Name, lo.Name, "", "", 0, false},
+		{"interface and ip", lo.Name + "/127.0.0.1", lo.Name, "127.0.0.1", "", 0, false},
+		{"interface and port", lo.Name + ":40000", lo.Name, "", "", 40000, false},
+		{"ip", "10.0.0.5", "", "10.0.0.5", "", 0, false},
+		{"ip and port", "10.0.0.5:40000", "", "10.0.0.5", "", 40000, false},
+		{"ipv6 with zone", "fe80::1%eth0", "", "fe80::1", "eth0", 0, false},
+		{"ipv6 with zone and port", "[fe80::1%eth0]:40000", "", "fe80::1", "eth0", 40000, false},
+		{"interface and port range", lo.Name + ":40000-40100", lo.Name, "", "", 40000, false},
+		{"ip and port range", "10.0.0.5:40000-40100", "", "10.0.0.5", "", 40000, false},
+		{"interface ip and port range", lo.Name + "/127.0.0.1:40000-40100", lo.Name, "127.0.0.1", "", 40000, false},
+		{"reversed port range", "10.0.0.5:40100-40000", "", "", "", 0, true},
+		{"port range starting at zero", "10.0.0.5:0-100", "", "", "", 0, true},
+		{"invalid port range", "10.0.0.5:40000-", "", "", "", 0, true},
+		{"interface ip not assigned", lo.Name + "/10.9.9.9", "", "", "", 0, true},
+		{"unknown interface", "missing0/10.0.0.5", "", "", "", 0, true},
+		{"invalid port", "10.0.0.5:99999", "", "", "", 0, true},
+		{"unknown binding", "missing0", "", "", "", 0, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := require.New(t)
+
+			binding, err := ParseLocalBinding(tt.input)
+			if tt.wantErr {
+				req.Error(err)
+				return
+			}
+			req.NoError(err)
+
+			if tt.wantIface == "" {
+				req.Nil(binding.Interface)
+			} else {
+				req.Equal(tt.wantIface, binding.Interface.Name)
+			}
+
+			if tt.wantIP == "" {
+				req.Nil(binding.IP)
+			} else {
+				req.Equal(tt.wantIP, binding.IP.String())
+			}
+
+			req.Equal(tt.wantZone, binding.Zone)
+			req.Equal(tt.wantPort, binding.Port)
+			if strings.Contains(tt.name, "range") {
+				req.Equal(40100, binding.LastPort)
+			} else {
+				req.Equal(0, binding.LastPort)
+			}
+		})
+	}
+
+	binding, err := ParseLocalBinding("")
+	require.NoError(t, err)
+	require.Nil(t, binding)
+}
+
+func TestLocalBindingLocalAddr(t *testing.T) {
+	req := require.New(t)
+	lo := loopbackInterface(t)
+
+	binding, err := ParseLocalBinding(lo.Name + ":40000")
+	req.NoError(err)
+
+	addr, err := binding.LocalAddr("tcp", net.ParseIP("127.0.0.1"))
+	req.NoError(err)
+	req.Equal("127.0.0.1:40000", addr.String())
+	_, isTcp := addr.(*net.TCPAddr)
+	req.True(isTcp)
+
+	binding, err = ParseLocalBinding("10.0.0.5")
+	req.NoError(err)
+
+	addr, err = binding.LocalAddr("udp", net.ParseIP("10.1.1.1"))
+	req.NoError(err)
+	req.Equal(&net.UDPAddr{IP: net.ParseIP("10.0.0.5")}, addr)
+
+	_, err = binding.LocalAddr("udp", net.ParseIP("2001:db8::1"))
+	req.Error(err)
+}
+
+func TestBindLocalAddrPortRange(t *testing.T) {
+	req := require.New(t)
+
+	binding, err := ParseLocalBinding("10.0.0.5:40000-40009")
+	req.NoError(err)
+
+	ports := binding.ports()
+	sorted := slices.Clone(ports)
+	slices.Sort(sorted)
+	req.Equal([]int{40000, 40001, 40002, 40003, 40004, 40005, 40006, 40007, 40008, 40009}, sorted)
+
+	// ports in use are skipped
+	var tried []int
+	addr, err := BindLocalAddr(binding, "tcp", nil, func(localAddr net.Addr) (net.Addr, error) {
+		port := localAddr.(*net.TCPAddr).Port
+		tried = append(tried, port)
+		if port != 40005 {
+			return nil, &net.OpError{Op: "dial", Net: "tcp", Err: syscall.EADDRINUSE}
+		}
+		return localAddr, nil
+	})
+	req.NoError(err)
+	req.Equal("10.0.0.5:40005", addr.String())
+	req.Contains(tried, 40005)
+
+	// other errors aren't retried
+	tried = nil
+	_, err = BindLocalAddr(binding, "tcp", nil, func(localAddr net.Addr) (net.Addr, error) {
+		tried = append(tried, localAddr.(*net.TCPAddr).Port)
+		return nil, syscall.ECONNREFUSED
+	})
+	req.ErrorIs(err, syscall.ECONNREFUSED)
+	req.Len(tried, 1)
+
+	// running out of ports is reported
+	_, err = BindLocalAddr(binding, "udp", nil, func(localAddr net.Addr) (net.Addr, error) {
+		return nil, syscall.EADDRNOTAVAIL
+	})
+	req.ErrorContains(err, "no free local port in range 40000-40009")
+
+	// without a binding, bind is called once with a nil address
+	addr, err = BindLocalAddr(nil, "tcp", nil, func(localAddr net.Addr) (net.Addr, error) {
+		return localAddr, nil
+	})
+	req.NoError(err)
+	req.Nil(addr)
+}
+
+func TestDialFromFixedPortReusesTimeWaitPort(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("SO_REUSEADDR isn't set on windows")
+	}
+
+	req := require.New(t)
+
+	startListener := func() net.Listener {
+		listener, err := net.Listen("tcp", "127.0.0.1:0")
+		req.NoError(err)
+		t.Cleanup(func() { _ = listener.Close() })
+
+		go func() {
+			for {
+				conn, err := listener.Accept()
+				if err != nil {
+					return
+				}
+				go func() {
+					_, _ = io.Copy(io.Discard, conn)
+					_ = conn.Close()
+				}()
+			}
+		}()
+		return listener
+	}
+
+	first := startListener()
+	second := startListener()
+
+	// find a free local port
+	probe, err := net.Listen("tcp", "127.0.0.1:0")
+	req.NoError(err)
+	port := probe.Addr().(*net.TCPAddr).Port
+	req.NoError(probe.Close())
+
+	binding, err := ParseLocalBinding("127.0.0.1:" + strconv.Itoa(port))
+	req.NoError(err)
+
+	dialer := NewHappyEyeballsDialer(&net.Dialer{})
+	dialer.LocalBinding = binding
+
+	conn, err := dialer.DialContext(context.Background(), "tcp", first.Addr().String())
+	req.NoError(err)
+	req.Equal(port, conn.LocalAddr().(*net.TCPAddr).Port)
+
+	// closing first leaves the local port in TIME_WAIT
+	req.NoError(conn.Close())
+
+	conn, err = dialer.DialContext(context.Background(), "tcp", second.Addr().String())
+	req.NoError(err)
+	req.Equal(port, conn.LocalAddr().(*net.TCPAddr).Port)
+	req.NoError(conn.Close())
+}
+
+func TestDeviceBinding(t *testing.T) {
+	req := require.New(t)
+	lo := loopbackInterface(t)
+
+	binding, err := ParseLocalBinding(DeviceBindingPrefix + lo.Name)
+	if runtime.GOOS != "linux" {
+		req.Error(err)
+		return
+	}
+	req.NoError(err)
+	req.Equal(lo.Name, binding.Device)
+	req.Nil(binding.Interface)
+	req.Nil(binding.IP)
+
+	addr, err := binding.LocalAddr("tcp", net.ParseIP("127.0.0.1"))
+	req.NoError(err)
+	req.Nil(addr)
+	req.NotNil(binding.Control(nil))
+
+	_, err = ParseLocalBinding(DeviceBindingPrefix + "missing0")
+	req.Error(err)
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	req.NoError(err)
+	defer func() { _ = listener.Close() }()
+
+	dialer := NewHappyEyeballsDialer(&net.Dialer{})
+	dialer.LocalBinding = binding
+
+	conn, err := dialer.DialContext(context.Background(), "tcp", listener.Addr().String())
+	if err != nil && strings.Contains(err.Error(), "CAP_NET_RAW") {
+		t.Skip("process lacks CAP_NET_RAW")
+	}
+	req.NoError(err)
+	req.NoError(conn.Close())
+
+	udpConn, err := binding.ListenUDP(context.Background(), nil)
+	req.NoError(err)
+	req.NoError(udpConn.Close())
+}
@@ -0,0 +1,90 @@
+/*
+	Copyright NetFoundry Inc.
+
+	Licensed under the Apache License, Version 2.0 (the "License");
+	you may not use this file except in compliance with the License.
+	You may obtain a copy of the License at
+
+	https://www.apache.org/licenses/LICENSE-2.0
+
+	Unless required by applicable law or agreed to in writing, software
+	distributed under the License is distributed on an "AS IS" BASIS,
+	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+	See the License for the specific language governing permissions and
+	limitations under the License.
+*/
+
+package proxies
+
+import (
+	"context"
+	"net"
+
+	"github.com/michaelquigley/pfxlog"
+	"github.com/openziti/transport/v2"
+	"github.com/pkg/errors"
+	"golang.org/x/net/proxy"
+)
+
+// ForwardDialer connects to the proxy server, or directly to the destination if no proxy is used. It is implemented
+// by *net.Dialer and *transport.HappyEyeballsDialer
+type ForwardDialer interface {
+	proxy.Dialer
+	proxy.ContextDialer
+}
+
+// NewContextDialer returns a dialer which connects through the proxy described by proxyConf, using forward to
+// reach the proxy server. If no proxy is configured, forward is returned as is.
+func NewContextDialer(proxyConf *transport.ProxyConfiguration, forward ForwardDialer) (proxy.ContextDialer, error) {
+	if proxyConf == nil || proxyConf.Type == transport.ProxyTypeNone {
+		return forward, nil
+	}
+
+	if proxyConf.Type == transport.ProxyTypeEnvironment {
+		return &environmentProxyDialer{
+			proxyConf: proxyConf,
+			forward:   forward,
+		}, nil
+	}
+
+	pfxlog.Logger().Infof("using %s proxy at %s", string(proxyConf.Type), proxyConf.Address)
+
+	switch proxyConf.Type {
+	case transport.ProxyTypeHttpConnect:
+		return NewHttpConnectProxyDialer(forward, proxyConf.Address, proxyConf.Auth, 0), nil
+	case transport.ProxyTypeHttpsConnect:
+		return NewHttpsConnectProxyDialer(forward, proxyConf.Address, proxyConf.Auth, proxyConf.TLSConfig, 0), nil
+	case transport.ProxyTypeSocks5:
+		dialer, err := proxy.SOCKS5("tcp", proxyConf.Address, proxyConf.Auth, forward)
+		if err != nil {
+			return nil, errors.Wrapf(err, "unable to create socks5 proxy dialer for %s", proxyConf.Address)
+		}
+		contextDialer, ok := dialer.(proxy.ContextDialer)
+		if !ok {
+			return nil, errors.Errorf("socks5 proxy dialer of type %T does not support contexts", dialer)
+		}
+		return contextDialer, nil
+	default:
+		return nil, errors.Errorf("unsupported proxy type %s", string(proxyConf.Type))
+	}
+}
+
+// environmentProxyDialer selects the proxy for each destination from the proxy environment variables
+type environmentProxyDialer struct {
+	proxyConf *transport.ProxyConfiguration
+	forward   ForwardDialer
+}
+
+func (self *environmentProxyDialer) DialContext(ctx context.Context, network, addr string) (net.Conn, error) {
+	proxyConf, err := self.proxyConf.ForDestination(addr)
+	if err != nil {
+		return nil, err
+	}
+
+	dialer, err := NewContextDialer(proxyConf, self.forward)
+	if err != nil {
+		return nil, err
+	}
+
+	return dialer.DialContext(ctx, network, addr)
+}
@@ -0,0 +1,204 @@
+package proxies
+
+import (
+	"context"
+	"encoding/binary"
+	"io"
+	"net"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/openziti/transport/v2"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/net/proxy"
+)
+
+// startEchoServer starts a tcp server which echoes back whatever it receives
+func startEchoServer(t *testing.T) net.Listener {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = listener.Close() })
+
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer func() { _ = conn.Close() }()
+				_, _ = io.Copy(conn, conn)
+			}()
+		}
+	}()
+
+	return listener
+}
+
+// startSocks5Proxy starts a minimal socks5 proxy supporting the CONNECT command, requiring username/password
+// authentication if auth is set
+func startSocks5Proxy(t *testing.T, auth *proxy.Auth) net.Listener {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = listener.Close() })
+
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go serveSocks5(conn, auth)
+		}
+	}()
+
+	return listener
+}
+
+func serveSocks5(conn net.Conn, auth *proxy.Auth) {
+	defer func() { _ = conn.Close() }()
+
+	header := make([]byte, 2)
+	if _, err := io.ReadFull(conn, header); err != nil || header[0] != 5 {
+		return
+	}
+	methods := make([]byte, header[1])
+	if _, err := io.ReadFull(conn, methods); err != nil {
+		return
+	}
+
+	if auth == nil {
+		_, _ = conn.Write([]byte{5, 0})
+	} else {
+		_, _ = conn.Write([]byte{5, 2})
+
+		buf := make([]byte, 2)
+		if _, err := io.ReadFull(conn, buf); err != nil {
+			return
+		}
+		user := make([]byte, buf[1])
+		if _, err := io.ReadFull(conn, user); err != nil {
+			return
+		}
+		if _, err := io.ReadFull(conn, buf[:1]); err != nil {
+			return
+		}
+		password := make([]byte, buf[0])
+		if _, err := io.ReadFull(conn, password); err != nil {
+			return
+		}
+		if string(user) != auth.User || string(password) != auth.Password {
+			_, _ = conn.Write([]byte{1, 1})
+			return
+		}
+		_, _ = conn.Write([]byte{1, 0})
+	}
+
+	request := make([]byte, 4)
+	if _, err := io.ReadFull(conn, request); err != nil || request[1] != 1 {
+		return
+	}
+
+	var host string
+	switch request[3] {
+	case 1:
+		ip := make([]byte, 4)
+		if _, err := io.ReadFull(conn, ip); err != nil {
+			return
+		}
+		host = net.IP(ip).String()
+	case 3:
+		length := make([]byte, 1)
+		if _, err := io.ReadFull(conn, length); err != nil {
+			return
+		}
+		name := make([]byte, length[0])
+		if _, err := io.ReadFull(conn, name); err != nil {
+			return
+		}
+		host = string(name)
+	default:
+		return
+	}
+
+	port := make([]byte, 2)
+	if _, err := io.ReadFull(conn, port); err != nil {
+		return
+	}
+
+	target, err := net.Dial("tcp", net.JoinHostPort(host, strconv.Itoa(int(binary.BigEndian.Uint16(port)))))
+	if err != nil {
+		_, _ = conn.Write([]byte{5, 5, 0, 1, 0, 0, 0, 0, 0, 0})
+		return
+	}
+	defer func() { _ = target.Close() }()
+
+	_, _ = conn.Write([]byte{5, 0, 0, 1, 0, 0, 0, 0, 0, 0})
+
+	go func() { _, _ = io.Copy(target, conn) }()
+	_, _ = io.Copy(conn, target)
+}
+
+func TestSocks5Dialer(t *testing.T) {
+	echo := startEchoServer(t)
+
+	tests := []struct {
+		name      string
+		proxyAuth *proxy.Auth
+		auth      *proxy.Auth
+		wantErr   bool
+	}{
+		{"no auth", nil, nil, false},
+		{"auth", &proxy.Auth{User: "user", Password: "pass"}, &proxy.Auth{User: "user", Password: "pass"}, false},
+		{"wrong password", &proxy.Auth{User: "user", Password: "pass"}, &proxy.Auth{User: "user", Password: "wrong"}, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := require.New(t)
+
+			socksProxy := startSocks5Proxy(t, tt.proxyAuth)
+
+			dialer, err := NewContextDialer(&transport.ProxyConfiguration{
+				Type:    transport.ProxyTypeSocks5,
+				Address: socksProxy.Addr().String(),
+				Auth:    tt.auth,
+			}, &net.Dialer{})
+			req.NoError(err)
+
+			ctx, cancelF := context.WithTimeout(context.Background(), 5*time.Second)
+			defer cancelF()
+
+			conn, err := dialer.DialContext(ctx, "tcp", echo.Addr().String())
+			if tt.wantErr {
+				req.Error(err)
+				return
+			}
+			req.NoError(err)
+			defer func() { _ = conn.Close() }()
+
+			_, err = conn.Write([]byte("hello"))
+			req.NoError(err)
+
+			buf := make([]byte, 5)
+			_, err = io.ReadFull(conn, buf)
+			req.NoError(err)
+			req.Equal("hello", string(buf))
+		})
+	}
+}
+
+func TestNewContextDialerNoProxy(t *testing.T) {
+	req := require.New(t)
+
+	forward := &net.Dialer{}
+
+	dialer, err := NewContextDialer(nil, forward)
+	req.NoError(err)
+	req.Same(forward, dialer)
+
+	dialer, err = NewContextDialer(&transport.ProxyConfiguration{Type: transport.ProxyTypeNone}, forward)
+	req.NoError(err)
+	req.Same(forward, dialer)
+}
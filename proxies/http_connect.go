@@ -18,11 +18,14 @@ package proxies
 
 import (
 	"bufio"
+	"bytes"
 	"context"
+	"crypto/tls"
 	"io"
 	"net"
 	"net/http"
 	"net/url"
+	"strings"
 	"time"
 
 	"github.com/michaelquigley/pfxlog"
@@ -30,6 +33,14 @@ import (
 	"golang.org/x/net/proxy"
 )
 
+const (
+	// maxConnectAttempts limits the number of CONNECT requests sent while answering authentication challenges
+	maxConnectAttempts = 3
+
+	// maxErrorBodySize limits how much of the body of an unsuccessful response is read
+	maxErrorBodySize = 64 * 1024
+)
+
 func NewHttpConnectProxyDialer(dialer proxy.Dialer, addr string, auth *proxy.Auth, timeout time.Duration) *HttpConnectProxyDialer {
 	return &HttpConnectProxyDialer{
 		dialer:  dialer,
@@ -39,45 +50,256 @@ func NewHttpConnectProxyDialer(dialer proxy.Dialer, addr string, auth *proxy.Aut
 	}
 }
 
+// NewHttpsConnectProxyDialer returns a dialer which establishes TLS to the proxy server before issuing the CONNECT
+// request. If tlsConfig is nil or has no server name set, the host name from addr is used for SNI and verification
+func NewHttpsConnectProxyDialer(dialer proxy.Dialer, addr string, auth *proxy.Auth, tlsConfig *tls.Config, timeout time.Duration) *HttpConnectProxyDialer {
+	if tlsConfig == nil {
+		tlsConfig = &tls.Config{
+			MinVersion: tls.VersionTLS12,
+		}
+	}
+
+	if tlsConfig.ServerName == "" {
+		tlsConfig = tlsConfig.Clone()
+		if host, _, err := net.SplitHostPort(addr); err == nil {
+			tlsConfig.ServerName = host
+		} else {
+			tlsConfig.ServerName = addr
+		}
+	}
+
+	result := NewHttpConnectProxyDialer(dialer, addr, auth, timeout)
+	result.tlsConfig = tlsConfig
+	return result
+}
+
 type HttpConnectProxyDialer struct {
-	dialer  proxy.Dialer
-	address string
-	auth    *proxy.Auth
-	timeout time.Duration
+	dialer    proxy.Dialer
+	address   string
+	auth      *proxy.Auth
+	timeout   time.Duration
+	tlsConfig *tls.Config
 }
 
 func (self *HttpConnectProxyDialer) Dial(network, addr string) (net.Conn, error) {
-	dialer := self.dialer
-	if dialer == nil {
-		dialer = &net.Dialer{Timeout: self.timeout}
+	ctx, cancelF := self.timeoutContext()
+	defer cancelF()
+	return self.DialContext(ctx, network, addr)
+}
+
+// DialContext connects to the proxy server and asks it to connect to the given address. The context deadline and
+// cancellation apply to both connecting to the proxy and to the CONNECT request/response exchange
+func (self *HttpConnectProxyDialer) DialContext(ctx context.Context, network, addr string) (net.Conn, error) {
+	c, err := self.dialProxy(ctx, network)
+	if err != nil {
+		return nil, err
+	}
+
+	result, live, err := self.connect(ctx, network, c, addr)
+	if err != nil {
+		// c may already have been closed and replaced during authentication, so only the live connection is closed
+		if live != nil {
+			if closeErr := live.Close(); closeErr != nil {
+				pfxlog.Logger().WithError(closeErr).Error("failed to close connection to proxy after connect error")
+			}
+		}
+		return nil, err
+	}
+
+	return result, nil
+}
+
+func (self *HttpConnectProxyDialer) dialProxy(ctx context.Context, network string) (net.Conn, error) {
+	var c net.Conn
+	var err error
+
+	if dialer, ok := self.dialer.(proxy.ContextDialer); ok {
+		c, err = dialer.DialContext(ctx, network, self.address)
+	} else if self.dialer != nil {
+		c, err = self.dialer.Dial(network, self.address)
+	} else {
+		dialer := &net.Dialer{Timeout: self.timeout}
+		c, err = dialer.DialContext(ctx, network, self.address)
 	}
-	c, err := dialer.Dial(network, self.address)
+
 	if err != nil {
 		return nil, errors.Wrapf(err, "unable to connect to proxy server at %s", self.address)
 	}
 
-	if err = self.Connect(c, addr); err != nil {
-		if closeErr := c.Close(); closeErr != nil {
-			pfxlog.Logger().WithError(closeErr).Error("failed to close connection to proxy after connect error")
+	if self.tlsConfig != nil {
+		tlsConn := tls.Client(c, self.tlsConfig)
+		if err = tlsConn.HandshakeContext(ctx); err != nil {
+			_ = c.Close()
+			return nil, errors.Wrapf(err, "tls handshake with proxy server at %s failed", self.address)
 		}
-		return nil, err
+		c = tlsConn
 	}
 
 	return c, nil
 }
 
+// Connect issues a CONNECT request for the given address over an existing connection to the proxy server.
+//
+// Deprecated: if the destination sends data immediately after the proxy response, it can't be passed on to the
+// caller, so an error is returned. Use ConnectContext and read from the returned connection instead.
 func (self *HttpConnectProxyDialer) Connect(c net.Conn, addr string) error {
+	ctx, cancelF := self.timeoutContext()
+	defer cancelF()
+	conn, err := self.ConnectContext(ctx, c, addr)
+	if err != nil {
+		return err
+	}
+
+	underlying := conn
+	if buffered, ok := conn.(*bufferedConn); ok {
+		underlying = buffered.Conn
+	}
+
+	if underlying != c {
+		// the proxy closed c during authentication, so the caller has no way to use the new connection
+		_ = conn.Close()
+		return errors.Errorf("proxy server at %s closed the connection during authentication", self.address)
+	}
+
+	if conn != c {
+		// c is still usable, but the bytes which were read from it along with the response can't be replayed
+		return errors.Errorf("data received from %s along with the connect response would be lost, use ConnectContext instead", addr)
+	}
+	return nil
+}
+
+// ConnectContext issues a CONNECT request for the given address over an existing connection to the proxy server.
+// If the context expires or is cancelled before the proxy has responded, the exchange is aborted. The returned
+// connection should be used in place of c, as it replays any bytes which were received from the destination
+// along with the proxy response.
+//
+// Credentials, if configured, are sent using Basic authentication up front. If the proxy responds with a 407
+// challenge, the request is retried using the strongest offered scheme which is supported. If the proxy closes
+// the connection after the challenge, a new connection to the proxy is made, in which case c is closed and the
+// returned connection is different from c. If none of the offered schemes are supported, an
+// *UnsupportedProxyAuthError is returned.
+func (self *HttpConnectProxyDialer) ConnectContext(ctx context.Context, c net.Conn, addr string) (net.Conn, error) {
+	result, live, err := self.connect(ctx, "tcp", c, addr)
+	if err != nil && live != nil && live != c {
+		// c is left for the caller to close, but a connection made to replace it isn't visible to the caller
+		_ = live.Close()
+	}
+	return result, err
+}
+
+// connect implements ConnectContext. If the proxy has to be re-dialed during authentication, the given network is
+// used. On failure, it also returns the connection to the proxy which is still open, which is c unless it was
+// replaced during authentication, or nil if there is none
+func (self *HttpConnectProxyDialer) connect(ctx context.Context, network string, c net.Conn, addr string) (net.Conn, net.Conn, error) {
 	log := pfxlog.Logger()
 
 	log.Debugf("create connect request to %s", addr)
 
-	ctx := context.Background()
-	if self.timeout > 0 {
-		timeoutCtx, cancelF := context.WithTimeout(ctx, self.timeout)
-		defer cancelF()
-		ctx = timeoutCtx
+	conn := c
+	reader := bufio.NewReader(conn)
+
+	fail := func(err error) (net.Conn, net.Conn, error) {
+		return nil, conn, err
+	}
+
+	var authorization string
+	if self.auth != nil {
+		authorization = basicAuthorization(self.auth)
+	}
+
+	var challenge *digestChallenge
+
+	for attempt := 1; ; attempt++ {
+		resp, respBody, err := self.roundTrip(ctx, conn, reader, addr, authorization)
+		if err != nil {
+			return fail(err)
+		}
+
+		if resp.StatusCode == http.StatusOK {
+			return self.newConn(conn, reader, addr), nil, nil
+		}
+
+		if resp.StatusCode != http.StatusProxyAuthRequired || self.auth == nil || attempt >= maxConnectAttempts {
+			log.Errorf("proxy returned: %s", string(respBody))
+			return fail(errors.Errorf("received %v instead of 200 OK in response to connect request to proxy server at %s", resp.StatusCode, self.address))
+		}
+
+		challenges := parseChallenges(resp.Header.Values("Proxy-Authenticate"))
+		authorization, challenge, err = self.respondToChallenge(challenges, challenge, addr)
+		if err != nil {
+			return fail(err)
+		}
+
+		if resp.Close {
+			log.Debugf("proxy server at %s closed connection after authentication challenge, reconnecting", self.address)
+			_ = conn.Close()
+			if conn, err = self.dialProxy(ctx, network); err != nil {
+				return nil, nil, err
+			}
+			reader = bufio.NewReader(conn)
+		}
+	}
+}
+
+// respondToChallenge picks the strongest supported scheme from the challenges in a 407 response and computes the
+// matching Proxy-Authorization value. previous is the digest challenge which was answered in the last request, if
+// any, so that a repeated challenge can be told apart from a stale nonce
+func (self *HttpConnectProxyDialer) respondToChallenge(challenges []*authChallenge, previous *digestChallenge, addr string) (string, *digestChallenge, error) {
+	var basicOffered bool
+	var best *digestChallenge
+	for _, challenge := range challenges {
+		switch {
+		case strings.EqualFold(challenge.scheme, "Basic"):
+			basicOffered = true
+		case strings.EqualFold(challenge.scheme, "Digest"):
+			if digest := newDigestChallenge(challenge); digest != nil && (best == nil || digest.strength() > best.strength()) {
+				best = digest
+			}
+		}
 	}
 
+	if best != nil {
+		if previous != nil && previous.realm == best.realm && !best.stale {
+			return "", nil, errors.Errorf("proxy server at %s rejected digest credentials for user %s", self.address, self.auth.User)
+		}
+		return best.authorization(self.auth, http.MethodConnect, addr, newCnonce()), best, nil
+	}
+
+	if basicOffered {
+		// basic credentials are always sent with the initial request, so they must have been rejected
+		return "", nil, errors.Errorf("proxy server at %s rejected basic credentials for user %s", self.address, self.auth.User)
+	}
+
+	result := &UnsupportedProxyAuthError{Address: self.address}
+	for _, challenge := range challenges {
+		result.Schemes = append(result.Schemes, challenge.scheme)
+	}
+	return "", nil, result
+}
+
+// roundTrip sends a CONNECT request and reads the response. The body of an unsuccessful response is read, so that
+// the connection can be reused for a subsequent request
+func (self *HttpConnectProxyDialer) roundTrip(ctx context.Context, c net.Conn, reader *bufio.Reader, addr, authorization string) (resp *http.Response, respBody []byte, err error) {
+	if deadline, ok := ctx.Deadline(); ok {
+		if err = c.SetDeadline(deadline); err != nil {
+			return nil, nil, errors.Wrapf(err, "unable to set deadline on connection to proxy server at %s", self.address)
+		}
+	}
+
+	// unblock any pending read or write if the context is cancelled
+	stop := context.AfterFunc(ctx, func() {
+		_ = c.SetDeadline(time.Unix(1, 0))
+	})
+
+	defer func() {
+		if !stop() && err == nil {
+			err = errors.Wrapf(ctx.Err(), "connect request to proxy server at %s cancelled", self.address)
+		}
+		if err == nil {
+			err = c.SetDeadline(time.Time{})
+		}
+	}()
+
 	req := &http.Request{
 		Method: http.MethodConnect,
 		URL:    &url.URL{Host: addr},
@@ -86,30 +308,68 @@ func (self *HttpConnectProxyDialer) Connect(c net.Conn, addr string) error {
 		Close:  false,
 	}
 	req = req.WithContext(ctx)
-	if self.auth != nil {
-		req.SetBasicAuth(self.auth.User, self.auth.Password)
+	if authorization != "" {
+		req.Header.Set("Proxy-Authorization", authorization)
 	}
 	req.Header.Set("User-Agent", "ziti-transport")
 
-	if err := req.Write(c); err != nil {
-		return errors.Wrapf(err, "unable to send connect request to proxy server at %s", self.address)
+	if err = req.Write(c); err != nil {
+		return nil, nil, errors.Wrapf(err, "unable to send connect request to proxy server at %s", self.address)
 	}
 
-	resp, err := http.ReadResponse(bufio.NewReader(c), req)
+	resp, err = http.ReadResponse(reader, req)
 	if err != nil {
-		return errors.Wrapf(err, "unable to read response to connect request to proxy server at %s", self.address)
+		return nil, nil, errors.Wrapf(err, "unable to read response to connect request to proxy server at %s", self.address)
 	}
 
-	defer func() {
-		log.Debug("closing resp body")
+	if resp.StatusCode != http.StatusOK {
+		respBody, err = io.ReadAll(io.LimitReader(resp.Body, maxErrorBodySize+1))
 		_ = resp.Body.Close()
-	}()
+		if err != nil {
+			return nil, nil, errors.Wrapf(err, "unable to read response to connect request to proxy server at %s", self.address)
+		}
+		if len(respBody) > maxErrorBodySize {
+			// the remainder of the body is still unread, so the connection can't be reused
+			respBody = respBody[:maxErrorBodySize]
+			resp.Close = true
+		}
+	}
 
-	if resp.StatusCode != http.StatusOK {
-		respBody, _ := io.ReadAll(resp.Body)
-		log.Errorf("proxy returned: %s", string(respBody))
-		return errors.Errorf("received %v instead of 200 OK in response to connect request to proxy server at %s", resp.StatusCode, self.address)
+	return resp, respBody, nil
+}
+
+func (self *HttpConnectProxyDialer) newConn(c net.Conn, reader *bufio.Reader, addr string) net.Conn {
+	// a successful CONNECT response has no body, so anything already buffered was sent by the destination
+	if reader.Buffered() == 0 {
+		return c
 	}
 
-	return nil
+	buffered, _ := reader.Peek(reader.Buffered())
+	pfxlog.Logger().Debugf("%d bytes received from %s along with connect response", len(buffered), addr)
+	return &bufferedConn{
+		Conn:     c,
+		buffered: bytes.Clone(buffered),
+	}
+}
+
+func (self *HttpConnectProxyDialer) timeoutContext() (context.Context, context.CancelFunc) {
+	if self.timeout > 0 {
+		return context.WithTimeout(context.Background(), self.timeout)
+	}
+	return context.WithCancel(context.Background())
+}
+
+// bufferedConn returns bytes which were read ahead from the underlying connection before reading from it again
+type bufferedConn struct {
+	net.Conn
+	buffered []byte
+}
+
+func (self *bufferedConn) Read(b []byte) (int, error) {
+	if len(self.buffered) > 0 {
+		n := copy(b, self.buffered)
+		self.buffered = self.buffered[n:]
+		return n, nil
+	}
+	return self.Conn.Read(b)
 }
@@ -0,0 +1,278 @@
+/*
+	Copyright NetFoundry Inc.
+
+	Licensed under the Apache License, Version 2.0 (the "License");
+	you may not use this file except in compliance with the License.
+	You may obtain a copy of the License at
+
+	https://www.apache.org/licenses/LICENSE-2.0
+
+	Unless required by applicable law or agreed to in writing, software
+	distributed under the License is distributed on an "AS IS" BASIS,
+	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+	See the License for the specific language governing permissions and
+	limitations under the License.
+*/
+
+package proxies
+
+import (
+	"crypto/md5"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"strings"
+
+	"golang.org/x/net/proxy"
+)
+
+// UnsupportedProxyAuthError is returned when a proxy server requires authentication, but none of the
+// authentication schemes it offers are supported
+type UnsupportedProxyAuthError struct {
+	Address string
+	Schemes []string
+}
+
+func (self *UnsupportedProxyAuthError) Error() string {
+	return fmt.Sprintf("proxy server at %s requires unsupported authentication scheme(s) [%s], supported schemes are [Basic, Digest]",
+		self.Address, strings.Join(self.Schemes, ", "))
+}
+
+func basicAuthorization(auth *proxy.Auth) string {
+	return "Basic " + base64.StdEncoding.EncodeToString([]byte(auth.User+":"+auth.Password))
+}
+
+// authChallenge is a single challenge from a Proxy-Authenticate header, as defined in RFC 7235
+type authChallenge struct {
+	scheme string
+	params map[string]string
+}
+
+// parseChallenges parses the values of the Proxy-Authenticate headers of a response. A single header value may hold
+// multiple challenges
+func parseChallenges(headers []string) []*authChallenge {
+	var result []*authChallenge
+	for _, header := range headers {
+		p := &challengeParser{input: header}
+		var current *authChallenge
+		for {
+			p.skip(" \t,")
+			token := p.token()
+			if token == "" {
+				break
+			}
+
+			p.skip(" \t")
+			if current != nil && p.peek() == '=' {
+				p.pos++
+				p.skip(" \t")
+				var value string
+				if p.peek() == '"' {
+					value = p.quoted()
+				} else {
+					value = p.token()
+				}
+				current.params[strings.ToLower(token)] = value
+				continue
+			}
+
+			// a token which isn't followed by '=' starts a new challenge. Schemes like Negotiate may carry a token68
+			// value instead of parameters, which isn't needed by any supported scheme
+			current = &authChallenge{
+				scheme: token,
+				params: map[string]string{},
+			}
+			result = append(result, current)
+
+			p.skipToken68()
+		}
+	}
+	return result
+}
+
+type challengeParser struct {
+	input string
+	pos   int
+}
+
+func (self *challengeParser) peek() byte {
+	if self.pos < len(self.input) {
+		return self.input[self.pos]
+	}
+	return 0
+}
+
+func (self *challengeParser) skip(chars string) {
+	for self.pos < len(self.input) && strings.IndexByte(chars, self.input[self.pos]) >= 0 {
+		self.pos++
+	}
+}
+
+func (self *challengeParser) token() string {
+	start := self.pos
+	for self.pos < len(self.input) && isTokenChar(self.input[self.pos]) {
+		self.pos++
+	}
+	return self.input[start:self.pos]
+}
+
+// skipToken68 skips a token68 value, as used by schemes like Negotiate, if the input at the current position is one.
+// Otherwise, the position is left unchanged
+func (self *challengeParser) skipToken68() {
+	start := self.pos
+	for self.pos < len(self.input) && (isTokenChar(self.input[self.pos]) || self.input[self.pos] == '/') {
+		self.pos++
+	}
+	for self.pos < len(self.input) && self.input[self.pos] == '=' {
+		self.pos++
+	}
+
+	end := self.pos
+	self.skip(" \t")
+	if end == start || (self.pos < len(self.input) && self.input[self.pos] != ',') {
+		self.pos = start
+	}
+}
+
+func (self *challengeParser) quoted() string {
+	var result strings.Builder
+	self.pos++ // opening quote
+	for self.pos < len(self.input) {
+		c := self.input[self.pos]
+		self.pos++
+		switch {
+		case c == '"':
+			return result.String()
+		case c == '\\' && self.pos < len(self.input):
+			result.WriteByte(self.input[self.pos])
+			self.pos++
+		default:
+			result.WriteByte(c)
+		}
+	}
+	return result.String()
+}
+
+func isTokenChar(c byte) bool {
+	if c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' {
+		return true
+	}
+	return strings.IndexByte("!#$%&'*+-.^_`|~", c) >= 0
+}
+
+// digestChallenge holds the parameters of a Digest challenge, as defined in RFC 7616. Only the auth quality of
+// protection is supported, along with the legacy RFC 2069 mode where the proxy doesn't specify a qop
+type digestChallenge struct {
+	realm     string
+	nonce     string
+	opaque    string
+	algorithm string
+	sess      bool
+	qop       bool
+	stale     bool
+	newHash   func() hash.Hash
+}
+
+// newDigestChallenge returns the digest challenge for the given auth challenge, or nil if the challenge uses an
+// unsupported algorithm or quality of protection
+func newDigestChallenge(challenge *authChallenge) *digestChallenge {
+	result := &digestChallenge{
+		realm:     challenge.params["realm"],
+		nonce:     challenge.params["nonce"],
+		opaque:    challenge.params["opaque"],
+		algorithm: challenge.params["algorithm"],
+		stale:     strings.EqualFold(challenge.params["stale"], "true"),
+	}
+
+	if result.nonce == "" {
+		return nil
+	}
+
+	if result.algorithm == "" {
+		result.algorithm = "MD5"
+	}
+
+	algorithm := strings.ToUpper(result.algorithm)
+	if strings.HasSuffix(algorithm, "-SESS") {
+		result.sess = true
+		algorithm = strings.TrimSuffix(algorithm, "-SESS")
+	}
+
+	switch algorithm {
+	case "MD5":
+		result.newHash = md5.New
+	case "SHA-256":
+		result.newHash = sha256.New
+	default:
+		return nil
+	}
+
+	if qop, found := challenge.params["qop"]; found {
+		for _, option := range strings.Split(qop, ",") {
+			if strings.EqualFold(strings.TrimSpace(option), "auth") {
+				result.qop = true
+			}
+		}
+		if !result.qop {
+			return nil
+		}
+	}
+
+	return result
+}
+
+func (self *digestChallenge) strength() int {
+	if self.newHash().Size() > md5.Size {
+		return 2
+	}
+	return 1
+}
+
+func (self *digestChallenge) hash(values ...string) string {
+	h := self.newHash()
+	h.Write([]byte(strings.Join(values, ":")))
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// authorization computes the Proxy-Authorization header value answering this challenge
+func (self *digestChallenge) authorization(auth *proxy.Auth, method, uri, cnonce string) string {
+	const nc = "00000001"
+
+	ha1 := self.hash(auth.User, self.realm, auth.Password)
+	if self.sess {
+		ha1 = self.hash(ha1, self.nonce, cnonce)
+	}
+	ha2 := self.hash(method, uri)
+
+	var response string
+	if self.qop {
+		response = self.hash(ha1, self.nonce, nc, cnonce, "auth", ha2)
+	} else {
+		response = self.hash(ha1, self.nonce, ha2)
+	}
+
+	var b strings.Builder
+	b.WriteString("Digest ")
+	fmt.Fprintf(&b, `username=%s, realm=%s, nonce=%s, uri=%s, algorithm=%s, response=%s`,
+		quote(auth.User), quote(self.realm), quote(self.nonce), quote(uri), self.algorithm, quote(response))
+	if self.qop {
+		fmt.Fprintf(&b, `, qop=auth, nc=%s, cnonce=%s`, nc, quote(cnonce))
+	}
+	if self.opaque != "" {
+		fmt.Fprintf(&b, `, opaque=%s`, quote(self.opaque))
+	}
+	return b.String()
+}
+
+func newCnonce() string {
+	cnonce := make([]byte, 16)
+	_, _ = rand.Read(cnonce)
+	return hex.EncodeToString(cnonce)
+}
+
+func quote(s string) string {
+	return `"` + strings.NewReplacer(`\`, `\\`, `"`, `\"`).Replace(s) + `"`
+}
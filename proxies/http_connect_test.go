@@ -0,0 +1,579 @@
+package proxies
+
+import (
+	"bufio"
+	"context"
+	"crypto/md5"
+	"crypto/sha256"
+	"crypto/tls"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"hash"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/openziti/transport/v2"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/net/proxy"
+)
+
+// startHttpsConnectProxy starts a TLS CONNECT proxy which requires the given basic auth credentials
+func startHttpsConnectProxy(t *testing.T, auth *proxy.Auth) *httptest.Server {
+	server := httptest.NewTLSServer(connectProxyHandler(auth))
+	t.Cleanup(server.Close)
+	return server
+}
+
+// connectProxyHandler handles CONNECT requests which carry the given basic auth credentials in the
+// Proxy-Authorization header, relaying data between the client and the requested destination
+func connectProxyHandler(auth *proxy.Auth) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodConnect {
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+
+		expected := "Basic " + base64.StdEncoding.EncodeToString([]byte(auth.User+":"+auth.Password))
+		if r.Header.Get("Proxy-Authorization") != expected {
+			w.WriteHeader(http.StatusProxyAuthRequired)
+			return
+		}
+
+		target, err := net.Dial("tcp", r.Host)
+		if err != nil {
+			w.WriteHeader(http.StatusBadGateway)
+			return
+		}
+		defer func() { _ = target.Close() }()
+
+		conn, rw, err := http.NewResponseController(w).Hijack()
+		if err != nil {
+			return
+		}
+		defer func() { _ = conn.Close() }()
+
+		if _, err = conn.Write([]byte("HTTP/1.1 200 Connection established\r\n\r\n")); err != nil {
+			return
+		}
+
+		go func() { _, _ = io.Copy(target, rw) }()
+		_, _ = io.Copy(conn, target)
+	})
+}
+
+func TestHttpsConnectProxyDialer(t *testing.T) {
+	echo := startEchoServer(t)
+	auth := &proxy.Auth{User: "user", Password: "pass"}
+	httpsProxy := startHttpsConnectProxy(t, auth)
+	rootCAs := httpsProxy.Client().Transport.(*http.Transport).TLSClientConfig.RootCAs
+
+	tests := []struct {
+		name      string
+		tlsConfig *tls.Config
+		auth      *proxy.Auth
+		wantErr   bool
+	}{
+		{"trusted ca", &tls.Config{RootCAs: rootCAs}, auth, false},
+		{"server name override", &tls.Config{RootCAs: rootCAs, ServerName: "example.com"}, auth, false},
+		{"untrusted ca", nil, auth, true},
+		{"server name mismatch", &tls.Config{RootCAs: rootCAs, ServerName: "proxy.corp"}, auth, true},
+		{"wrong password", &tls.Config{RootCAs: rootCAs}, &proxy.Auth{User: "user", Password: "wrong"}, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := require.New(t)
+
+			dialer, err := NewContextDialer(&transport.ProxyConfiguration{
+				Type:      transport.ProxyTypeHttpsConnect,
+				Address:   httpsProxy.Listener.Addr().String(),
+				Auth:      tt.auth,
+				TLSConfig: tt.tlsConfig,
+			}, &net.Dialer{})
+			req.NoError(err)
+
+			ctx, cancelF := context.WithTimeout(context.Background(), 5*time.Second)
+			defer cancelF()
+
+			conn, err := dialer.DialContext(ctx, "tcp", echo.Addr().String())
+			if tt.wantErr {
+				req.Error(err)
+				return
+			}
+			req.NoError(err)
+			defer func() { _ = conn.Close() }()
+
+			_, isTls := conn.(*tls.Conn)
+			req.True(isTls)
+
+			_, err = conn.Write([]byte("hello"))
+			req.NoError(err)
+
+			buf := make([]byte, 5)
+			_, err = io.ReadFull(conn, buf)
+			req.NoError(err)
+			req.Equal("hello", string(buf))
+		})
+	}
+}
+
+func TestHttpConnectProxyDialerBasicAuth(t *testing.T) {
+	echo := startEchoServer(t)
+	auth := &proxy.Auth{User: "user", Password: "pass"}
+
+	// plain http proxies also get the credentials in the Proxy-Authorization header, rather than Authorization
+	httpProxy := httptest.NewServer(connectProxyHandler(auth))
+	t.Cleanup(httpProxy.Close)
+
+	tests := []struct {
+		name    string
+		auth    *proxy.Auth
+		wantErr bool
+	}{
+		{"valid credentials", auth, false},
+		{"wrong password", &proxy.Auth{User: "user", Password: "wrong"}, true},
+		{"no credentials", nil, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := require.New(t)
+
+			dialer := NewHttpConnectProxyDialer(&net.Dialer{}, httpProxy.Listener.Addr().String(), tt.auth, 5*time.Second)
+
+			conn, err := dialer.Dial("tcp", echo.Addr().String())
+			if tt.wantErr {
+				req.Error(err)
+				return
+			}
+			req.NoError(err)
+			defer func() { _ = conn.Close() }()
+
+			_, err = conn.Write([]byte("hello"))
+			req.NoError(err)
+
+			buf := make([]byte, 5)
+			_, err = io.ReadFull(conn, buf)
+			req.NoError(err)
+			req.Equal("hello", string(buf))
+		})
+	}
+}
+
+// startPipeliningProxy starts a fake CONNECT proxy which sends the given response followed by the given
+// destination data in a single write, then echoes back whatever it receives
+func startPipeliningProxy(t *testing.T, response string, data []byte) net.Listener {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = listener.Close() })
+
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer func() { _ = conn.Close() }()
+
+				req, err := http.ReadRequest(bufio.NewReader(conn))
+				if err != nil || req.Method != http.MethodConnect {
+					return
+				}
+
+				if _, err = conn.Write(append([]byte(response), data...)); err != nil {
+					return
+				}
+				_, _ = io.Copy(conn, conn)
+			}()
+		}
+	}()
+
+	return listener
+}
+
+func TestHttpConnectProxyDialerPreservesBufferedData(t *testing.T) {
+	tests := []struct {
+		name     string
+		response string
+		data     []byte
+	}{
+		{"no data", "HTTP/1.1 200 Connection established\r\n\r\n", nil},
+		{"server banner", "HTTP/1.1 200 Connection established\r\n\r\n", []byte("SSH-2.0-OpenSSH_9.6\r\n")},
+		{"server banner with headers", "HTTP/1.1 200 OK\r\nProxy-Agent: test\r\n\r\n", []byte("220 smtp.example.com ESMTP\r\n")},
+		{"data exceeding read buffer", "HTTP/1.1 200 OK\r\n\r\n", []byte(strings.Repeat("0123456789", 1000))},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := require.New(t)
+
+			httpProxy := startPipeliningProxy(t, tt.response, tt.data)
+			dialer := NewHttpConnectProxyDialer(&net.Dialer{}, httpProxy.Addr().String(), nil, 5*time.Second)
+
+			conn, err := dialer.Dial("tcp", "ctrl.example.com:443")
+			req.NoError(err)
+			defer func() { _ = conn.Close() }()
+
+			req.NoError(conn.SetReadDeadline(time.Now().Add(5 * time.Second)))
+
+			// the data the destination sent along with the proxy response must come first
+			if len(tt.data) > 0 {
+				buf := make([]byte, len(tt.data))
+				_, err = io.ReadFull(conn, buf)
+				req.NoError(err)
+				req.Equal(tt.data, buf)
+			}
+
+			// followed by anything read from the socket afterward
+			_, err = conn.Write([]byte("hello"))
+			req.NoError(err)
+
+			buf := make([]byte, 5)
+			_, err = io.ReadFull(conn, buf)
+			req.NoError(err)
+			req.Equal("hello", string(buf))
+		})
+	}
+}
+
+func TestHttpConnectProxyDialerErrorResponse(t *testing.T) {
+	req := require.New(t)
+
+	httpProxy := startPipeliningProxy(t, "HTTP/1.1 403 Forbidden\r\nContent-Length: 6\r\n\r\ndenied", nil)
+	dialer := NewHttpConnectProxyDialer(&net.Dialer{}, httpProxy.Addr().String(), nil, 5*time.Second)
+
+	conn, err := dialer.Dial("tcp", "ctrl.example.com:443")
+	req.Error(err)
+	req.Nil(conn)
+	req.Contains(err.Error(), "403")
+}
+
+func TestParseChallenges(t *testing.T) {
+	req := require.New(t)
+
+	challenges := parseChallenges([]string{
+		`Negotiate, NTLM`,
+		`Digest realm="proxy \"corp\"", nonce="abc=", qop="auth,auth-int", algorithm=SHA-256, stale=TRUE`,
+		`Negotiate YIIBhwYGKwYBBQUCoIIBezCCAXeg==, Basic realm = "corp"`,
+	})
+
+	req.Len(challenges, 5)
+	req.Equal("Negotiate", challenges[0].scheme)
+	req.Equal("NTLM", challenges[1].scheme)
+	req.Equal("Digest", challenges[2].scheme)
+	req.Equal(map[string]string{
+		"realm":     `proxy "corp"`,
+		"nonce":     "abc=",
+		"qop":       "auth,auth-int",
+		"algorithm": "SHA-256",
+		"stale":     "TRUE",
+	}, challenges[2].params)
+	req.Equal("Negotiate", challenges[3].scheme)
+	req.Empty(challenges[3].params)
+	req.Equal("Basic", challenges[4].scheme)
+	req.Equal(map[string]string{"realm": "corp"}, challenges[4].params)
+}
+
+func TestDigestAuthorization(t *testing.T) {
+	// example from RFC 7616, section 3.9.1
+	auth := &proxy.Auth{User: "Mufasa", Password: "Circle of Life"}
+	const cnonce = "f2/wE4q74E6zIJEtWaHKaf5wv/H5QzzpXusqGemxURZJ"
+
+	tests := []struct {
+		algorithm string
+		response  string
+	}{
+		{"MD5", "8ca523f5e9506fed4657c9700eebdbec"},
+		{"SHA-256", "753927fa0e85d155564e2e272a28d1802ca10daf4496794697cf8db5856cb6c1"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.algorithm, func(t *testing.T) {
+			req := require.New(t)
+
+			challenges := parseChallenges([]string{`Digest realm="http-auth@example.org", qop="auth, auth-int", algorithm=` + tt.algorithm +
+				`, nonce="7ypf/xlj9XXwfDPEoM4URrv/xwf94BcCAzFZH4GiTo0v", opaque="FQhe/qaU925kfnzjCev0ciny7QMkPqMAFRtzCUYo5tdS"`})
+			req.Len(challenges, 1)
+
+			digest := newDigestChallenge(challenges[0])
+			req.NotNil(digest)
+
+			authorization := parseChallenges([]string{digest.authorization(auth, http.MethodGet, "/dir/index.html", cnonce)})
+			req.Len(authorization, 1)
+			req.Equal("Digest", authorization[0].scheme)
+			req.Equal(tt.response, authorization[0].params["response"])
+			req.Equal("auth", authorization[0].params["qop"])
+			req.Equal("00000001", authorization[0].params["nc"])
+			req.Equal(cnonce, authorization[0].params["cnonce"])
+			req.Equal("FQhe/qaU925kfnzjCev0ciny7QMkPqMAFRtzCUYo5tdS", authorization[0].params["opaque"])
+		})
+	}
+}
+
+func TestNewDigestChallengeUnsupported(t *testing.T) {
+	for _, header := range []string{
+		`Digest realm="corp", nonce="abc", algorithm=SHA-512-256`,
+		`Digest realm="corp", nonce="abc", qop="auth-int"`,
+		`Digest realm="corp"`,
+	} {
+		challenges := parseChallenges([]string{header})
+		require.Len(t, challenges, 1)
+		require.Nil(t, newDigestChallenge(challenges[0]), header)
+	}
+}
+
+type digestProxy struct {
+	listener    net.Listener
+	auth        *proxy.Auth
+	algorithm   string
+	keepAlive   bool
+	staleFirst  bool
+	schemes     []string
+	connections atomic.Int32
+	nonces      atomic.Int32
+}
+
+// startDigestProxy starts a fake CONNECT proxy which requires digest authentication, offering the given
+// challenges. Once authenticated, it echoes back whatever it receives
+func startDigestProxy(t *testing.T, p *digestProxy) *digestProxy {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = listener.Close() })
+	p.listener = listener
+
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			p.connections.Add(1)
+			go p.serve(conn)
+		}
+	}()
+
+	return p
+}
+
+func (self *digestProxy) serve(conn net.Conn) {
+	defer func() { _ = conn.Close() }()
+
+	reader := bufio.NewReader(conn)
+	for {
+		req, err := http.ReadRequest(reader)
+		if err != nil || req.Method != http.MethodConnect {
+			return
+		}
+
+		if self.authorized(req) {
+			if _, err = conn.Write([]byte("HTTP/1.1 200 Connection established\r\n\r\n")); err != nil {
+				return
+			}
+			_, _ = io.Copy(conn, reader)
+			return
+		}
+
+		stale := self.staleFirst && self.nonces.Load() == 1
+		nonce := "nonce-" + strconv.Itoa(int(self.nonces.Add(1)))
+
+		resp := "HTTP/1.1 407 Proxy Authentication Required\r\n"
+		for _, scheme := range self.schemes {
+			if scheme == "Digest" {
+				scheme = `Digest realm="corp", qop="auth", algorithm=` + self.algorithm + `, nonce="` + nonce + `", opaque="xyz"`
+				if stale {
+					scheme += ", stale=true"
+				}
+			}
+			resp += "Proxy-Authenticate: " + scheme + "\r\n"
+		}
+		if !self.keepAlive {
+			resp += "Connection: close\r\n"
+		}
+		resp += "Content-Length: 6\r\n\r\ndenied"
+
+		if _, err = conn.Write([]byte(resp)); err != nil || !self.keepAlive {
+			return
+		}
+	}
+}
+
+func (self *digestProxy) authorized(req *http.Request) bool {
+	challenges := parseChallenges([]string{req.Header.Get("Proxy-Authorization")})
+	if len(challenges) != 1 || challenges[0].scheme != "Digest" {
+		return false
+	}
+
+	params := challenges[0].params
+	if self.staleFirst && params["nonce"] == "nonce-1" {
+		return false
+	}
+
+	var newHash func() hash.Hash = md5.New
+	if self.algorithm == "SHA-256" {
+		newHash = sha256.New
+	}
+
+	h := func(s string) string {
+		digest := newHash()
+		digest.Write([]byte(s))
+		return hex.EncodeToString(digest.Sum(nil))
+	}
+
+	ha1 := h(self.auth.User + ":corp:" + self.auth.Password)
+	ha2 := h(http.MethodConnect + ":" + req.Host)
+	expected := h(ha1 + ":" + params["nonce"] + ":" + params["nc"] + ":" + params["cnonce"] + ":auth:" + ha2)
+
+	return params["username"] == self.auth.User && params["uri"] == req.Host && params["opaque"] == "xyz" &&
+		params["qop"] == "auth" && params["response"] == expected
+}
+
+func TestHttpConnectProxyDialerDigest(t *testing.T) {
+	auth := &proxy.Auth{User: "user", Password: "pass"}
+
+	tests := []struct {
+		name            string
+		proxy           *digestProxy
+		auth            *proxy.Auth
+		wantConnections int32
+		wantErr         bool
+	}{
+		{"md5 keep alive", &digestProxy{auth: auth, algorithm: "MD5", keepAlive: true, schemes: []string{"Digest"}}, auth, 1, false},
+		{"sha-256 keep alive", &digestProxy{auth: auth, algorithm: "SHA-256", keepAlive: true, schemes: []string{"Basic realm=\"corp\"", "Digest"}}, auth, 1, false},
+		{"md5 connection close", &digestProxy{auth: auth, algorithm: "MD5", schemes: []string{"Digest"}}, auth, 2, false},
+		{"stale nonce", &digestProxy{auth: auth, algorithm: "MD5", keepAlive: true, staleFirst: true, schemes: []string{"Digest"}}, auth, 1, false},
+		{"wrong password", &digestProxy{auth: auth, algorithm: "MD5", keepAlive: true, schemes: []string{"Digest"}}, &proxy.Auth{User: "user", Password: "wrong"}, 1, true},
+		{"basic rejected", &digestProxy{auth: auth, algorithm: "MD5", keepAlive: true, schemes: []string{"Basic realm=\"corp\""}}, auth, 1, true},
+		{"no credentials", &digestProxy{auth: auth, algorithm: "MD5", keepAlive: true, schemes: []string{"Digest"}}, nil, 1, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := require.New(t)
+
+			p := startDigestProxy(t, tt.proxy)
+			dialer := NewHttpConnectProxyDialer(&net.Dialer{}, p.listener.Addr().String(), tt.auth, 5*time.Second)
+
+			conn, err := dialer.Dial("tcp", "ctrl.example.com:443")
+			req.Equal(tt.wantConnections, p.connections.Load())
+			if tt.wantErr {
+				req.Error(err)
+				return
+			}
+			req.NoError(err)
+			defer func() { _ = conn.Close() }()
+
+			_, err = conn.Write([]byte("hello"))
+			req.NoError(err)
+
+			buf := make([]byte, 5)
+			_, err = io.ReadFull(conn, buf)
+			req.NoError(err)
+			req.Equal("hello", string(buf))
+		})
+	}
+}
+
+func TestHttpConnectProxyDialerUnsupportedAuth(t *testing.T) {
+	req := require.New(t)
+
+	auth := &proxy.Auth{User: "user", Password: "pass"}
+	p := startDigestProxy(t, &digestProxy{auth: auth, keepAlive: true, schemes: []string{"Negotiate", "NTLM"}})
+	dialer := NewHttpConnectProxyDialer(&net.Dialer{}, p.listener.Addr().String(), auth, 5*time.Second)
+
+	_, err := dialer.Dial("tcp", "ctrl.example.com:443")
+	req.Error(err)
+
+	var unsupportedErr *UnsupportedProxyAuthError
+	req.True(errors.As(err, &unsupportedErr))
+	req.Equal(p.listener.Addr().String(), unsupportedErr.Address)
+	req.Equal([]string{"Negotiate", "NTLM"}, unsupportedErr.Schemes)
+}
+
+// closeCountingDialer records the connections it makes and the networks they were made on, counting how often each
+// connection is closed
+type closeCountingDialer struct {
+	conns    []*closeCountingConn
+	networks []string
+}
+
+func (self *closeCountingDialer) Dial(network, addr string) (net.Conn, error) {
+	self.networks = append(self.networks, network)
+	conn, err := net.Dial(network, addr)
+	if err != nil {
+		return nil, err
+	}
+	result := &closeCountingConn{Conn: conn}
+	self.conns = append(self.conns, result)
+	return result, nil
+}
+
+type closeCountingConn struct {
+	net.Conn
+	closes atomic.Int32
+}
+
+func (self *closeCountingConn) Close() error {
+	self.closes.Add(1)
+	return self.Conn.Close()
+}
+
+func TestHttpConnectProxyDialerClosesReplacedConnectionOnce(t *testing.T) {
+	req := require.New(t)
+
+	auth := &proxy.Auth{User: "user", Password: "pass"}
+	p := startDigestProxy(t, &digestProxy{auth: auth, algorithm: "MD5", schemes: []string{"Digest"}})
+
+	countingDialer := &closeCountingDialer{}
+	dialer := NewHttpConnectProxyDialer(countingDialer, p.listener.Addr().String(), &proxy.Auth{User: "user", Password: "wrong"}, 5*time.Second)
+
+	_, err := dialer.Dial("tcp", "ctrl.example.com:443")
+	req.Error(err)
+
+	req.Greater(len(countingDialer.conns), 1, "proxy should have been re-dialed after closing the connection")
+	for i, conn := range countingDialer.conns {
+		req.Equal(int32(1), conn.closes.Load(), "connection %d should be closed exactly once", i)
+	}
+}
+
+func TestHttpConnectProxyDialerConnectWithBufferedData(t *testing.T) {
+	req := require.New(t)
+
+	httpProxy := startPipeliningProxy(t, "HTTP/1.1 200 Connection established\r\n\r\n", []byte("SSH-2.0-OpenSSH_9.6\r\n"))
+	dialer := NewHttpConnectProxyDialer(&net.Dialer{}, httpProxy.Addr().String(), nil, 5*time.Second)
+
+	c, err := net.Dial("tcp", httpProxy.Addr().String())
+	req.NoError(err)
+	defer func() { _ = c.Close() }()
+
+	err = dialer.Connect(c, "ctrl.example.com:443")
+	req.ErrorContains(err, "use ConnectContext instead")
+
+	// the caller's connection is left open
+	_, err = c.Write([]byte("hello"))
+	req.NoError(err)
+}
+
+func TestHttpConnectProxyDialerReconnectsOnSameNetwork(t *testing.T) {
+	req := require.New(t)
+
+	auth := &proxy.Auth{User: "user", Password: "pass"}
+	p := startDigestProxy(t, &digestProxy{auth: auth, algorithm: "MD5", schemes: []string{"Digest"}})
+
+	countingDialer := &closeCountingDialer{}
+	dialer := NewHttpConnectProxyDialer(countingDialer, p.listener.Addr().String(), auth, 5*time.Second)
+
+	conn, err := dialer.Dial("tcp4", "ctrl.example.com:443")
+	req.NoError(err)
+	req.NoError(conn.Close())
+
+	req.Equal([]string{"tcp4", "tcp4"}, countingDialer.networks)
+}
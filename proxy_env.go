@@ -0,0 +1,99 @@
+/*
+	Copyright NetFoundry Inc.
+
+	Licensed under the Apache License, Version 2.0 (the "License");
+	you may not use this file except in compliance with the License.
+	You may obtain a copy of the License at
+
+	https://www.apache.org/licenses/LICENSE-2.0
+
+	Unless required by applicable law or agreed to in writing, software
+	distributed under the License is distributed on an "AS IS" BASIS,
+	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+	See the License for the specific language governing permissions and
+	limitations under the License.
+*/
+
+package transport
+
+import (
+	"net"
+	"net/url"
+	"os"
+
+	"github.com/pkg/errors"
+	"golang.org/x/net/http/httpproxy"
+	"golang.org/x/net/proxy"
+)
+
+// ForDestination returns the proxy configuration to use when connecting to the given host:port destination. For
+// ProxyTypeEnvironment, the proxy is taken from HTTPS_PROXY, falling back to ALL_PROXY, unless the destination is
+// excluded by NO_PROXY, which may contain IP addresses, CIDRs, domain suffixes and host:port entries. Lower case
+// variants of the variables are honored as well. As with the go standard library, connections to localhost and
+// loopback addresses never use the proxy. If no proxy applies, a ProxyTypeNone configuration is returned.
+//
+// Configurations of any other type are returned as is.
+func (self *ProxyConfiguration) ForDestination(destination string) (*ProxyConfiguration, error) {
+	if self == nil || self.Type != ProxyTypeEnvironment {
+		return self, nil
+	}
+
+	cfg := &httpproxy.Config{
+		HTTPSProxy: getEnvAny("HTTPS_PROXY", "https_proxy", "ALL_PROXY", "all_proxy"),
+		NoProxy:    getEnvAny("NO_PROXY", "no_proxy"),
+	}
+
+	proxyUrl, err := cfg.ProxyFunc()(&url.URL{Scheme: "https", Host: destination})
+	if err != nil {
+		return nil, errors.Wrap(err, "unable to determine proxy from environment")
+	}
+
+	if proxyUrl == nil {
+		return &ProxyConfiguration{Type: ProxyTypeNone}, nil
+	}
+
+	return proxyConfigurationFromUrl(proxyUrl)
+}
+
+func proxyConfigurationFromUrl(proxyUrl *url.URL) (*ProxyConfiguration, error) {
+	result := &ProxyConfiguration{}
+
+	var defaultPort string
+	switch proxyUrl.Scheme {
+	case "http":
+		result.Type = ProxyTypeHttpConnect
+		defaultPort = "80"
+	case "https":
+		result.Type = ProxyTypeHttpsConnect
+		defaultPort = "443"
+	case "socks5", "socks5h":
+		result.Type = ProxyTypeSocks5
+		defaultPort = "1080"
+	default:
+		return nil, errors.Errorf("unsupported proxy scheme '%s' in proxy url %s", proxyUrl.Scheme, proxyUrl.Redacted())
+	}
+
+	if port := proxyUrl.Port(); port != "" {
+		result.Address = proxyUrl.Host
+	} else {
+		result.Address = net.JoinHostPort(proxyUrl.Hostname(), defaultPort)
+	}
+
+	if proxyUrl.User != nil {
+		result.Auth = &proxy.Auth{
+			User: proxyUrl.User.Username(),
+		}
+		result.Auth.Password, _ = proxyUrl.User.Password()
+	}
+
+	return result, nil
+}
+
+func getEnvAny(names ...string) string {
+	for _, name := range names {
+		if val := os.Getenv(name); val != "" {
+			return val
+		}
+	}
+	return ""
+}
@@ -0,0 +1,481 @@
+/*
+	Copyright NetFoundry Inc.
+
+	Licensed under the Apache License, Version 2.0 (the "License");
+	you may not use this file except in compliance with the License.
+	You may obtain a copy of the License at
+
+	https://www.apache.org/licenses/LICENSE-2.0
+
+	Unless required by applicable law or agreed to in writing, software
+	distributed under the License is distributed on an "AS IS" BASIS,
+	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+	See the License for the specific language governing permissions and
+	limitations under the License.
+*/
+
+package transport
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// DefaultProxyProtocolHeaderTimeout limits how long to wait for a PROXY protocol header, unless configured otherwise
+const DefaultProxyProtocolHeaderTimeout = 5 * time.Second
+
+const (
+	proxyProtocolV1Prefix    = "PROXY "
+	proxyProtocolV1MaxLength = 107
+	proxyProtocolV2HeaderLen = 16
+)
+
+var proxyProtocolV2Signature = []byte("\r\n\r\n\x00\r\nQUIT\n")
+
+// ProxyProtocolConfig enables reading PROXY protocol headers, which load balancers such as HAProxy and AWS NLBs send
+// ahead of the connection data, so that the address of the client can be reported instead of the load balancer's
+type ProxyProtocolConfig struct {
+	// TrustedSources are the networks the load balancers connect from. Headers are only read from connections from
+	// these networks. Connections from anywhere else are used as is
+	TrustedSources []*net.IPNet
+
+	// Required rejects connections from trusted sources which don't start with a header. Otherwise, such
+	// connections are used as is, which allows for load balancer health checks without a header
+	Required bool
+
+	// HeaderTimeout limits how long to wait for the header
+	HeaderTimeout time.Duration
+}
+
+// GetProxyProtocolConfig returns the PROXY protocol configuration, or nil if the PROXY protocol isn't enabled
+func (self Configuration) GetProxyProtocolConfig() (*ProxyProtocolConfig, error) {
+	if self == nil {
+		return nil, nil
+	}
+
+	if val, found := self[KeyCachedProxyProtocol]; found {
+		return val.(*ProxyProtocolConfig), nil
+	}
+
+	var result *ProxyProtocolConfig
+
+	if val, found := self[KeyProxyProtocol]; found {
+		cfg, ok := val.(map[interface{}]interface{})
+		if !ok {
+			return nil, errors.New("invalid proxyProtocol configuration value, should be map")
+		}
+
+		var err error
+		if result, err = LoadProxyProtocolConfig(cfg); err != nil {
+			return nil, err
+		}
+	}
+
+	self[KeyCachedProxyProtocol] = result
+
+	return result, nil
+}
+
+// LoadProxyProtocolConfig loads the PROXY protocol configuration from a map, which must list the trusted sources, as
+// networks in CIDR notation or single ip addresses:
+//
+//	proxyProtocol:
+//	  trustedSources: [ 10.0.0.0/8, 192.168.1.10 ]
+//	  required: true
+//	  headerTimeout: 5s
+func LoadProxyProtocolConfig(cfg map[interface{}]interface{}) (*ProxyProtocolConfig, error) {
+	result := &ProxyProtocolConfig{
+		HeaderTimeout: DefaultProxyProtocolHeaderTimeout,
+	}
+
+	sources, ok := cfg["trustedSources"].([]interface{})
+	if !ok || len(sources) == 0 {
+		return nil, errors.New("invalid proxyProtocol configuration, trustedSources must be a non-empty list")
+	}
+
+	for _, source := range sources {
+		network, err := parseTrustedSource(source)
+		if err != nil {
+			return nil, err
+		}
+		result.TrustedSources = append(result.TrustedSources, network)
+	}
+
+	if val, found := cfg["required"]; found {
+		if result.Required, ok = val.(bool); !ok {
+			return nil, errors.Errorf("invalid value for proxyProtocol required [%v], must be bool", val)
+		}
+	}
+
+	if val, found := cfg["headerTimeout"]; found {
+		strVal, ok := val.(string)
+		if !ok {
+			return nil, errors.Errorf("invalid value for proxyProtocol headerTimeout [%v], must be duration string", val)
+		}
+		timeout, err := time.ParseDuration(strVal)
+		if err != nil {
+			return nil, errors.Wrapf(err, "unable to parse proxyProtocol headerTimeout '%s' to duration", strVal)
+		}
+		if timeout <= 0 {
+			return nil, errors.Errorf("invalid value for proxyProtocol headerTimeout [%v], must be positive", val)
+		}
+		result.HeaderTimeout = timeout
+	}
+
+	return result, nil
+}
+
+func parseTrustedSource(source interface{}) (*net.IPNet, error) {
+	s, ok := source.(string)
+	if !ok {
+		return nil, errors.Errorf("invalid proxyProtocol trusted source [%v], must be string", source)
+	}
+
+	if strings.Contains(s, "/") {
+		_, network, err := net.ParseCIDR(s)
+		if err != nil {
+			return nil, errors.Wrapf(err, "invalid proxyProtocol trusted source '%s'", s)
+		}
+		return network, nil
+	}
+
+	ip := net.ParseIP(s)
+	if ip == nil {
+		return nil, errors.Errorf("invalid proxyProtocol trusted source '%s', must be ip address or CIDR", s)
+	}
+	if ip4 := ip.To4(); ip4 != nil {
+		return &net.IPNet{IP: ip4, Mask: net.CIDRMask(32, 32)}, nil
+	}
+	return &net.IPNet{IP: ip, Mask: net.CIDRMask(128, 128)}, nil
+}
+
+// Equal returns true if both configurations are the same
+func (self *ProxyProtocolConfig) Equal(other *ProxyProtocolConfig) bool {
+	if self == nil || other == nil {
+		return self == other
+	}
+	if self.Required != other.Required || self.HeaderTimeout != other.HeaderTimeout || len(self.TrustedSources) != len(other.TrustedSources) {
+		return false
+	}
+	for i, network := range self.TrustedSources {
+		if network.String() != other.TrustedSources[i].String() {
+			return false
+		}
+	}
+	return true
+}
+
+// IsTrusted returns true if a connection from the given address may send a header
+func (self *ProxyProtocolConfig) IsTrusted(addr net.Addr) bool {
+	tcpAddr, ok := addr.(*net.TCPAddr)
+	if !ok {
+		return false
+	}
+	for _, network := range self.TrustedSources {
+		if network.Contains(tcpAddr.IP) {
+			return true
+		}
+	}
+	return false
+}
+
+// Listener wraps the connections accepted by the given listener, so that they read the PROXY protocol header on
+// first use. A nil receiver returns the listener as is
+func (self *ProxyProtocolConfig) Listener(listener net.Listener) net.Listener {
+	if self == nil {
+		return listener
+	}
+	return &proxyProtocolListener{
+		Listener: listener,
+		config:   self,
+	}
+}
+
+// Conn wraps a connection, so that it reads the PROXY protocol header on first use. A nil receiver returns the
+// connection as is
+func (self *ProxyProtocolConfig) Conn(conn net.Conn) net.Conn {
+	if self == nil {
+		return conn
+	}
+	return &ProxyProtocolConn{
+		Conn:   conn,
+		config: self,
+		reader: bufio.NewReader(conn),
+	}
+}
+
+type proxyProtocolListener struct {
+	net.Listener
+	config *ProxyProtocolConfig
+}
+
+func (self *proxyProtocolListener) Accept() (net.Conn, error) {
+	conn, err := self.Listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+	return self.config.Conn(conn), nil
+}
+
+// ProxyProtocolHeader holds the contents of a PROXY protocol header
+type ProxyProtocolHeader struct {
+	// Version is 1 for the text format and 2 for the binary format
+	Version int
+
+	// Source and Destination are the addresses of the client and of the load balancer's listener. Both are nil if
+	// the header doesn't carry addresses, as with v1 UNKNOWN or v2 LOCAL headers, which are sent by health checks
+	Source      net.Addr
+	Destination net.Addr
+
+	// TLVs holds the type-length-value fields of a v2 header
+	TLVs []ProxyProtocolTLV
+}
+
+// ProxyProtocolTLV is a type-length-value field of a v2 PROXY protocol header
+type ProxyProtocolTLV struct {
+	Type  byte
+	Value []byte
+}
+
+// ProxyProtocolConn reads the PROXY protocol header, if there is one, before the first read or address lookup, and
+// reports the addresses from the header. The header is read with a timeout, and a connection with an invalid header
+// fails all reads
+type ProxyProtocolConn struct {
+	net.Conn
+	config *ProxyProtocolConfig
+	reader *bufio.Reader
+	once   sync.Once
+	header *ProxyProtocolHeader
+	err    error
+}
+
+// Header returns the header read from the connection, or nil if the connection didn't start with one
+func (self *ProxyProtocolConn) Header() (*ProxyProtocolHeader, error) {
+	self.once.Do(self.readHeader)
+	return self.header, self.err
+}
+
+// NetConn returns the underlying connection, as accepted from the load balancer
+func (self *ProxyProtocolConn) NetConn() net.Conn {
+	return self.Conn
+}
+
+func (self *ProxyProtocolConn) Read(b []byte) (int, error) {
+	if _, err := self.Header(); err != nil {
+		return 0, err
+	}
+	return self.reader.Read(b)
+}
+
+func (self *ProxyProtocolConn) RemoteAddr() net.Addr {
+	if header, _ := self.Header(); header != nil && header.Source != nil {
+		return header.Source
+	}
+	return self.Conn.RemoteAddr()
+}
+
+func (self *ProxyProtocolConn) LocalAddr() net.Addr {
+	if header, _ := self.Header(); header != nil && header.Destination != nil {
+		return header.Destination
+	}
+	return self.Conn.LocalAddr()
+}
+
+func (self *ProxyProtocolConn) readHeader() {
+	if !self.config.IsTrusted(self.Conn.RemoteAddr()) {
+		return
+	}
+
+	if err := self.Conn.SetReadDeadline(time.Now().Add(self.config.HeaderTimeout)); err != nil {
+		self.err = errors.Wrap(err, "unable to set deadline for reading PROXY protocol header")
+		return
+	}
+
+	self.header, self.err = ReadProxyProtocolHeader(self.reader)
+	if self.err == nil && self.header == nil && self.config.Required {
+		self.err = errors.Errorf("connection from %v didn't start with a PROXY protocol header", self.Conn.RemoteAddr())
+	}
+
+	if err := self.Conn.SetReadDeadline(time.Time{}); err != nil && self.err == nil {
+		self.err = errors.Wrap(err, "unable to clear deadline after reading PROXY protocol header")
+	}
+}
+
+// ReadProxyProtocolHeader reads a v1 or v2 PROXY protocol header. Returns nil if the data doesn't start with a
+// header, in which case nothing is consumed from the reader
+func ReadProxyProtocolHeader(reader *bufio.Reader) (*ProxyProtocolHeader, error) {
+	first, err := reader.Peek(1)
+	if err != nil {
+		return nil, errors.Wrap(err, "unable to read PROXY protocol header")
+	}
+
+	switch first[0] {
+	case proxyProtocolV1Prefix[0]:
+		if prefix, err := reader.Peek(len(proxyProtocolV1Prefix)); err == nil && string(prefix) == proxyProtocolV1Prefix {
+			return readProxyProtocolV1(reader)
+		}
+	case proxyProtocolV2Signature[0]:
+		if prefix, err := reader.Peek(len(proxyProtocolV2Signature)); err == nil && bytes.Equal(prefix, proxyProtocolV2Signature) {
+			return readProxyProtocolV2(reader)
+		}
+	}
+
+	return nil, nil
+}
+
+func readProxyProtocolV1(reader *bufio.Reader) (*ProxyProtocolHeader, error) {
+	var line []byte
+	for !bytes.HasSuffix(line, []byte("\r\n")) {
+		if len(line) >= proxyProtocolV1MaxLength {
+			return nil, errors.New("invalid PROXY protocol v1 header, too long")
+		}
+		b, err := reader.ReadByte()
+		if err != nil {
+			return nil, errors.Wrap(err, "unable to read PROXY protocol v1 header")
+		}
+		line = append(line, b)
+	}
+
+	fields := strings.Split(string(line[:len(line)-2]), " ")
+	result := &ProxyProtocolHeader{Version: 1}
+
+	if len(fields) >= 2 && fields[1] == "UNKNOWN" {
+		return result, nil
+	}
+
+	if len(fields) != 6 || (fields[1] != "TCP4" && fields[1] != "TCP6") {
+		return nil, errors.Errorf("invalid PROXY protocol v1 header '%s'", line[:len(line)-2])
+	}
+
+	var err error
+	if result.Source, err = parseProxyProtocolV1Addr(fields[1], fields[2], fields[4]); err != nil {
+		return nil, err
+	}
+	if result.Destination, err = parseProxyProtocolV1Addr(fields[1], fields[3], fields[5]); err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+func parseProxyProtocolV1Addr(protocol, ipStr, portStr string) (*net.TCPAddr, error) {
+	ip := net.ParseIP(ipStr)
+	if ip == nil || (protocol == "TCP4") != (ip.To4() != nil) {
+		return nil, errors.Errorf("invalid PROXY protocol v1 %s address '%s'", protocol, ipStr)
+	}
+	port, err := strconv.ParseUint(portStr, 10, 16)
+	if err != nil {
+		return nil, errors.Errorf("invalid PROXY protocol v1 port '%s'", portStr)
+	}
+	return &net.TCPAddr{IP: ip, Port: int(port)}, nil
+}
+
+func readProxyProtocolV2(reader *bufio.Reader) (*ProxyProtocolHeader, error) {
+	header := make([]byte, proxyProtocolV2HeaderLen)
+	if _, err := io.ReadFull(reader, header); err != nil {
+		return nil, errors.Wrap(err, "unable to read PROXY protocol v2 header")
+	}
+
+	versionCommand, family := header[12], header[13]
+	if versionCommand>>4 != 2 {
+		return nil, errors.Errorf("unsupported PROXY protocol version %d", versionCommand>>4)
+	}
+
+	body := make([]byte, binary.BigEndian.Uint16(header[14:]))
+	if _, err := io.ReadFull(reader, body); err != nil {
+		return nil, errors.Wrap(err, "unable to read PROXY protocol v2 header")
+	}
+
+	result := &ProxyProtocolHeader{Version: 2}
+
+	var addrLen int
+	switch command := versionCommand & 0xF; command {
+	case 0x0: // LOCAL, the connection was made by the load balancer itself
+		// the address block and anything after it must be ignored, whatever the address family
+		return result, nil
+	case 0x1: // PROXY
+		switch family {
+		case 0x11: // TCP over IPv4
+			addrLen = 12
+			if len(body) < addrLen {
+				return nil, errors.New("invalid PROXY protocol v2 header, too short for IPv4 addresses")
+			}
+			result.Source = &net.TCPAddr{IP: net.IP(bytes.Clone(body[0:4])), Port: int(binary.BigEndian.Uint16(body[8:]))}
+			result.Destination = &net.TCPAddr{IP: net.IP(bytes.Clone(body[4:8])), Port: int(binary.BigEndian.Uint16(body[10:]))}
+		case 0x21: // TCP over IPv6
+			addrLen = 36
+			if len(body) < addrLen {
+				return nil, errors.New("invalid PROXY protocol v2 header, too short for IPv6 addresses")
+			}
+			result.Source = &net.TCPAddr{IP: net.IP(bytes.Clone(body[0:16])), Port: int(binary.BigEndian.Uint16(body[32:]))}
+			result.Destination = &net.TCPAddr{IP: net.IP(bytes.Clone(body[16:32])), Port: int(binary.BigEndian.Uint16(body[34:]))}
+		case 0x00: // UNSPEC
+		case 0x31: // unix stream sockets, which carry no addresses which can be reported for a tcp connection
+			addrLen = 216
+		default:
+			return nil, errors.Errorf("unsupported PROXY protocol v2 address family 0x%x", family)
+		}
+	default:
+		return nil, errors.Errorf("unsupported PROXY protocol v2 command 0x%x", command)
+	}
+
+	if len(body) < addrLen {
+		return nil, errors.New("invalid PROXY protocol v2 header, too short for addresses")
+	}
+
+	for tlvs := body[addrLen:]; len(tlvs) > 0; {
+		if len(tlvs) < 3 {
+			return nil, errors.New("invalid PROXY protocol v2 TLV, too short")
+		}
+		length := int(binary.BigEndian.Uint16(tlvs[1:]))
+		if len(tlvs) < 3+length {
+			return nil, errors.Errorf("invalid PROXY protocol v2 TLV of type 0x%x, value too short", tlvs[0])
+		}
+		result.TLVs = append(result.TLVs, ProxyProtocolTLV{Type: tlvs[0], Value: bytes.Clone(tlvs[3 : 3+length])})
+		tlvs = tlvs[3+length:]
+	}
+
+	return result, nil
+}
+
+// ProxiedBy returns the address of the load balancer which passed the connection on with a PROXY protocol header,
+// or an empty string if the connection didn't come with one
+func ProxiedBy(conn net.Conn) string {
+	for conn != nil {
+		if proxyConn, ok := conn.(*ProxyProtocolConn); ok {
+			if header, _ := proxyConn.Header(); header != nil && header.Source != nil {
+				return proxyConn.Conn.RemoteAddr().String()
+			}
+			return ""
+		}
+		wrapper, ok := conn.(interface{ NetConn() net.Conn })
+		if !ok {
+			return ""
+		}
+		conn = wrapper.NetConn()
+	}
+	return ""
+}
+
+// tcpConnOf returns the tcp connection underneath any tls or PROXY protocol wrappers, or nil if there isn't one
+func tcpConnOf(conn net.Conn) *net.TCPConn {
+	for conn != nil {
+		if tcpConn, ok := conn.(*net.TCPConn); ok {
+			return tcpConn
+		}
+		wrapper, ok := conn.(interface{ NetConn() net.Conn })
+		if !ok {
+			return nil
+		}
+		conn = wrapper.NetConn()
+	}
+	return nil
+}
@@ -0,0 +1,217 @@
+package transport
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"io"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestLoadProxyProtocolConfig(t *testing.T) {
+	req := require.New(t)
+
+	cfg, err := Configuration{}.GetProxyProtocolConfig()
+	req.NoError(err)
+	req.Nil(cfg)
+
+	tcfg := Configuration{
+		KeyProxyProtocol: map[interface{}]interface{}{
+			"trustedSources": []interface{}{"10.0.0.0/8", "192.168.1.10", "fd00::/8"},
+			"required":       true,
+			"headerTimeout":  "2s",
+		},
+	}
+
+	cfg, err = tcfg.GetProxyProtocolConfig()
+	req.NoError(err)
+	req.True(cfg.Required)
+	req.Equal(2*time.Second, cfg.HeaderTimeout)
+	req.Len(cfg.TrustedSources, 3)
+	req.Equal("192.168.1.10/32", cfg.TrustedSources[1].String())
+
+	req.True(cfg.IsTrusted(&net.TCPAddr{IP: net.ParseIP("10.1.2.3")}))
+	req.True(cfg.IsTrusted(&net.TCPAddr{IP: net.ParseIP("192.168.1.10")}))
+	req.False(cfg.IsTrusted(&net.TCPAddr{IP: net.ParseIP("192.168.1.11")}))
+	req.True(cfg.IsTrusted(&net.TCPAddr{IP: net.ParseIP("fd00::1")}))
+	req.False(cfg.IsTrusted(&net.UnixAddr{Name: "/tmp/sock"}))
+
+	cached, err := tcfg.GetProxyProtocolConfig()
+	req.NoError(err)
+	req.Same(cfg, cached)
+
+	invalid := []map[interface{}]interface{}{
+		{},
+		{"trustedSources": []interface{}{}},
+		{"trustedSources": []interface{}{"10.0.0.0/33"}},
+		{"trustedSources": []interface{}{"not-an-ip"}},
+		{"trustedSources": []interface{}{10}},
+		{"trustedSources": []interface{}{"10.0.0.1"}, "required": "yes"},
+		{"trustedSources": []interface{}{"10.0.0.1"}, "headerTimeout": "soon"},
+		{"trustedSources": []interface{}{"10.0.0.1"}, "headerTimeout": "0s"},
+	}
+	for _, cfg := range invalid {
+		_, err = LoadProxyProtocolConfig(cfg)
+		req.Error(err, "%v", cfg)
+	}
+}
+
+func proxyProtocolV2Header(command, family byte, addrs []byte, tlvs ...ProxyProtocolTLV) []byte {
+	var body []byte
+	body = append(body, addrs...)
+	for _, tlv := range tlvs {
+		body = append(body, tlv.Type)
+		body = binary.BigEndian.AppendUint16(body, uint16(len(tlv.Value)))
+		body = append(body, tlv.Value...)
+	}
+
+	result := append([]byte{}, proxyProtocolV2Signature...)
+	result = append(result, 0x20|command, family)
+	result = binary.BigEndian.AppendUint16(result, uint16(len(body)))
+	return append(result, body...)
+}
+
+func TestReadProxyProtocolHeader(t *testing.T) {
+	ipv4Addrs := []byte{203, 0, 113, 7, 10, 0, 0, 1, 0x0f, 0xa0, 0x01, 0xbb}
+	ipv6Addrs := append(append(append([]byte{}, net.ParseIP("2001:db8::1")...), net.ParseIP("2001:db8::2")...), 0x0f, 0xa0, 0x01, 0xbb)
+
+	tests := []struct {
+		name        string
+		input       []byte
+		version     int
+		source      string
+		destination string
+		tlvs        []ProxyProtocolTLV
+		wantErr     bool
+	}{
+		{"v1 tcp4", []byte("PROXY TCP4 203.0.113.7 10.0.0.1 4000 443\r\n"), 1, "203.0.113.7:4000", "10.0.0.1:443", nil, false},
+		{"v1 tcp6", []byte("PROXY TCP6 2001:db8::1 2001:db8::2 4000 443\r\n"), 1, "[2001:db8::1]:4000", "[2001:db8::2]:443", nil, false},
+		{"v1 unknown", []byte("PROXY UNKNOWN\r\n"), 1, "", "", nil, false},
+		{"v1 wrong family", []byte("PROXY TCP4 2001:db8::1 10.0.0.1 4000 443\r\n"), 0, "", "", nil, true},
+		{"v1 bad port", []byte("PROXY TCP4 203.0.113.7 10.0.0.1 70000 443\r\n"), 0, "", "", nil, true},
+		{"v1 missing fields", []byte("PROXY TCP4 203.0.113.7\r\n"), 0, "", "", nil, true},
+		{"v1 too long", []byte("PROXY TCP4 " + strings.Repeat("1", 120) + "\r\n"), 0, "", "", nil, true},
+		{"v2 ipv4", proxyProtocolV2Header(1, 0x11, ipv4Addrs), 2, "203.0.113.7:4000", "10.0.0.1:443", nil, false},
+		{"v2 ipv4 with tlvs", proxyProtocolV2Header(1, 0x11, ipv4Addrs, ProxyProtocolTLV{Type: 0x02, Value: []byte("example.com")}, ProxyProtocolTLV{Type: 0xea, Value: []byte{0x01, 'v', 'p', 'c'}}),
+			2, "203.0.113.7:4000", "10.0.0.1:443", []ProxyProtocolTLV{{Type: 0x02, Value: []byte("example.com")}, {Type: 0xea, Value: []byte{0x01, 'v', 'p', 'c'}}}, false},
+		{"v2 ipv6", proxyProtocolV2Header(1, 0x21, ipv6Addrs), 2, "[2001:db8::1]:4000", "[2001:db8::2]:443", nil, false},
+		{"v2 local", proxyProtocolV2Header(0, 0x00, nil), 2, "", "", nil, false},
+		{"v2 local with addresses", proxyProtocolV2Header(0, 0x11, ipv4Addrs), 2, "", "", nil, false},
+		{"v2 short addresses", proxyProtocolV2Header(1, 0x11, ipv4Addrs[:8]), 0, "", "", nil, true},
+		{"v2 truncated tlv", proxyProtocolV2Header(1, 0x11, append(append([]byte{}, ipv4Addrs...), 0x02, 0x00, 0x05, 'a')), 0, "", "", nil, true},
+		{"v2 unknown command", proxyProtocolV2Header(5, 0x11, ipv4Addrs), 0, "", "", nil, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := require.New(t)
+
+			reader := bufio.NewReader(bytes.NewReader(append(tt.input, "payload"...)))
+			header, err := ReadProxyProtocolHeader(reader)
+			if tt.wantErr {
+				req.Error(err)
+				return
+			}
+			req.NoError(err)
+			req.NotNil(header)
+			req.Equal(tt.version, header.Version)
+			if tt.source == "" {
+				req.Nil(header.Source)
+				req.Nil(header.Destination)
+			} else {
+				req.Equal(tt.source, header.Source.String())
+				req.Equal(tt.destination, header.Destination.String())
+			}
+			req.Equal(tt.tlvs, header.TLVs)
+
+			rest, err := io.ReadAll(reader)
+			req.NoError(err)
+			req.Equal("payload", string(rest))
+		})
+	}
+}
+
+func TestReadProxyProtocolHeaderWithoutHeader(t *testing.T) {
+	req := require.New(t)
+
+	for _, input := range []string{"\x16\x03\x01 client hello", "POST / HTTP/1.1\r\n", "\r\n\r\nnot quite"} {
+		reader := bufio.NewReader(strings.NewReader(input))
+		header, err := ReadProxyProtocolHeader(reader)
+		req.NoError(err)
+		req.Nil(header)
+
+		rest, err := io.ReadAll(reader)
+		req.NoError(err)
+		req.Equal(input, string(rest))
+	}
+}
+
+func TestProxyProtocolConn(t *testing.T) {
+	req := require.New(t)
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	req.NoError(err)
+	defer func() { _ = listener.Close() }()
+
+	dial := func(cfg *ProxyProtocolConfig, data string) net.Conn {
+		client, err := net.Dial("tcp", listener.Addr().String())
+		req.NoError(err)
+		t.Cleanup(func() { _ = client.Close() })
+		_, err = client.Write([]byte(data))
+		req.NoError(err)
+
+		conn, err := cfg.Listener(listener).Accept()
+		req.NoError(err)
+		t.Cleanup(func() { _ = conn.Close() })
+		return conn
+	}
+
+	trusted := &ProxyProtocolConfig{
+		TrustedSources: []*net.IPNet{{IP: net.IPv4(127, 0, 0, 0), Mask: net.CIDRMask(8, 32)}},
+		HeaderTimeout:  time.Second,
+	}
+
+	conn := dial(trusted, "PROXY TCP4 203.0.113.7 10.0.0.1 4000 443\r\nhello")
+	req.Equal("203.0.113.7:4000", conn.RemoteAddr().String())
+	req.Equal("10.0.0.1:443", conn.LocalAddr().String())
+	req.Equal(conn.(*ProxyProtocolConn).NetConn().RemoteAddr().String(), ProxiedBy(conn))
+	buf := make([]byte, 5)
+	_, err = io.ReadFull(conn, buf)
+	req.NoError(err)
+	req.Equal("hello", string(buf))
+
+	// headers from untrusted sources are left in the data
+	untrusted := &ProxyProtocolConfig{
+		TrustedSources: []*net.IPNet{{IP: net.IPv4(10, 0, 0, 0), Mask: net.CIDRMask(8, 32)}},
+		HeaderTimeout:  time.Second,
+	}
+	conn = dial(untrusted, "PROXY TCP4 203.0.113.7 10.0.0.1 4000 443\r\n")
+	req.Equal(conn.(*ProxyProtocolConn).NetConn().RemoteAddr(), conn.RemoteAddr())
+	req.Empty(ProxiedBy(conn))
+	buf = make([]byte, 6)
+	_, err = io.ReadFull(conn, buf)
+	req.NoError(err)
+	req.Equal("PROXY ", string(buf))
+
+	// without a header, trusted sources are used as is unless a header is required
+	conn = dial(trusted, "hello")
+	req.Equal(conn.(*ProxyProtocolConn).NetConn().RemoteAddr(), conn.RemoteAddr())
+	req.Empty(ProxiedBy(conn))
+
+	required := *trusted
+	required.Required = true
+	conn = dial(&required, "hello")
+	_, err = conn.Read(buf)
+	req.Error(err)
+
+	// a header which doesn't arrive in time fails the connection
+	required.HeaderTimeout = 50 * time.Millisecond
+	conn = dial(&required, "PROXY TCP4")
+	_, err = conn.Read(buf)
+	req.Error(err)
+}
@@ -0,0 +1,75 @@
+/*
+	Copyright NetFoundry Inc.
+
+	Licensed under the Apache License, Version 2.0 (the "License");
+	you may not use this file except in compliance with the License.
+	You may obtain a copy of the License at
+
+	https://www.apache.org/licenses/LICENSE-2.0
+
+	Unless required by applicable law or agreed to in writing, software
+	distributed under the License is distributed on an "AS IS" BASIS,
+	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+	See the License for the specific language governing permissions and
+	limitations under the License.
+*/
+
+package quic
+
+import (
+	"crypto/x509"
+	"errors"
+	"io"
+	"net"
+	"sync/atomic"
+
+	"github.com/openziti/transport/v2"
+	quicgo "github.com/quic-go/quic-go"
+)
+
+// Connection exposes a single bidirectional stream of a QUIC connection as a transport.Conn. Closing the
+// Connection closes the underlying QUIC connection.
+type Connection struct {
+	detail *transport.ConnectionDetail
+	*quicgo.Stream
+	conn   *quicgo.Conn
+	socket io.Closer // packet conn owned by dialed connections, nil for accepted connections
+	closed atomic.Bool
+}
+
+func (self *Connection) Detail() *transport.ConnectionDetail {
+	return self.detail
+}
+
+func (self *Connection) PeerCertificates() []*x509.Certificate {
+	return self.conn.ConnectionState().TLS.PeerCertificates
+}
+
+func (self *Connection) Protocol() string {
+	return self.conn.ConnectionState().TLS.NegotiatedProtocol
+}
+
+func (self *Connection) LocalAddr() net.Addr {
+	return self.conn.LocalAddr()
+}
+
+func (self *Connection) RemoteAddr() net.Addr {
+	return self.conn.RemoteAddr()
+}
+
+func (self *Connection) Close() error {
+	if !self.closed.CompareAndSwap(false, true) {
+		return nil
+	}
+
+	err := self.Stream.Close()
+	if closeErr := self.conn.CloseWithError(0, "closed"); closeErr != nil {
+		err = errors.Join(err, closeErr)
+	}
+	if self.socket != nil {
+		if closeErr := self.socket.Close(); closeErr != nil {
+			err = errors.Join(err, closeErr)
+		}
+	}
+	return err
+}
@@ -0,0 +1,118 @@
+/*
+	Copyright NetFoundry Inc.
+
+	Licensed under the Apache License, Version 2.0 (the "License");
+	you may not use this file except in compliance with the License.
+	You may obtain a copy of the License at
+
+	https://www.apache.org/licenses/LICENSE-2.0
+
+	Unless required by applicable law or agreed to in writing, software
+	distributed under the License is distributed on an "AS IS" BASIS,
+	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+	See the License for the specific language governing permissions and
+	limitations under the License.
+*/
+
+package quic
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"time"
+
+	"github.com/michaelquigley/pfxlog"
+	"github.com/openziti/identity"
+	"github.com/openziti/transport/v2"
+	quicgo "github.com/quic-go/quic-go"
+)
+
+func Dial(a address, name string, i *identity.TokenId, timeout time.Duration, protocols ...string) (transport.Conn, error) {
+	return DialWithLocalBinding(a, name, "", i, timeout, protocols...)
+}
+
+func DialWithLocalBinding(a address, name, localBinding string, i *identity.TokenId, timeout time.Duration, protocols ...string) (transport.Conn, error) {
+	ctx, cancelF := transport.TimeoutContext(timeout)
+	defer cancelF()
+	return DialWithLocalBindingContext(ctx, a, name, localBinding, i, protocols...)
+}
+
+func DialContext(ctx context.Context, a address, name string, i *identity.TokenId, protocols ...string) (transport.Conn, error) {
+	return DialWithLocalBindingContext(ctx, a, name, "", i, protocols...)
+}
+
+// DialWithLocalBindingContext establishes a QUIC connection to the given address, authenticating with the client
+// tls configuration of the given identity, and opens the stream carried by the returned transport.Conn
+func DialWithLocalBindingContext(ctx context.Context, a address, name, localBinding string, i *identity.TokenId, protocols ...string) (transport.Conn, error) {
+	log := pfxlog.Logger().WithField("dest", a.bindableAddress())
+
+	destination, err := transport.ResolveUDPAddress(ctx, a.hostname, a.port)
+	if err != nil {
+		return nil, err
+	}
+
+	binding, err := transport.ParseLocalBinding(localBinding)
+	if err != nil {
+		return nil, err
+	}
+
+	socket, err := transport.BindLocalAddr(binding, "udp", destination.IP, func(localAddr net.Addr) (*net.UDPConn, error) {
+		return binding.ListenUDP(ctx, localAddr)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	tlsCfg := i.ClientTLSConfig().Clone()
+	tlsCfg.ServerName = a.hostname
+	tlsCfg.NextProtos = getProtocols(protocols)
+
+	conn, err := quicgo.Dial(ctx, socket, destination, tlsCfg, newConfig(0))
+	if err != nil {
+		_ = socket.Close()
+		return nil, err
+	}
+
+	stream, err := openStream(ctx, conn)
+	if err != nil {
+		_ = conn.CloseWithError(0, "stream setup failed")
+		_ = socket.Close()
+		return nil, err
+	}
+
+	log.Debugf("server provided [%d] certificates", len(conn.ConnectionState().TLS.PeerCertificates))
+
+	return &Connection{
+		detail: &transport.ConnectionDetail{
+			Address: Type + ":" + a.bindableAddress(),
+			InBound: false,
+			Name:    name,
+		},
+		Stream: stream,
+		conn:   conn,
+		socket: socket,
+	}, nil
+}
+
+// openStream opens the stream carried by a dialed connection. The peer only learns about a new stream once data has
+// been sent on it, so the stream header is written straight away
+func openStream(ctx context.Context, conn *quicgo.Conn) (*quicgo.Stream, error) {
+	stream, err := conn.OpenStreamSync(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	if deadline, ok := ctx.Deadline(); ok {
+		_ = stream.SetWriteDeadline(deadline)
+	}
+
+	if _, err = stream.Write(streamHeader); err != nil {
+		stream.CancelRead(0)
+		stream.CancelWrite(0)
+		return nil, fmt.Errorf("unable to write stream header (%w)", err)
+	}
+
+	_ = stream.SetWriteDeadline(time.Time{})
+	return stream, nil
+}
@@ -0,0 +1,226 @@
+/*
+	Copyright NetFoundry Inc.
+
+	Licensed under the Apache License, Version 2.0 (the "License");
+	you may not use this file except in compliance with the License.
+	You may obtain a copy of the License at
+
+	https://www.apache.org/licenses/LICENSE-2.0
+
+	Unless required by applicable law or agreed to in writing, software
+	distributed under the License is distributed on an "AS IS" BASIS,
+	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+	See the License for the specific language governing permissions and
+	limitations under the License.
+*/
+
+package quic
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"fmt"
+	"io"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/michaelquigley/pfxlog"
+	"github.com/openziti/identity"
+	"github.com/openziti/transport/v2"
+	quicgo "github.com/quic-go/quic-go"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	// DefaultProtocol is the ALPN protocol negotiated when no protocols are configured. QUIC requires ALPN, so
+	// dialers and listeners must agree on at least one protocol.
+	DefaultProtocol = "ziti-quic"
+
+	DefaultHandshakeTimeout = 10 * time.Second
+	DefaultKeepAlivePeriod  = 15 * time.Second
+)
+
+// streamHeader is sent by the dialer when opening the connection's stream, so that the listener can accept it
+// before either side has application data to send
+var streamHeader = []byte{'z', 'q', 0x01}
+
+func Listen(bindAddress, name string, i *identity.TokenId, acceptF func(transport.Conn), tcfg transport.Configuration) (transport.Listener, error) {
+	log := pfxlog.ContextLogger(name + "/" + Type + ":" + bindAddress).Entry
+
+	timeout, err := tcfg.GetHandshakeTimeout()
+	if err != nil {
+		return nil, err
+	}
+
+	if timeout == 0 {
+		timeout = DefaultHandshakeTimeout
+	}
+
+	protocols := getProtocols(tcfg.Protocols())
+
+	tlsCfg := i.ServerTLSConfig().Clone()
+	tlsCfg.NextProtos = protocols
+
+	// identity server configs may hand out a different config per client, which must carry the same protocols
+	if getConfigForClient := tlsCfg.GetConfigForClient; getConfigForClient != nil {
+		tlsCfg.GetConfigForClient = func(info *tls.ClientHelloInfo) (*tls.Config, error) {
+			cfg, err := getConfigForClient(info)
+			if err != nil || cfg == nil {
+				return cfg, err
+			}
+			cfg = cfg.Clone()
+			cfg.NextProtos = protocols
+			return cfg, nil
+		}
+	}
+
+	listener, err := quicgo.ListenAddr(bindAddress, tlsCfg, newConfig(timeout))
+	if err != nil {
+		return nil, err
+	}
+
+	result := &acceptor{
+		name:     name,
+		listener: listener,
+		acceptF:  acceptF,
+		timeout:  timeout,
+	}
+	result.ctx, result.cancelF = context.WithCancel(context.Background())
+
+	result.pending.Add(1)
+	go result.acceptLoop(log)
+
+	return result, nil
+}
+
+type acceptor struct {
+	transport.ListenerCounters
+	name     string
+	listener *quicgo.Listener
+	acceptF  func(transport.Conn)
+	timeout  time.Duration
+	ctx      context.Context
+	cancelF  context.CancelFunc
+	closed   atomic.Bool
+
+	// pending tracks the accept loop and connections whose stream hasn't been accepted yet
+	pending sync.WaitGroup
+}
+
+func (self *acceptor) Close() error {
+	if self.closed.CompareAndSwap(false, true) {
+		self.cancelF()
+		return self.listener.Close()
+	}
+	return nil
+}
+
+// Shutdown stops accepting new connections and waits until connections which completed the handshake have had their
+// stream accepted or timed out, and for the accept loop to exit
+func (self *acceptor) Shutdown(ctx context.Context) error {
+	if !self.closed.CompareAndSwap(false, true) {
+		return transport.WaitForShutdown(ctx, &self.pending)
+	}
+
+	if err := self.listener.Close(); err != nil {
+		self.cancelF()
+		return err
+	}
+
+	err := transport.WaitForShutdown(ctx, &self.pending)
+	self.cancelF()
+	return err
+}
+
+func (self *acceptor) Addr() string {
+	return Type + ":" + self.listener.Addr().String()
+}
+
+func (self *acceptor) acceptLoop(log *logrus.Entry) {
+	defer self.pending.Done()
+	defer log.Info("exited")
+
+	for {
+		conn, err := self.listener.Accept(self.ctx)
+		if err != nil {
+			if self.closed.Load() {
+				log.WithError(err).Info("listener closed, exiting")
+				return
+			}
+			log.WithError(err).Error("accept failed. Failure not recoverable. Exiting listen loop")
+			return
+		}
+
+		self.pending.Add(1)
+		go self.acceptStream(log, conn)
+	}
+}
+
+func (self *acceptor) acceptStream(log *logrus.Entry, conn *quicgo.Conn) {
+	defer self.pending.Done()
+
+	log = log.WithField("remote", conn.RemoteAddr().String())
+
+	ctx, cancelF := context.WithTimeout(self.ctx, self.timeout)
+	defer cancelF()
+
+	stream, err := conn.AcceptStream(ctx)
+	if err != nil {
+		log.WithError(err).Error("failed to accept stream")
+		_ = conn.CloseWithError(0, "stream setup failed")
+		self.IncrementFailed()
+		return
+	}
+
+	if err = readStreamHeader(stream, time.Now().Add(self.timeout)); err != nil {
+		log.WithError(err).Error("invalid stream header")
+		_ = conn.CloseWithError(0, "stream setup failed")
+		self.IncrementFailed()
+		return
+	}
+
+	connection := &Connection{
+		detail: &transport.ConnectionDetail{
+			Address: Type + ":" + conn.RemoteAddr().String(),
+			InBound: true,
+			Name:    self.name,
+		},
+		Stream: stream,
+		conn:   conn,
+	}
+	self.IncrementAccepted()
+	self.acceptF(connection)
+}
+
+func readStreamHeader(stream *quicgo.Stream, deadline time.Time) error {
+	if err := stream.SetReadDeadline(deadline); err != nil {
+		return err
+	}
+
+	header := make([]byte, len(streamHeader))
+	if _, err := io.ReadFull(stream, header); err != nil {
+		return err
+	}
+
+	if !bytes.Equal(header, streamHeader) {
+		return fmt.Errorf("unexpected stream header %x", header)
+	}
+
+	return stream.SetReadDeadline(time.Time{})
+}
+
+func getProtocols(protocols []string) []string {
+	if len(protocols) == 0 {
+		return []string{DefaultProtocol}
+	}
+	return protocols
+}
+
+func newConfig(handshakeTimeout time.Duration) *quicgo.Config {
+	return &quicgo.Config{
+		HandshakeIdleTimeout: handshakeTimeout,
+		KeepAlivePeriod:      DefaultKeepAlivePeriod,
+	}
+}
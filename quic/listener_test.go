@@ -0,0 +1,132 @@
+package quic
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/openziti/transport/v2"
+	"github.com/openziti/transport/v2/internal/testutil"
+	"github.com/stretchr/testify/require"
+)
+
+func TestListenAndDial(t *testing.T) {
+	req := require.New(t)
+
+	serverId, clientId := testutil.NewIdentities(t)
+
+	transport.AddAddressParser(AddressParser{})
+	bindAddr, err := transport.ParseAddress("quic:127.0.0.1:0")
+	req.NoError(err)
+
+	accepted := make(chan transport.Conn, 1)
+	listener, err := bindAddr.Listen("test", serverId, func(conn transport.Conn) {
+		accepted <- conn
+	}, nil)
+	req.NoError(err)
+	defer func() { _ = listener.Close() }()
+
+	addr, err := transport.ParseAddress(listener.Addr())
+	req.NoError(err)
+	req.NotZero(addr.(*address).port)
+
+	conn, err := addr.Dial("test", clientId, 5*time.Second, nil)
+	req.NoError(err)
+	defer func() { _ = conn.Close() }()
+
+	req.Equal(DefaultProtocol, conn.(*Connection).Protocol())
+	req.Len(conn.PeerCertificates(), 1)
+	req.Equal("testServer", conn.PeerCertificates()[0].Subject.CommonName)
+
+	var inbound transport.Conn
+	select {
+	case inbound = <-accepted:
+	case <-time.After(5 * time.Second):
+		req.Fail("connection not accepted")
+	}
+	defer func() { _ = inbound.Close() }()
+
+	req.True(inbound.Detail().InBound)
+	req.Equal(transport.ListenerStats{Accepted: 1}, listener.Stats())
+	req.Len(inbound.PeerCertificates(), 1)
+	req.Equal("testClient", inbound.PeerCertificates()[0].Subject.CommonName)
+
+	_, err = conn.Write([]byte("ping"))
+	req.NoError(err)
+
+	buf := make([]byte, 4)
+	_, err = inbound.Read(buf)
+	req.NoError(err)
+	req.Equal("ping", string(buf))
+
+	_, err = inbound.Write([]byte("pong"))
+	req.NoError(err)
+	_, err = conn.Read(buf)
+	req.NoError(err)
+	req.Equal("pong", string(buf))
+
+	ctx, cancelF := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancelF()
+	req.NoError(listener.Shutdown(ctx))
+}
+
+// localResolver resolves every host to the loopback address, recording the hosts looked up
+type localResolver struct {
+	hosts chan string
+}
+
+func (self *localResolver) LookupIPAddr(_ context.Context, host string) ([]net.IPAddr, error) {
+	self.hosts <- host
+	return []net.IPAddr{{IP: net.IPv4(127, 0, 0, 1)}}, nil
+}
+
+func TestDialUsesResolver(t *testing.T) {
+	req := require.New(t)
+
+	resolver := &localResolver{hosts: make(chan string, 1)}
+	transport.SetResolver(resolver)
+	defer transport.SetResolver(nil)
+
+	serverId, clientId := testutil.NewIdentities(t)
+
+	bindAddr, err := AddressParser{}.Parse("quic:127.0.0.1:0")
+	req.NoError(err)
+
+	listener, err := bindAddr.Listen("test", serverId, func(conn transport.Conn) {
+		_ = conn.Close()
+	}, nil)
+	req.NoError(err)
+	defer func() { _ = listener.Close() }()
+
+	listenAddr, err := AddressParser{}.Parse(listener.Addr())
+	req.NoError(err)
+
+	addr, err := AddressParser{}.Parse(fmt.Sprintf("quic:localhost:%d", listenAddr.(*address).port))
+	req.NoError(err)
+
+	conn, err := addr.Dial("test", clientId, 5*time.Second, nil)
+	req.NoError(err)
+	defer func() { _ = conn.Close() }()
+
+	req.Equal("localhost", <-resolver.hosts)
+}
+
+func TestProtocolMismatch(t *testing.T) {
+	req := require.New(t)
+
+	serverId, clientId := testutil.NewIdentities(t)
+
+	closer, err := Listen("127.0.0.1:0", "test", serverId, func(conn transport.Conn) {
+		_ = conn.Close()
+	}, transport.Configuration{transport.KeyProtocol: "foo"})
+	req.NoError(err)
+	defer func() { _ = closer.Close() }()
+
+	addr, err := AddressParser{}.Parse(closer.Addr())
+	req.NoError(err)
+
+	_, err = Dial(*addr.(*address), "test", clientId, 5*time.Second, "bar")
+	req.Error(err)
+}
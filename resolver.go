@@ -0,0 +1,168 @@
+/*
+	Copyright NetFoundry Inc.
+
+	Licensed under the Apache License, Version 2.0 (the "License");
+	you may not use this file except in compliance with the License.
+	You may obtain a copy of the License at
+
+	https://www.apache.org/licenses/LICENSE-2.0
+
+	Unless required by applicable law or agreed to in writing, software
+	distributed under the License is distributed on an "AS IS" BASIS,
+	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+	See the License for the specific language governing permissions and
+	limitations under the License.
+*/
+
+package transport
+
+import (
+	"context"
+	"net"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/openziti/foundation/v2/concurrenz"
+)
+
+// Resolver looks up the IP addresses of a host. It is implemented by *net.Resolver
+type Resolver interface {
+	LookupIPAddr(ctx context.Context, host string) ([]net.IPAddr, error)
+}
+
+var resolverAtomic concurrenz.AtomicValue[*Resolver]
+
+// SetResolver sets the resolver used by dialers to look up host names. Host names are resolved each time a
+// connection is dialed, so a CachingResolver may be used to limit the number of lookups. Passing nil restores the
+// default, net.DefaultResolver
+func SetResolver(resolver Resolver) {
+	if resolver == nil {
+		resolver = net.DefaultResolver
+	}
+	resolverAtomic.Store(&resolver)
+}
+
+// GetResolver returns the resolver used by dialers to look up host names
+func GetResolver() Resolver {
+	if resolver := resolverAtomic.Load(); resolver != nil {
+		return *resolver
+	}
+	return net.DefaultResolver
+}
+
+// resolverCacheSize is the maximum number of host names a CachingResolver keeps entries for
+const resolverCacheSize = 1024
+
+// CachingResolver caches successful lookups of the wrapped resolver. The TTLs of DNS records are not honoured, as the
+// go resolver doesn't expose them. Instead, every entry is kept for the same fixed TTL, which should be set no higher
+// than the lowest TTL of the records being looked up. Failed lookups aren't cached. Expired entries are removed when
+// they are next looked up or when room is needed for a new entry, and once the cache is full, the entries closest to
+// expiring are evicted first.
+type CachingResolver struct {
+	resolver   Resolver
+	ttl        time.Duration
+	maxEntries int
+	lock       sync.Mutex
+	entries    map[string]*resolverCacheEntry
+}
+
+type resolverCacheEntry struct {
+	ips     []net.IPAddr
+	expires time.Time
+}
+
+// NewCachingResolver returns a resolver which caches lookups made with the given resolver for ttl. If resolver is
+// nil, net.DefaultResolver is used
+func NewCachingResolver(resolver Resolver, ttl time.Duration) *CachingResolver {
+	if resolver == nil {
+		resolver = net.DefaultResolver
+	}
+	return &CachingResolver{
+		resolver:   resolver,
+		ttl:        ttl,
+		maxEntries: resolverCacheSize,
+		entries:    map[string]*resolverCacheEntry{},
+	}
+}
+
+func (self *CachingResolver) LookupIPAddr(ctx context.Context, host string) ([]net.IPAddr, error) {
+	now := time.Now()
+
+	self.lock.Lock()
+	entry, found := self.entries[host]
+	if found && !now.Before(entry.expires) {
+		delete(self.entries, host)
+		found = false
+	}
+	self.lock.Unlock()
+
+	if found {
+		return slices.Clone(entry.ips), nil
+	}
+
+	ips, err := self.resolver.LookupIPAddr(ctx, host)
+	if err != nil {
+		return nil, err
+	}
+
+	self.lock.Lock()
+	if _, found = self.entries[host]; !found && len(self.entries) >= self.maxEntries {
+		self.evict(time.Now())
+	}
+	self.entries[host] = &resolverCacheEntry{
+		ips:     slices.Clone(ips),
+		expires: now.Add(self.ttl),
+	}
+	self.lock.Unlock()
+
+	return ips, nil
+}
+
+// evict makes room for a new entry, by removing all expired entries or, if there are none, the entry which expires
+// first. Must be called with the lock held
+func (self *CachingResolver) evict(now time.Time) {
+	var first string
+	var firstEntry *resolverCacheEntry
+	for host, entry := range self.entries {
+		if !now.Before(entry.expires) {
+			delete(self.entries, host)
+		} else if firstEntry == nil || entry.expires.Before(firstEntry.expires) {
+			first, firstEntry = host, entry
+		}
+	}
+
+	if len(self.entries) >= self.maxEntries {
+		delete(self.entries, first)
+	}
+}
+
+// Flush removes all cached entries
+func (self *CachingResolver) Flush() {
+	self.lock.Lock()
+	self.entries = map[string]*resolverCacheEntry{}
+	self.lock.Unlock()
+}
+
+// ResolveUDPAddress is the equivalent of net.ResolveUDPAddr using the resolver set with SetResolver. As with
+// net.ResolveUDPAddr, IPv4 addresses are preferred and an empty host results in an address with no IP set
+func ResolveUDPAddress(ctx context.Context, host string, port uint16) (*net.UDPAddr, error) {
+	if host == "" {
+		return &net.UDPAddr{Port: int(port)}, nil
+	}
+
+	candidates, err := ResolveCandidates(ctx, nil, "udp", host)
+	if err != nil {
+		return nil, err
+	}
+
+	ip := candidates[0]
+	for _, candidate := range candidates {
+		if candidate.IP.To4() != nil {
+			ip = candidate
+			break
+		}
+	}
+
+	return &net.UDPAddr{IP: ip.IP, Zone: ip.Zone, Port: int(port)}, nil
+}
@@ -0,0 +1,124 @@
+package transport
+
+import (
+	"context"
+	"net"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+type countingResolver struct {
+	Resolver
+	lookups atomic.Int32
+}
+
+func (self *countingResolver) LookupIPAddr(ctx context.Context, host string) ([]net.IPAddr, error) {
+	self.lookups.Add(1)
+	return self.Resolver.LookupIPAddr(ctx, host)
+}
+
+func TestCachingResolver(t *testing.T) {
+	req := require.New(t)
+
+	backing := &countingResolver{Resolver: staticResolver{"ctrl.example.com": ipAddrs("10.0.0.1")}}
+	resolver := NewCachingResolver(backing, 50*time.Millisecond)
+
+	ips, err := resolver.LookupIPAddr(context.Background(), "ctrl.example.com")
+	req.NoError(err)
+	req.Equal(ipAddrs("10.0.0.1"), ips)
+
+	// callers may modify the result without affecting the cache
+	ips[0].IP = net.ParseIP("10.0.0.99")
+
+	ips, err = resolver.LookupIPAddr(context.Background(), "ctrl.example.com")
+	req.NoError(err)
+	req.Equal(ipAddrs("10.0.0.1"), ips)
+	req.Equal(int32(1), backing.lookups.Load())
+
+	// failures aren't cached
+	_, err = resolver.LookupIPAddr(context.Background(), "missing.example.com")
+	req.Error(err)
+	_, err = resolver.LookupIPAddr(context.Background(), "missing.example.com")
+	req.Error(err)
+	req.Equal(int32(3), backing.lookups.Load())
+
+	time.Sleep(60 * time.Millisecond)
+	_, err = resolver.LookupIPAddr(context.Background(), "ctrl.example.com")
+	req.NoError(err)
+	req.Equal(int32(4), backing.lookups.Load())
+
+	resolver.Flush()
+	_, err = resolver.LookupIPAddr(context.Background(), "ctrl.example.com")
+	req.NoError(err)
+	req.Equal(int32(5), backing.lookups.Load())
+}
+
+func TestCachingResolverEviction(t *testing.T) {
+	req := require.New(t)
+
+	backing := &countingResolver{Resolver: staticResolver{
+		"a.example.com": ipAddrs("10.0.0.1"),
+		"b.example.com": ipAddrs("10.0.0.2"),
+		"c.example.com": ipAddrs("10.0.0.3"),
+	}}
+	resolver := NewCachingResolver(backing, time.Hour)
+	resolver.maxEntries = 2
+
+	lookup := func(host string) {
+		_, err := resolver.LookupIPAddr(context.Background(), host)
+		req.NoError(err)
+	}
+
+	lookup("a.example.com")
+	time.Sleep(time.Millisecond)
+	lookup("b.example.com")
+	lookup("c.example.com")
+	req.Len(resolver.entries, 2)
+	req.NotContains(resolver.entries, "a.example.com", "the entry expiring first should have been evicted")
+	req.Equal(int32(3), backing.lookups.Load())
+
+	lookup("b.example.com")
+	lookup("c.example.com")
+	req.Equal(int32(3), backing.lookups.Load())
+
+	// expired entries are removed when looked up, even if the lookup fails
+	resolver.ttl = 0
+	resolver.Flush()
+	lookup("a.example.com")
+	delete(backing.Resolver.(staticResolver), "a.example.com")
+	_, err := resolver.LookupIPAddr(context.Background(), "a.example.com")
+	req.Error(err)
+	req.Empty(resolver.entries)
+}
+
+func TestResolveUDPAddress(t *testing.T) {
+	req := require.New(t)
+
+	resolver := staticResolver{"ctrl.example.com": ipAddrs("2001:db8::1", "10.0.0.1")}
+	SetResolver(resolver)
+	defer SetResolver(nil)
+	req.Equal(resolver, GetResolver())
+
+	addr, err := ResolveUDPAddress(context.Background(), "ctrl.example.com", 6262)
+	req.NoError(err)
+	req.Equal("10.0.0.1:6262", addr.String())
+
+	// dns changes are picked up on the next resolve
+	resolver["ctrl.example.com"] = ipAddrs("10.0.0.2")
+	addr, err = ResolveUDPAddress(context.Background(), "ctrl.example.com", 6262)
+	req.NoError(err)
+	req.Equal("10.0.0.2:6262", addr.String())
+
+	addr, err = ResolveUDPAddress(context.Background(), "", 6262)
+	req.NoError(err)
+	req.Equal(&net.UDPAddr{Port: 6262}, addr)
+
+	_, err = ResolveUDPAddress(context.Background(), "missing.example.com", 6262)
+	req.Error(err)
+
+	SetResolver(nil)
+	req.Equal(net.DefaultResolver, GetResolver())
+}
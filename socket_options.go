@@ -0,0 +1,427 @@
+/*
+	Copyright NetFoundry Inc.
+
+	Licensed under the Apache License, Version 2.0 (the "License");
+	you may not use this file except in compliance with the License.
+	You may obtain a copy of the License at
+
+	https://www.apache.org/licenses/LICENSE-2.0
+
+	Unless required by applicable law or agreed to in writing, software
+	distributed under the License is distributed on an "AS IS" BASIS,
+	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+	See the License for the specific language governing permissions and
+	limitations under the License.
+*/
+
+package transport
+
+import (
+	"net"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	// DefaultKeepAlive matches the keepalive which the go runtime enables on tcp connections by default
+	DefaultKeepAlive = 15 * time.Second
+
+	// DefaultKeepAliveCount matches the keepalive probe count used by the go runtime by default
+	DefaultKeepAliveCount = 9
+)
+
+// SocketOptions are the tcp socket options which the tcp and tls transports apply to the connections they dial and
+// accept. They are loaded from the socket section of the transport configuration, for example:
+//
+//	socket:
+//	  noDelay: true
+//	  keepAlive:
+//	    idle: 30s
+//	    interval: 10s
+//	    count: 5
+//	  userTimeout: 45s
+//	  sendBufferSize: 4194304
+//	  receiveBufferSize: 4194304
+//	  linger: 0s
+//	  dscp: 46
+//	  multipathTcp: true
+//	  listenerSockets: 4
+//
+// keepAlive may also be set to false to disable keepalive probes. Marking may be given either as a dscp code point
+// or as a raw tos byte. Settings which are left out keep the values from DefaultSocketOptions.
+type SocketOptions struct {
+	// NoDelay disables Nagle's algorithm, so that small writes are sent immediately
+	NoDelay bool
+
+	// KeepAlive configures keepalive probes. As with net.KeepAliveConfig, zero values select the go defaults and
+	// negative values leave the operating system defaults in place
+	KeepAlive net.KeepAliveConfig
+
+	// UserTimeout is how long sent data may remain unacknowledged before the connection is dropped. Only
+	// supported on linux. Zero leaves the operating system default in place
+	UserTimeout time.Duration
+
+	// SendBufferSize and ReceiveBufferSize set the socket buffer sizes in bytes. Zero leaves buffer sizing to the
+	// operating system
+	SendBufferSize    int
+	ReceiveBufferSize int
+
+	// Linger controls what happens to unsent data on close, see net.TCPConn.SetLinger. It is applied with second
+	// granularity. Negative leaves the operating system default in place
+	Linger time.Duration
+
+	// TOS is the value of the IPv4 type of service or IPv6 traffic class byte. The DSCP code point occupies the
+	// upper six bits. Negative leaves packets unmarked. Not supported on windows
+	TOS int
+
+	// MultipathTCP requests MPTCP for dialed connections and listening sockets. MPTCP is only available on linux
+	// hosts which support it, otherwise connections fall back to plain tcp. Whether a connection actually uses
+	// MPTCP is reported by MultipathTCPActive. If false, the go defaults apply, which use MPTCP for listening
+	// sockets where available, but not for dialing
+	MultipathTCP bool
+
+	// ListenerSockets is the number of sockets to listen on per bind address. If more than one, the sockets are
+	// opened with SO_REUSEPORT, and the kernel spreads incoming connections across them. This avoids a single
+	// accept loop becoming a bottleneck when many clients connect at once. Only supported on linux
+	ListenerSockets int
+}
+
+// DefaultSocketOptions returns the socket options used when none are configured. They match the settings which the
+// go runtime applies to tcp connections by default
+func DefaultSocketOptions() *SocketOptions {
+	return &SocketOptions{
+		NoDelay: true,
+		KeepAlive: net.KeepAliveConfig{
+			Enable:   true,
+			Idle:     DefaultKeepAlive,
+			Interval: DefaultKeepAlive,
+			Count:    DefaultKeepAliveCount,
+		},
+		Linger:          -1,
+		TOS:             -1,
+		ListenerSockets: 1,
+	}
+}
+
+func (self Configuration) GetSocketOptions() (*SocketOptions, error) {
+	if self == nil {
+		return DefaultSocketOptions(), nil
+	}
+
+	if val, found := self[KeyCachedSocketOptions]; found {
+		return val.(*SocketOptions), nil
+	}
+
+	result := DefaultSocketOptions()
+
+	if val, found := self[KeySocket]; found {
+		cfg, ok := val.(map[interface{}]interface{})
+		if !ok {
+			return nil, errors.New("invalid socket configuration value, should be map")
+		}
+
+		var err error
+		if result, err = LoadSocketOptions(cfg); err != nil {
+			return nil, err
+		}
+	}
+
+	self[KeyCachedSocketOptions] = result
+
+	return result, nil
+}
+
+// LoadSocketOptions reads socket options from the socket section of a transport configuration. See SocketOptions
+// for the supported settings
+func LoadSocketOptions(cfg map[interface{}]interface{}) (*SocketOptions, error) {
+	result := DefaultSocketOptions()
+
+	var err error
+	if result.NoDelay, err = socketBoolValue(cfg, "noDelay", result.NoDelay); err != nil {
+		return nil, err
+	}
+
+	if result.MultipathTCP, err = socketBoolValue(cfg, "multipathTcp", result.MultipathTCP); err != nil {
+		return nil, err
+	}
+
+	if val, found := cfg["keepAlive"]; found {
+		switch v := val.(type) {
+		case bool:
+			result.KeepAlive.Enable = v
+		case map[interface{}]interface{}:
+			if result.KeepAlive.Enable, err = socketBoolValue(v, "enabled", true); err != nil {
+				return nil, err
+			}
+			if result.KeepAlive.Idle, err = socketDurationValue(v, "idle", result.KeepAlive.Idle); err != nil {
+				return nil, err
+			}
+			if result.KeepAlive.Interval, err = socketDurationValue(v, "interval", result.KeepAlive.Interval); err != nil {
+				return nil, err
+			}
+			if result.KeepAlive.Count, err = socketIntValue(v, "count", result.KeepAlive.Count); err != nil {
+				return nil, err
+			}
+		default:
+			return nil, errors.Errorf("invalid value for socket keepAlive [%v], must be bool or map", val)
+		}
+	}
+
+	if result.UserTimeout, err = socketDurationValue(cfg, "userTimeout", result.UserTimeout); err != nil {
+		return nil, err
+	}
+	if result.UserTimeout < 0 {
+		return nil, errors.Errorf("invalid value for socket userTimeout [%v], must not be negative", result.UserTimeout)
+	}
+	if result.UserTimeout > 0 && !userTimeoutSupported {
+		return nil, errors.New("socket userTimeout is only supported on linux")
+	}
+
+	if result.SendBufferSize, err = socketIntValue(cfg, "sendBufferSize", result.SendBufferSize); err != nil {
+		return nil, err
+	}
+	if result.ReceiveBufferSize, err = socketIntValue(cfg, "receiveBufferSize", result.ReceiveBufferSize); err != nil {
+		return nil, err
+	}
+	if result.SendBufferSize < 0 || result.ReceiveBufferSize < 0 {
+		return nil, errors.New("socket buffer sizes must not be negative")
+	}
+
+	if result.Linger, err = socketDurationValue(cfg, "linger", result.Linger); err != nil {
+		return nil, err
+	}
+
+	_, hasTos := cfg["tos"]
+	_, hasDscp := cfg["dscp"]
+	if hasTos && hasDscp {
+		return nil, errors.New("only one of socket tos and dscp may be set")
+	}
+
+	if hasTos {
+		if result.TOS, err = socketIntValue(cfg, "tos", result.TOS); err != nil {
+			return nil, err
+		}
+		if result.TOS < 0 || result.TOS > 255 {
+			return nil, errors.Errorf("invalid value for socket tos [%d], must be between 0 and 255", result.TOS)
+		}
+	}
+
+	if hasDscp {
+		dscp, err := socketIntValue(cfg, "dscp", 0)
+		if err != nil {
+			return nil, err
+		}
+		if dscp < 0 || dscp > 63 {
+			return nil, errors.Errorf("invalid value for socket dscp [%d], must be between 0 and 63", dscp)
+		}
+		result.TOS = dscp << 2
+	}
+
+	if result.TOS >= 0 && !tosSupported {
+		return nil, errors.New("socket tos and dscp marking is not supported on this platform")
+	}
+
+	if result.ListenerSockets, err = socketIntValue(cfg, "listenerSockets", result.ListenerSockets); err != nil {
+		return nil, err
+	}
+	if result.ListenerSockets < 1 {
+		return nil, errors.Errorf("invalid value for socket listenerSockets [%d], must be at least 1", result.ListenerSockets)
+	}
+	if result.ListenerSockets > 1 && !reusePortSupported {
+		return nil, errors.New("socket listenerSockets is only supported on linux")
+	}
+
+	return result, nil
+}
+
+func socketBoolValue(cfg map[interface{}]interface{}, key string, defaultValue bool) (bool, error) {
+	val, found := cfg[key]
+	if !found {
+		return defaultValue, nil
+	}
+	result, ok := val.(bool)
+	if !ok {
+		return false, errors.Errorf("invalid value for socket %s [%v], must be bool", key, val)
+	}
+	return result, nil
+}
+
+func socketIntValue(cfg map[interface{}]interface{}, key string, defaultValue int) (int, error) {
+	val, found := cfg[key]
+	if !found {
+		return defaultValue, nil
+	}
+	result, ok := val.(int)
+	if !ok {
+		return 0, errors.Errorf("invalid value for socket %s [%v], must be int", key, val)
+	}
+	return result, nil
+}
+
+func socketDurationValue(cfg map[interface{}]interface{}, key string, defaultValue time.Duration) (time.Duration, error) {
+	val, found := cfg[key]
+	if !found {
+		return defaultValue, nil
+	}
+	strVal, ok := val.(string)
+	if !ok {
+		return 0, errors.Errorf("invalid value for socket %s [%v], must be duration string", key, val)
+	}
+	result, err := time.ParseDuration(strVal)
+	if err != nil {
+		return 0, errors.Wrapf(err, "unable to parse socket %s '%s' to duration", key, strVal)
+	}
+	return result, nil
+}
+
+// Control returns a function for net.Dialer or net.ListenConfig which sets the options that have to be in place
+// before a connection is established: the buffer sizes, which determine the advertised window scaling, the tos
+// marking, so that it also applies to the handshake, and the user timeout. Returns nil if there is nothing to set.
+// Sockets which aren't tcp are left alone. A nil receiver has nothing to set
+func (self *SocketOptions) Control() func(network, address string, c syscall.RawConn) error {
+	if self == nil || (self.SendBufferSize == 0 && self.ReceiveBufferSize == 0 && self.TOS < 0 && self.UserTimeout == 0) {
+		return nil
+	}
+
+	return func(network, address string, c syscall.RawConn) error {
+		if !strings.HasPrefix(network, "tcp") {
+			return nil
+		}
+		return self.setRawOptions(c, network == "tcp6")
+	}
+}
+
+// ListenConfig returns a net.ListenConfig for creating listening sockets with the options. A nil receiver returns a
+// ListenConfig with the go defaults
+func (self *SocketOptions) ListenConfig() *net.ListenConfig {
+	result := &net.ListenConfig{
+		Control: self.Control(),
+	}
+	if self != nil && self.MultipathTCP {
+		result.SetMultipathTCP(true)
+	}
+	return result
+}
+
+// Apply sets the options on an established connection. Connections which aren't tcp are left alone, tls and PROXY
+// protocol connections are unwrapped first. A nil receiver leaves the go defaults in place
+func (self *SocketOptions) Apply(conn net.Conn) error {
+	if self == nil {
+		return nil
+	}
+
+	tcpConn := tcpConnOf(conn)
+	if tcpConn == nil {
+		return nil
+	}
+
+	if err := tcpConn.SetNoDelay(self.NoDelay); err != nil {
+		return errors.Wrap(err, "unable to set tcp nodelay")
+	}
+
+	if self.KeepAlive.Enable {
+		if err := tcpConn.SetKeepAliveConfig(self.KeepAlive); err != nil {
+			return errors.Wrap(err, "unable to set tcp keepalive")
+		}
+	} else if err := tcpConn.SetKeepAlive(false); err != nil {
+		return errors.Wrap(err, "unable to disable tcp keepalive")
+	}
+
+	if self.Linger >= 0 {
+		if err := tcpConn.SetLinger(int(self.Linger / time.Second)); err != nil {
+			return errors.Wrap(err, "unable to set socket linger")
+		}
+	}
+
+	// accepted connections usually inherit these from the listening socket, but not on every platform
+	rawConn, err := tcpConn.SyscallConn()
+	if err != nil {
+		return err
+	}
+
+	var ipv6 bool
+	if addr, ok := tcpConn.LocalAddr().(*net.TCPAddr); ok {
+		ipv6 = addr.IP.To4() == nil
+	}
+
+	return self.setRawOptions(rawConn, ipv6)
+}
+
+func (self *SocketOptions) setRawOptions(c syscall.RawConn, ipv6 bool) error {
+	var sockErr error
+	err := c.Control(func(fd uintptr) {
+		if self.SendBufferSize > 0 {
+			if sockErr = setSendBufferSize(fd, self.SendBufferSize); sockErr != nil {
+				sockErr = errors.Wrap(sockErr, "unable to set socket send buffer size")
+				return
+			}
+		}
+
+		if self.ReceiveBufferSize > 0 {
+			if sockErr = setReceiveBufferSize(fd, self.ReceiveBufferSize); sockErr != nil {
+				sockErr = errors.Wrap(sockErr, "unable to set socket receive buffer size")
+				return
+			}
+		}
+
+		if self.TOS >= 0 {
+			if sockErr = setTOS(fd, ipv6, self.TOS); sockErr != nil {
+				sockErr = errors.Wrap(sockErr, "unable to set socket tos")
+				return
+			}
+		}
+
+		if self.UserTimeout > 0 {
+			if sockErr = setUserTimeout(fd, self.UserTimeout); sockErr != nil {
+				sockErr = errors.Wrap(sockErr, "unable to set tcp user timeout")
+				return
+			}
+		}
+	})
+
+	if err != nil {
+		return err
+	}
+	return sockErr
+}
+
+// MultipathTCPActive reports whether the connection uses MPTCP. tls and PROXY protocol connections are unwrapped
+// first. Connections which aren't tcp never do
+func MultipathTCPActive(conn net.Conn) bool {
+	if tcpConn := tcpConnOf(conn); tcpConn != nil {
+		active, err := tcpConn.MultipathTCP()
+		return err == nil && active
+	}
+
+	return false
+}
+
+// chainControl combines socket control functions, skipping any which are nil. Returns nil if all are nil
+func chainControl(controls ...func(network, address string, c syscall.RawConn) error) func(network, address string, c syscall.RawConn) error {
+	var result []func(network, address string, c syscall.RawConn) error
+	for _, control := range controls {
+		if control != nil {
+			result = append(result, control)
+		}
+	}
+
+	if len(result) == 0 {
+		return nil
+	}
+
+	if len(result) == 1 {
+		return result[0]
+	}
+
+	return func(network, address string, c syscall.RawConn) error {
+		for _, control := range result {
+			if err := control(network, address, c); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+}
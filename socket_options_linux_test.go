@@ -0,0 +1,133 @@
+//go:build linux
+
+package transport
+
+import (
+	"context"
+	"net"
+	"sync/atomic"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"golang.org/x/sys/unix"
+)
+
+func getSockOpt(t *testing.T, conn net.Conn, level, opt int) int {
+	rawConn, err := conn.(*net.TCPConn).SyscallConn()
+	require.NoError(t, err)
+
+	var val int
+	var sockErr error
+	require.NoError(t, rawConn.Control(func(fd uintptr) {
+		val, sockErr = unix.GetsockoptInt(int(fd), level, opt)
+	}))
+	require.NoError(t, sockErr)
+	return val
+}
+
+func TestSocketOptionsApplied(t *testing.T) {
+	req := require.New(t)
+
+	opts, err := LoadSocketOptions(map[interface{}]interface{}{
+		"noDelay": false,
+		"keepAlive": map[interface{}]interface{}{
+			"idle":     "30s",
+			"interval": "10s",
+			"count":    5,
+		},
+		"userTimeout":       "45s",
+		"receiveBufferSize": 1 << 18,
+		"dscp":              10,
+	})
+	req.NoError(err)
+
+	listenConfig := &net.ListenConfig{Control: opts.Control()}
+	listener, err := listenConfig.Listen(context.Background(), "tcp4", "127.0.0.1:0")
+	req.NoError(err)
+	defer func() { _ = listener.Close() }()
+
+	accepted := make(chan net.Conn, 1)
+	go func() {
+		conn, err := listener.Accept()
+		if err == nil {
+			accepted <- conn
+		}
+	}()
+
+	dialer := NewHappyEyeballsDialer(&net.Dialer{})
+	dialer.SocketOptions = opts
+	conn, err := dialer.Dial("tcp", listener.Addr().String())
+	req.NoError(err)
+	defer func() { _ = conn.Close() }()
+
+	var serverConn net.Conn
+	select {
+	case serverConn = <-accepted:
+	case <-time.After(5 * time.Second):
+		req.Fail("timed out waiting for connection")
+	}
+	defer func() { _ = serverConn.Close() }()
+	req.NoError(opts.Apply(serverConn))
+
+	for _, c := range []net.Conn{conn, serverConn} {
+		req.Equal(0, getSockOpt(t, c, syscall.IPPROTO_TCP, syscall.TCP_NODELAY))
+		req.Equal(1, getSockOpt(t, c, syscall.SOL_SOCKET, syscall.SO_KEEPALIVE))
+		req.Equal(30, getSockOpt(t, c, syscall.IPPROTO_TCP, syscall.TCP_KEEPIDLE))
+		req.Equal(10, getSockOpt(t, c, syscall.IPPROTO_TCP, syscall.TCP_KEEPINTVL))
+		req.Equal(5, getSockOpt(t, c, syscall.IPPROTO_TCP, syscall.TCP_KEEPCNT))
+		req.Equal(45000, getSockOpt(t, c, syscall.IPPROTO_TCP, unix.TCP_USER_TIMEOUT))
+		req.Equal(10<<2, getSockOpt(t, c, syscall.IPPROTO_IP, syscall.IP_TOS))
+		// linux doubles the requested size to account for bookkeeping overhead
+		req.GreaterOrEqual(getSockOpt(t, c, syscall.SOL_SOCKET, syscall.SO_RCVBUF), 1<<18)
+	}
+}
+
+func TestListenerSockets(t *testing.T) {
+	req := require.New(t)
+
+	opts, err := LoadSocketOptions(map[interface{}]interface{}{"listenerSockets": 3})
+	req.NoError(err)
+
+	listeners, err := opts.Listen(context.Background(), "tcp", "127.0.0.1:0")
+	req.NoError(err)
+	defer func() { _ = CloseListeners(listeners) }()
+	req.Len(listeners, 3)
+
+	addr := listeners[0].Addr().String()
+	counts := make([]atomic.Int32, len(listeners))
+	for i, listener := range listeners {
+		req.Equal(addr, listener.Addr().String())
+		go func() {
+			for {
+				conn, err := listener.Accept()
+				if err != nil {
+					return
+				}
+				counts[i].Add(1)
+				_ = conn.Close()
+			}
+		}()
+	}
+
+	const connections = 50
+	for range connections {
+		conn, err := net.Dial("tcp", addr)
+		req.NoError(err)
+		_ = conn.Close()
+	}
+
+	var total, used int32
+	req.Eventually(func() bool {
+		total, used = 0, 0
+		for i := range counts {
+			if count := counts[i].Load(); count > 0 {
+				total += count
+				used++
+			}
+		}
+		return total == connections
+	}, 5*time.Second, 10*time.Millisecond)
+	req.Greater(used, int32(1), "connections should be spread across the sockets")
+}
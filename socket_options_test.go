@@ -0,0 +1,97 @@
+package transport
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestGetSocketOptionsDefaults(t *testing.T) {
+	req := require.New(t)
+
+	opts, err := Configuration(nil).GetSocketOptions()
+	req.NoError(err)
+	req.Equal(DefaultSocketOptions(), opts)
+
+	tcfg := Configuration{}
+	opts, err = tcfg.GetSocketOptions()
+	req.NoError(err)
+	req.Equal(DefaultSocketOptions(), opts)
+	req.True(opts.NoDelay)
+	req.True(opts.KeepAlive.Enable)
+	req.Equal(DefaultKeepAlive, opts.KeepAlive.Idle)
+	req.Nil(opts.Control())
+}
+
+func TestLoadSocketOptions(t *testing.T) {
+	req := require.New(t)
+
+	tcfg := Configuration{
+		KeySocket: map[interface{}]interface{}{
+			"noDelay": false,
+			"keepAlive": map[interface{}]interface{}{
+				"idle":     "30s",
+				"interval": "10s",
+				"count":    5,
+			},
+			"sendBufferSize":    1 << 20,
+			"receiveBufferSize": 1 << 21,
+			"linger":            "0s",
+			"dscp":              46,
+			"multipathTcp":      true,
+		},
+	}
+
+	opts, err := tcfg.GetSocketOptions()
+	req.NoError(err)
+	req.False(opts.NoDelay)
+	req.Equal(net.KeepAliveConfig{Enable: true, Idle: 30 * time.Second, Interval: 10 * time.Second, Count: 5}, opts.KeepAlive)
+	req.Equal(1<<20, opts.SendBufferSize)
+	req.Equal(1<<21, opts.ReceiveBufferSize)
+	req.Equal(time.Duration(0), opts.Linger)
+	req.Equal(46<<2, opts.TOS)
+	req.True(opts.MultipathTCP)
+	req.NotNil(opts.Control())
+
+	cached, err := tcfg.GetSocketOptions()
+	req.NoError(err)
+	req.Same(opts, cached)
+
+	opts, err = LoadSocketOptions(map[interface{}]interface{}{"keepAlive": false, "tos": 0x10})
+	req.NoError(err)
+	req.False(opts.KeepAlive.Enable)
+	req.Equal(0x10, opts.TOS)
+	req.True(opts.NoDelay)
+}
+
+func TestLoadSocketOptionsInvalid(t *testing.T) {
+	tests := []struct {
+		name string
+		cfg  map[interface{}]interface{}
+	}{
+		{"noDelay not bool", map[interface{}]interface{}{"noDelay": "yes"}},
+		{"multipathTcp not bool", map[interface{}]interface{}{"multipathTcp": 1}},
+		{"keepAlive not map", map[interface{}]interface{}{"keepAlive": "15s"}},
+		{"bad keepAlive idle", map[interface{}]interface{}{"keepAlive": map[interface{}]interface{}{"idle": "soon"}}},
+		{"keepAlive count not int", map[interface{}]interface{}{"keepAlive": map[interface{}]interface{}{"count": "9"}}},
+		{"negative user timeout", map[interface{}]interface{}{"userTimeout": "-1s"}},
+		{"negative buffer", map[interface{}]interface{}{"sendBufferSize": -1}},
+		{"linger not duration", map[interface{}]interface{}{"linger": 5}},
+		{"tos and dscp", map[interface{}]interface{}{"tos": 0x10, "dscp": 4}},
+		{"tos out of range", map[interface{}]interface{}{"tos": 256}},
+		{"no listener sockets", map[interface{}]interface{}{"listenerSockets": 0}},
+		{"dscp out of range", map[interface{}]interface{}{"dscp": 64}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := LoadSocketOptions(tt.cfg)
+			require.Error(t, err)
+		})
+	}
+
+	_, err := Configuration{KeySocket: "fast"}.GetSocketOptions()
+	require.Error(t, err)
+}
@@ -0,0 +1,48 @@
+//go:build !unix && !windows
+
+/*
+	Copyright NetFoundry Inc.
+
+	Licensed under the Apache License, Version 2.0 (the "License");
+	you may not use this file except in compliance with the License.
+	You may obtain a copy of the License at
+
+	https://www.apache.org/licenses/LICENSE-2.0
+
+	Unless required by applicable law or agreed to in writing, software
+	distributed under the License is distributed on an "AS IS" BASIS,
+	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+	See the License for the specific language governing permissions and
+	limitations under the License.
+*/
+
+package transport
+
+import (
+	"errors"
+	"syscall"
+)
+
+const tosSupported = false
+
+var errSocketOptionUnsupported = errors.New("socket options are not supported on this platform")
+
+func setReuseAddr(syscall.RawConn) error {
+	return nil
+}
+
+func isLocalAddressUnavailable(error) bool {
+	return false
+}
+
+func setSendBufferSize(uintptr, int) error {
+	return errSocketOptionUnsupported
+}
+
+func setReceiveBufferSize(uintptr, int) error {
+	return errSocketOptionUnsupported
+}
+
+func setTOS(uintptr, bool, int) error {
+	return errSocketOptionUnsupported
+}
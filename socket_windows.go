@@ -0,0 +1,53 @@
+//go:build windows
+
+/*
+	Copyright NetFoundry Inc.
+
+	Licensed under the Apache License, Version 2.0 (the "License");
+	you may not use this file except in compliance with the License.
+	You may obtain a copy of the License at
+
+	https://www.apache.org/licenses/LICENSE-2.0
+
+	Unless required by applicable law or agreed to in writing, software
+	distributed under the License is distributed on an "AS IS" BASIS,
+	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+	See the License for the specific language governing permissions and
+	limitations under the License.
+*/
+
+package transport
+
+import (
+	"errors"
+	"syscall"
+)
+
+const (
+	wsaEADDRINUSE    = syscall.Errno(10048)
+	wsaEADDRNOTAVAIL = syscall.Errno(10049)
+)
+
+// setReuseAddr does nothing on windows, where SO_REUSEADDR allows taking over ports which are actively in use
+func setReuseAddr(syscall.RawConn) error {
+	return nil
+}
+
+func isLocalAddressUnavailable(err error) bool {
+	return errors.Is(err, wsaEADDRINUSE) || errors.Is(err, wsaEADDRNOTAVAIL)
+}
+
+// tosSupported is false on windows, which ignores IP_TOS unless marking is configured through QoS policies
+const tosSupported = false
+
+func setSendBufferSize(fd uintptr, size int) error {
+	return syscall.SetsockoptInt(syscall.Handle(fd), syscall.SOL_SOCKET, syscall.SO_SNDBUF, size)
+}
+
+func setReceiveBufferSize(fd uintptr, size int) error {
+	return syscall.SetsockoptInt(syscall.Handle(fd), syscall.SOL_SOCKET, syscall.SO_RCVBUF, size)
+}
+
+func setTOS(uintptr, bool, int) error {
+	return errors.New("tos marking is not supported on windows")
+}
@@ -17,12 +17,13 @@
 package tcp
 
 import (
+	"context"
 	"fmt"
-	"io"
 	"time"
 
 	"github.com/openziti/identity"
 	"github.com/openziti/transport/v2"
+	"github.com/pkg/errors"
 )
 
 var _ transport.Address = &address{} // enforce that address implements transport.Address
@@ -32,21 +33,47 @@ const Type = "tcp"
 type address struct {
 	hostname string
 	port     uint16
+
+	// inherited is set to the bind address of an inherited socket, such as fd:3, which can only be listened on
+	inherited string
 }
 
-func (a address) Dial(name string, i *identity.TokenId, timeout time.Duration, _ transport.Configuration) (transport.Conn, error) {
-	return Dial(a.bindableAddress(), name, timeout)
+func (a address) Dial(name string, i *identity.TokenId, timeout time.Duration, tcfg transport.Configuration) (transport.Conn, error) {
+	ctx, cancelF := transport.TimeoutContext(timeout)
+	defer cancelF()
+	return a.DialContext(ctx, name, i, tcfg)
 }
 
 func (a address) DialWithLocalBinding(name string, localBinding string, i *identity.TokenId, timeout time.Duration, tcfg transport.Configuration) (transport.Conn, error) {
-	return DialWithLocalBinding(a.bindableAddress(), name, localBinding, timeout)
+	ctx, cancelF := transport.TimeoutContext(timeout)
+	defer cancelF()
+	return a.DialWithLocalBindingContext(ctx, name, localBinding, i, tcfg)
+}
+
+func (a address) DialContext(ctx context.Context, name string, i *identity.TokenId, tcfg transport.Configuration) (transport.Conn, error) {
+	return a.DialWithLocalBindingContext(ctx, name, "", i, tcfg)
+}
+
+func (a address) DialWithLocalBindingContext(ctx context.Context, name string, localBinding string, _ *identity.TokenId, tcfg transport.Configuration) (transport.Conn, error) {
+	if a.inherited != "" {
+		return nil, errors.Errorf("unable to dial %v, inherited sockets can only be listened on", a)
+	}
+	proxyConfig, err := tcfg.GetProxyConfiguration()
+	if err != nil {
+		return nil, errors.Wrapf(err, "unable to get proxy configuration")
+	}
+	socketOptions, err := tcfg.GetSocketOptions()
+	if err != nil {
+		return nil, errors.Wrapf(err, "unable to get socket options")
+	}
+	return DialWithLocalBindingContext(ctx, a.bindableAddress(), name, localBinding, proxyConfig, socketOptions)
 }
 
-func (a address) Listen(name string, i *identity.TokenId, acceptF func(transport.Conn), _ transport.Configuration) (io.Closer, error) {
-	return Listen(a.bindableAddress(), name, acceptF)
+func (a address) Listen(name string, i *identity.TokenId, acceptF func(transport.Conn), tcfg transport.Configuration) (transport.Listener, error) {
+	return ListenWithConfig(a.bindableAddress(), name, acceptF, tcfg)
 }
 
-func (a address) MustListen(name string, i *identity.TokenId, acceptF func(transport.Conn), tcfg transport.Configuration) io.Closer {
+func (a address) MustListen(name string, i *identity.TokenId, acceptF func(transport.Conn), tcfg transport.Configuration) transport.Listener {
 	closer, err := a.Listen(name, i, acceptF, tcfg)
 	if err != nil {
 		panic(err)
@@ -59,6 +86,9 @@ func (a address) String() string {
 }
 
 func (a address) bindableAddress() string {
+	if a.inherited != "" {
+		return a.inherited
+	}
 	return transport.HostPortString(a.hostname, a.port)
 }
 
@@ -77,6 +107,9 @@ func (a address) Port() uint16 {
 type AddressParser struct{}
 
 func (ap AddressParser) Parse(s string) (transport.Address, error) {
+	if inherited, ok := transport.ParseInheritedAddress(s, Type); ok {
+		return &address{inherited: inherited}, nil
+	}
 	host, port, err := transport.ParseAddressHostPort(s, Type)
 	if err != nil {
 		return nil, err
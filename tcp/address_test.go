@@ -17,6 +17,8 @@ func TestParseAndString(t *testing.T) {
 		{"ipv4 ip", "tcp:127.0.0.1:8080", "tcp:127.0.0.1:8080", false},
 		{"ipv6 loopback", "tcp:[::1]:8080", "tcp:[::1]:8080", false},
 		{"ipv6 full", "tcp:[fe80::1]:443", "tcp:[fe80::1]:443", false},
+		{"inherited fd", "tcp:fd:3", "tcp:fd:3", false},
+		{"inherited name", "tcp:fd:router", "tcp:fd:router", false},
 		{"wrong prefix", "udp:localhost:8080", "", true},
 	}
 
@@ -17,47 +17,59 @@
 package tcp
 
 import (
+	"context"
 	"net"
 	"time"
 
 	"github.com/openziti/transport/v2"
+	"github.com/openziti/transport/v2/proxies"
 )
 
 func Dial(destination, name string, timeout time.Duration) (transport.Conn, error) {
-	socket, err := net.DialTimeout("tcp", destination, timeout)
-	if err != nil {
-		return nil, err
-	}
-
-	return &Connection{
-		detail: &transport.ConnectionDetail{
-			Address: Type + ":" + destination,
-			InBound: false,
-			Name:    name,
-		},
-		Conn: socket,
-	}, nil
+	ctx, cancelF := transport.TimeoutContext(timeout)
+	defer cancelF()
+	return DialContext(ctx, destination, name, nil, nil)
 }
 
 func DialWithLocalBinding(destination, name, localBinding string, timeout time.Duration) (transport.Conn, error) {
+	ctx, cancelF := transport.TimeoutContext(timeout)
+	defer cancelF()
+	return DialWithLocalBindingContext(ctx, destination, name, localBinding, nil, nil)
+}
 
-	dialer, err := transport.NewDialerWithLocalBinding(Type, timeout, localBinding)
+func DialContext(ctx context.Context, destination, name string, proxyConf *transport.ProxyConfiguration, socketOptions *transport.SocketOptions) (transport.Conn, error) {
+	return DialWithLocalBindingContext(ctx, destination, name, "", proxyConf, socketOptions)
+}
 
+// DialWithLocalBindingContext connects to the destination, optionally through a proxy. The socket options are
+// applied to the connection to the destination or proxy. If they are nil, the go defaults are kept
+func DialWithLocalBindingContext(ctx context.Context, destination, name, localBinding string, proxyConf *transport.ProxyConfiguration, socketOptions *transport.SocketOptions) (transport.Conn, error) {
+	binding, err := transport.ParseLocalBinding(localBinding)
 	if err != nil {
 		return nil, err
 	}
 
-	socket, err := dialer.Dial("tcp", destination)
+	dialer := transport.NewHappyEyeballsDialer(&net.Dialer{})
+	dialer.LocalBinding = binding
+	dialer.SocketOptions = socketOptions
+
+	contextDialer, err := proxies.NewContextDialer(proxyConf, dialer)
+	if err != nil {
+		return nil, err
+	}
 
+	socket, err := contextDialer.DialContext(ctx, "tcp", destination)
 	if err != nil {
 		return nil, err
 	}
 
 	return &Connection{
 		detail: &transport.ConnectionDetail{
-			Address: Type + ":" + destination,
-			InBound: false,
-			Name:    name,
+			Address:         Type + ":" + destination,
+			InBound:         false,
+			Name:            name,
+			ResolvedAddress: socket.RemoteAddr().String(),
+			MultipathTCP:    transport.MultipathTCPActive(socket),
 		},
 		Conn: socket,
 	}, nil
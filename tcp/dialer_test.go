@@ -0,0 +1,230 @@
+package tcp
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/openziti/transport/v2"
+	"github.com/openziti/transport/v2/internal/testutil"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDialContext(t *testing.T) {
+	req := require.New(t)
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	req.NoError(err)
+	defer func() { _ = listener.Close() }()
+
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			_ = conn.Close()
+		}
+	}()
+
+	conn, err := DialContext(context.Background(), listener.Addr().String(), "test", nil, nil)
+	req.NoError(err)
+	req.Equal("tcp:"+listener.Addr().String(), conn.Detail().Address)
+	req.Equal(listener.Addr().String(), conn.Detail().ResolvedAddress)
+	req.NoError(conn.Close())
+
+	ctx, cancelF := context.WithCancel(context.Background())
+	cancelF()
+
+	_, err = DialContext(ctx, listener.Addr().String(), "test", nil, nil)
+	req.ErrorIs(err, context.Canceled)
+}
+
+func mptcpEnabled() bool {
+	if runtime.GOOS != "linux" {
+		return false
+	}
+	val, err := os.ReadFile("/proc/sys/net/mptcp/enabled")
+	return err == nil && strings.TrimSpace(string(val)) == "1"
+}
+
+func TestDialMultipathTCP(t *testing.T) {
+	req := require.New(t)
+
+	tcfg := transport.Configuration{
+		transport.KeySocket: map[interface{}]interface{}{
+			"multipathTcp": true,
+		},
+	}
+
+	accepted := make(chan transport.Conn, 1)
+	closer, err := ListenWithConfig("127.0.0.1:0", "test", func(conn transport.Conn) {
+		accepted <- conn
+	}, tcfg)
+	req.NoError(err)
+	defer func() { _ = closer.Close() }()
+
+	socketOptions, err := tcfg.GetSocketOptions()
+	req.NoError(err)
+
+	conn, err := DialContext(context.Background(), strings.TrimPrefix(closer.Addr(), Type+":"), "test", nil, socketOptions)
+	req.NoError(err)
+	defer func() { _ = conn.Close() }()
+
+	var serverConn transport.Conn
+	select {
+	case serverConn = <-accepted:
+	case <-time.After(5 * time.Second):
+		req.Fail("timed out waiting for connection")
+	}
+	defer func() { _ = serverConn.Close() }()
+
+	// without kernel support, both ends fall back to plain tcp
+	req.Equal(mptcpEnabled(), conn.Detail().MultipathTCP)
+	req.Equal(mptcpEnabled(), serverConn.Detail().MultipathTCP)
+
+	plain, err := DialContext(context.Background(), strings.TrimPrefix(closer.Addr(), Type+":"), "test", nil, nil)
+	req.NoError(err)
+	defer func() { _ = plain.Close() }()
+	req.False(plain.Detail().MultipathTCP)
+}
+
+func TestListenReportsBoundAddress(t *testing.T) {
+	req := require.New(t)
+
+	bindAddr, err := AddressParser{}.Parse("tcp:127.0.0.1:0")
+	req.NoError(err)
+
+	accepted := make(chan transport.Conn, 1)
+	listener, err := bindAddr.Listen("test", nil, func(conn transport.Conn) {
+		accepted <- conn
+	}, nil)
+	req.NoError(err)
+	defer func() { _ = listener.Close() }()
+
+	addr, err := AddressParser{}.Parse(listener.Addr())
+	req.NoError(err)
+	req.NotZero(addr.(*address).port)
+
+	conn, err := addr.Dial("test", nil, time.Second, nil)
+	req.NoError(err)
+	defer func() { _ = conn.Close() }()
+
+	select {
+	case inbound := <-accepted:
+		_ = inbound.Close()
+	case <-time.After(5 * time.Second):
+		req.Fail("connection not accepted")
+	}
+
+	req.Equal(transport.ListenerStats{Accepted: 1}, listener.Stats())
+
+	ctx, cancelF := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancelF()
+	req.NoError(listener.Shutdown(ctx))
+
+	_, err = addr.Dial("test", nil, time.Second, nil)
+	req.Error(err)
+}
+
+func TestListenInherited(t *testing.T) {
+	req := require.New(t)
+
+	sock, err := net.Listen("tcp", "127.0.0.1:0")
+	req.NoError(err)
+	defer func() { _ = sock.Close() }()
+
+	fd := testutil.InheritableFd(t, sock.(*net.TCPListener))
+
+	bindAddr, err := AddressParser{}.Parse(fmt.Sprintf("tcp:fd:%d", fd))
+	req.NoError(err)
+
+	_, err = bindAddr.Dial("test", nil, time.Second, nil)
+	req.Error(err)
+
+	accepted := make(chan transport.Conn, 1)
+	listener, err := bindAddr.Listen("test", nil, func(conn transport.Conn) {
+		accepted <- conn
+	}, nil)
+	req.NoError(err)
+	defer func() { _ = listener.Close() }()
+
+	req.Equal(Type+":"+sock.Addr().String(), listener.Addr())
+
+	addr, err := AddressParser{}.Parse(listener.Addr())
+	req.NoError(err)
+
+	conn, err := addr.Dial("test", nil, time.Second, nil)
+	req.NoError(err)
+	defer func() { _ = conn.Close() }()
+
+	select {
+	case inbound := <-accepted:
+		_ = inbound.Close()
+	case <-time.After(5 * time.Second):
+		req.Fail("connection not accepted")
+	}
+
+	// once both the original socket and the listener are closed, nothing is left listening
+	req.NoError(sock.Close())
+	req.NoError(listener.Close())
+
+	_, err = net.DialTimeout("tcp", sock.Addr().String(), time.Second)
+	req.Error(err, "inherited socket should be closed with the last listener")
+
+	_, err = bindAddr.Listen("test", nil, func(transport.Conn) {}, nil)
+	req.ErrorContains(err, "was closed when its last listener was closed")
+}
+
+func TestListenWithProxyProtocol(t *testing.T) {
+	req := require.New(t)
+
+	bindAddr, err := AddressParser{}.Parse("tcp:127.0.0.1:0")
+	req.NoError(err)
+
+	tcfg := transport.Configuration{
+		transport.KeyProxyProtocol: map[interface{}]interface{}{
+			"trustedSources": []interface{}{"127.0.0.1"},
+		},
+	}
+
+	accepted := make(chan transport.Conn, 1)
+	listener, err := bindAddr.Listen("test", nil, func(conn transport.Conn) {
+		accepted <- conn
+	}, tcfg)
+	req.NoError(err)
+	defer func() { _ = listener.Close() }()
+
+	// a client which doesn't send its header mustn't hold up other connections
+	stalled, err := net.Dial("tcp", strings.TrimPrefix(listener.Addr(), Type+":"))
+	req.NoError(err)
+	defer func() { _ = stalled.Close() }()
+
+	conn, err := net.Dial("tcp", strings.TrimPrefix(listener.Addr(), Type+":"))
+	req.NoError(err)
+	defer func() { _ = conn.Close() }()
+
+	_, err = conn.Write([]byte("PROXY TCP4 203.0.113.7 10.0.0.1 4000 443\r\nhello"))
+	req.NoError(err)
+
+	select {
+	case inbound := <-accepted:
+		defer func() { _ = inbound.Close() }()
+		req.Equal("tcp:203.0.113.7:4000", inbound.Detail().Address)
+		req.Equal(conn.LocalAddr().String(), inbound.Detail().ProxiedBy)
+		req.Equal("203.0.113.7:4000", inbound.RemoteAddr().String())
+
+		buf := make([]byte, 5)
+		_, err = io.ReadFull(inbound, buf)
+		req.NoError(err)
+		req.Equal("hello", string(buf))
+	case <-time.After(5 * time.Second):
+		req.Fail("connection not accepted")
+	}
+}
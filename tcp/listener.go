@@ -17,47 +17,156 @@
 package tcp
 
 import (
-	"io"
+	"context"
 	"net"
+	"sync"
+	"sync/atomic"
 
 	"github.com/michaelquigley/pfxlog"
 	"github.com/openziti/transport/v2"
+	"github.com/pkg/errors"
 	"github.com/sirupsen/logrus"
 )
 
-func Listen(bindAddress, name string, acceptF func(transport.Conn)) (io.Closer, error) {
+func Listen(bindAddress, name string, acceptF func(transport.Conn)) (transport.Listener, error) {
+	return ListenWithConfig(bindAddress, name, acceptF, nil)
+}
+
+// ListenWithConfig is like Listen, but applies the socket options from the transport configuration to the listening
+// sockets and to each accepted connection. If the options ask for several listener sockets, each gets its own
+// accept loop. If the PROXY protocol is configured, connections from trusted sources are reported with the client
+// address from their header
+func ListenWithConfig(bindAddress, name string, acceptF func(transport.Conn), tcfg transport.Configuration) (transport.Listener, error) {
 	log := pfxlog.ContextLogger(name + "/" + Type + ":" + bindAddress)
 
-	listener, err := net.Listen("tcp", bindAddress)
+	socketOptions, err := tcfg.GetSocketOptions()
+	if err != nil {
+		return nil, errors.Wrapf(err, "unable to get socket options")
+	}
+
+	proxyProtocol, err := tcfg.GetProxyProtocolConfig()
+	if err != nil {
+		return nil, errors.Wrapf(err, "unable to get proxy protocol configuration")
+	}
+
+	sockets, err := socketOptions.Listen(context.Background(), "tcp", bindAddress)
 	if err != nil {
 		return nil, err
 	}
 
-	go acceptLoop(log.Entry, name, listener, acceptF)
+	result := &listener{
+		name:          name,
+		sockets:       sockets,
+		socketOptions: socketOptions,
+		proxyProtocol: proxyProtocol,
+		acceptF:       acceptF,
+	}
 
-	return listener, nil
+	result.acceptLoops.Add(len(sockets))
+	for _, socket := range sockets {
+		go result.acceptLoop(log.Entry, socket)
+	}
+
+	return result, nil
+}
+
+// listener holds the sockets listening on a bind address
+type listener struct {
+	transport.ListenerCounters
+	name          string
+	sockets       []net.Listener
+	socketOptions *transport.SocketOptions
+	proxyProtocol *transport.ProxyProtocolConfig
+	acceptF       func(transport.Conn)
+	acceptLoops   sync.WaitGroup
+	closed        atomic.Bool
+
+	// headers tracks connections whose PROXY protocol header is being read, which happens off the accept loops
+	headers sync.WaitGroup
+}
+
+func (self *listener) Addr() string {
+	return Type + ":" + self.sockets[0].Addr().String()
+}
+
+func (self *listener) Close() error {
+	if self.closed.CompareAndSwap(false, true) {
+		return transport.CloseListeners(self.sockets)
+	}
+	return nil
 }
 
-func acceptLoop(log *logrus.Entry, name string, listener net.Listener, acceptF func(transport.Conn)) {
+// Shutdown closes the listening sockets and waits for the accept loops to exit, along with any connections whose
+// PROXY protocol header is still being read
+func (self *listener) Shutdown(ctx context.Context) error {
+	if err := self.Close(); err != nil {
+		return err
+	}
+	if err := transport.WaitForShutdown(ctx, &self.acceptLoops); err != nil {
+		return err
+	}
+	return transport.WaitForShutdown(ctx, &self.headers)
+}
+
+func (self *listener) acceptLoop(log *logrus.Entry, sock net.Listener) {
+	defer self.acceptLoops.Done()
 	defer log.Error("exited")
 
 	for {
-		socket, err := listener.Accept()
+		socket, err := sock.Accept()
 		if err != nil {
+			if self.closed.Load() {
+				log.WithError(err).Info("listener closed, exiting")
+				return
+			}
 			log.WithField("err", err).Error("accept failed. failure not recoverable. exiting listen loop")
 			return
-		} else {
-			connection := &Connection{
-				detail: &transport.ConnectionDetail{
-					Address: Type + ":" + socket.RemoteAddr().String(),
-					InBound: true,
-					Name:    name,
-				},
-				Conn: socket,
-			}
-			acceptF(connection)
+		}
+
+		if self.proxyProtocol == nil {
+			self.accept(log, socket)
+			continue
+		}
 
-			log.WithField("addr", socket.RemoteAddr().String()).Info("accepted connection")
+		// waiting for the header mustn't hold up the accept loop
+		socket = self.proxyProtocol.Conn(socket)
+		self.headers.Add(1)
+		go func() {
+			defer self.headers.Done()
+			self.accept(log, socket)
+		}()
+	}
+}
+
+func (self *listener) accept(log *logrus.Entry, socket net.Conn) {
+	if proxyConn, ok := socket.(*transport.ProxyProtocolConn); ok {
+		if _, err := proxyConn.Header(); err != nil {
+			log.WithError(err).WithField("addr", proxyConn.NetConn().RemoteAddr().String()).Error("invalid proxy protocol header, closing connection")
+			_ = socket.Close()
+			self.IncrementFailed()
+			return
 		}
 	}
+
+	if err := self.socketOptions.Apply(socket); err != nil {
+		log.WithError(err).WithField("addr", socket.RemoteAddr().String()).Error("unable to set socket options, closing connection")
+		_ = socket.Close()
+		self.IncrementFailed()
+		return
+	}
+
+	connection := &Connection{
+		detail: &transport.ConnectionDetail{
+			Address:      Type + ":" + socket.RemoteAddr().String(),
+			InBound:      true,
+			Name:         self.name,
+			MultipathTCP: transport.MultipathTCPActive(socket),
+			ProxiedBy:    transport.ProxiedBy(socket),
+		},
+		Conn: socket,
+	}
+	self.IncrementAccepted()
+	self.acceptF(connection)
+
+	log.WithField("addr", socket.RemoteAddr().String()).Info("accepted connection")
 }
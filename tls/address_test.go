@@ -17,6 +17,8 @@ func TestParseAndString(t *testing.T) {
 		{"ipv4 ip", "tls:127.0.0.1:8080", "tls:127.0.0.1:8080", false},
 		{"ipv6 loopback", "tls:[::1]:8080", "tls:[::1]:8080", false},
 		{"ipv6 full", "tls:[fe80::1]:443", "tls:[fe80::1]:443", false},
+		{"inherited fd", "tls:fd:3", "tls:fd:3", false},
+		{"inherited name", "tls:fd:router", "tls:fd:router", false},
 		{"wrong prefix", "tcp:localhost:8080", "", true},
 	}
 
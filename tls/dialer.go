@@ -17,14 +17,15 @@
 package tls
 
 import (
+	"context"
 	"crypto/tls"
+	"net"
 	"time"
 
 	"github.com/michaelquigley/pfxlog"
 	"github.com/openziti/identity"
 	"github.com/openziti/transport/v2"
 	"github.com/openziti/transport/v2/proxies"
-	"github.com/pkg/errors"
 )
 
 func Dial(a address, name string, i *identity.TokenId, timeout time.Duration, proxyConf *transport.ProxyConfiguration, protocols ...string) (transport.Conn, error) {
@@ -32,12 +33,29 @@ func Dial(a address, name string, i *identity.TokenId, timeout time.Duration, pr
 }
 
 func DialWithLocalBinding(a address, name, localBinding string, i *identity.TokenId, timeout time.Duration, proxyConf *transport.ProxyConfiguration, protocols ...string) (transport.Conn, error) {
+	ctx, cancelF := transport.TimeoutContext(timeout)
+	defer cancelF()
+	return DialWithLocalBindingContext(ctx, a, name, localBinding, i, proxyConf, nil, protocols...)
+}
+
+func DialContext(ctx context.Context, a address, name string, i *identity.TokenId, proxyConf *transport.ProxyConfiguration, socketOptions *transport.SocketOptions, protocols ...string) (transport.Conn, error) {
+	return DialWithLocalBindingContext(ctx, a, name, "", i, proxyConf, socketOptions, protocols...)
+}
+
+// DialWithLocalBindingContext connects to the address, optionally through a proxy, and performs the tls handshake.
+// The socket options are applied to the tcp connection to the destination or proxy. If they are nil, the go
+// defaults are kept
+func DialWithLocalBindingContext(ctx context.Context, a address, name, localBinding string, i *identity.TokenId, proxyConf *transport.ProxyConfiguration, socketOptions *transport.SocketOptions, protocols ...string) (transport.Conn, error) {
 	destination := a.bindableAddress()
-	dialer, err := transport.NewDialerWithLocalBinding("tcp", timeout, localBinding)
+	binding, err := transport.ParseLocalBinding(localBinding)
 	if err != nil {
 		return nil, err
 	}
 
+	dialer := transport.NewHappyEyeballsDialer(&net.Dialer{})
+	dialer.LocalBinding = binding
+	dialer.SocketOptions = socketOptions
+
 	log := pfxlog.Logger().WithField("dest", destination)
 
 	tlsCfg := i.ClientTLSConfig()
@@ -47,35 +65,31 @@ func DialWithLocalBinding(a address, name, localBinding string, i *identity.Toke
 		tlsCfg.NextProtos = append(tlsCfg.NextProtos, protocols...)
 	}
 
-	var tlsConn *tls.Conn
-
-	if proxyConf != nil && proxyConf.Type != transport.ProxyTypeNone {
-		if proxyConf.Type == transport.ProxyTypeHttpConnect {
-			log.Infof("using http connect proxy at %s", proxyConf.Address)
-			proxyDialer := proxies.NewHttpConnectProxyDialer(dialer, proxyConf.Address, proxyConf.Auth, timeout)
-			conn, err := proxyDialer.Dial("tcp", destination)
-			if err != nil {
-				return nil, err
-			}
-
-			tlsConn = tls.Client(conn, tlsCfg)
-		} else {
-			return nil, errors.Errorf("unsupported proxy type %s", string(proxyConf.Type))
-		}
-	} else {
-		tlsConn, err = tls.DialWithDialer(dialer, "tcp", destination, tlsCfg)
-		if err != nil {
-			return nil, err
-		}
+	contextDialer, err := proxies.NewContextDialer(proxyConf, dialer)
+	if err != nil {
+		return nil, err
+	}
+
+	conn, err := contextDialer.DialContext(ctx, "tcp", destination)
+	if err != nil {
+		return nil, err
+	}
+
+	tlsConn := tls.Client(conn, tlsCfg)
+	if err = tlsConn.HandshakeContext(ctx); err != nil {
+		_ = conn.Close()
+		return nil, err
 	}
 
 	log.Debugf("server provided [%d] certificates", len(tlsConn.ConnectionState().PeerCertificates))
 
 	return &Connection{
 		detail: &transport.ConnectionDetail{
-			Address: Type + ":" + destination,
-			InBound: false,
-			Name:    name,
+			Address:         Type + ":" + destination,
+			InBound:         false,
+			Name:            name,
+			ResolvedAddress: conn.RemoteAddr().String(),
+			MultipathTCP:    transport.MultipathTCPActive(conn),
 		},
 		Conn: tlsConn,
 	}, nil
@@ -22,6 +22,7 @@ import (
 	"fmt"
 	"io"
 	"net"
+	"slices"
 	"sync"
 	"sync/atomic"
 	"time"
@@ -31,23 +32,28 @@ import (
 	"github.com/openziti/foundation/v2/rate"
 	"github.com/openziti/identity"
 	"github.com/openziti/transport/v2"
+	transportunix "github.com/openziti/transport/v2/unix"
+	"github.com/pkg/errors"
 	"github.com/sirupsen/logrus"
 )
 
 const (
-	// same as golang Dial default
-	keepAlive               = 15 * time.Second
 	handshakeTimeoutDefault = 5 * time.Second
+
+	// UnixType is the address type of tls connections carried over unix domain sockets
+	UnixType = "tlsunix"
 )
 
 var noProtocol = ""
 
-type handlerKeyType struct{}
+type handshakeKeyType struct{}
 
-var handlerKey = handlerKeyType{}
+var handshakeKey = handshakeKeyType{}
 
 var handshakeTimeout concurrenz.AtomicValue[time.Duration]
 
+// SetSharedListenerHandshakeTimeout sets the default handshake timeout, used for handlers which don't configure their
+// own and until the client hello has selected a handler
 func SetSharedListenerHandshakeTimeout(timeout time.Duration) {
 
 	handshakeTimeout.Store(timeout)
@@ -59,6 +65,8 @@ func GetSharedListenerHandshakeTimeout() time.Duration {
 
 var rateLimiterAtomic concurrenz.AtomicValue[*rate.AdaptiveRateLimitTracker]
 
+// SetSharedListenerRateLimiter sets the default handshake rate limiter. It covers each handshake until the client hello
+// has selected a handler, and the rest of the handshake for handlers which don't configure their own
 func SetSharedListenerRateLimiter(limiter rate.AdaptiveRateLimitTracker) {
 	rateLimiterAtomic.Store(&limiter)
 }
@@ -70,20 +78,135 @@ func init() {
 	handshakeTimeout.Store(handshakeTimeoutDefault)
 }
 
-func Listen(bindAddress, name string, i *identity.TokenId, acceptF func(transport.Conn), protocols ...string) (io.Closer, error) {
-	log := pfxlog.ContextLogger(name + "/" + Type + ":" + bindAddress).Entry
+func Listen(bindAddress, name string, i *identity.TokenId, acceptF func(transport.Conn), protocols ...string) (transport.Listener, error) {
+	return listen("tcp", bindAddress, name, i, acceptF, nil, nil, protocols...)
+}
+
+// ListenWithConfig is like Listen, but takes the protocols, socket options and PROXY protocol configuration from the
+// transport configuration. The socket options and PROXY protocol configuration of the handler which first registers
+// a bind address apply to the shared socket and to all connections accepted on it.
+//
+// If server names are configured, the handler is only selected for connections requesting one of them (SNI). A more
+// specific server name takes precedence: an exact name over a *. wildcard, and a wildcard over handlers registered for
+// any server name. Registration fails if another handler already has the same server name pattern and protocol.
+//
+// A handler configured as the fallback is selected for connections which no other handler accepts, such as those
+// offering only unknown protocols. No protocol is negotiated for those connections. If a protocol preference is
+// configured, the shared listener chooses among the protocols a client offers in that order, otherwise the last of the
+// client's protocols with a handler is chosen.
+//
+// The tls handshake timeout and rate limiter configured for the handler apply to the handshakes which select it, in
+// place of the shared listener defaults
+func ListenWithConfig(bindAddress, name string, i *identity.TokenId, acceptF func(transport.Conn), tcfg transport.Configuration) (transport.Listener, error) {
+	cfg, err := loadSharedListenerConfig(tcfg)
+	if err != nil {
+		return nil, err
+	}
+	handlerCfg, err := loadHandlerConfig(tcfg)
+	if err != nil {
+		return nil, err
+	}
+	return listen("tcp", bindAddress, name, i, acceptF, cfg, handlerCfg, tcfg.Protocols()...)
+}
+
+// sharedListenerConfig holds the settings which apply to a shared listener as a whole, rather than to one of its
+// handlers. They're taken from the handler which first registers the bind address
+type sharedListenerConfig struct {
+	socketOptions      *transport.SocketOptions
+	proxyProtocol      *transport.ProxyProtocolConfig
+	protocolPreference []string
+}
+
+func loadSharedListenerConfig(tcfg transport.Configuration) (*sharedListenerConfig, error) {
+	socketOptions, err := tcfg.GetSocketOptions()
+	if err != nil {
+		return nil, errors.Wrapf(err, "unable to get socket options")
+	}
+
+	proxyProtocol, err := tcfg.GetProxyProtocolConfig()
+	if err != nil {
+		return nil, errors.Wrapf(err, "unable to get proxy protocol configuration")
+	}
+
+	protocolPreference, err := tcfg.ProtocolPreference()
+	if err != nil {
+		return nil, err
+	}
+
+	return &sharedListenerConfig{
+		socketOptions:      socketOptions,
+		proxyProtocol:      proxyProtocol,
+		protocolPreference: protocolPreference,
+	}, nil
+}
+
+// handlerConfig holds the settings which apply to one handler of a shared listener
+type handlerConfig struct {
+	// serverNames are the server name patterns the handler is selected for, in addition to its ALPN protocols. If
+	// there are none, the handler is selected for any server name
+	serverNames []string
+
+	// fallback marks the handler as the one selected for its server names when no other handler accepts a connection
+	fallback bool
+
+	// handshakeTimeout and rateLimiter override the shared listener defaults, if set
+	handshakeTimeout time.Duration
+	rateLimiter      rate.AdaptiveRateLimitTracker
+}
+
+func loadHandlerConfig(tcfg transport.Configuration) (*handlerConfig, error) {
+	serverNames, err := tcfg.ServerNames()
+	if err != nil {
+		return nil, err
+	}
+
+	fallback, err := tcfg.IsFallback()
+	if err != nil {
+		return nil, err
+	}
+
+	timeout, err := tcfg.GetTLSHandshakeTimeout()
+	if err != nil {
+		return nil, err
+	}
+
+	var rateLimiter rate.AdaptiveRateLimitTracker
+	if val, found := tcfg[transport.KeyHandshakeRateLimiter]; found {
+		var ok bool
+		if rateLimiter, ok = val.(rate.AdaptiveRateLimitTracker); !ok {
+			return nil, errors.Errorf("invalid value for %s of type '%T', must be rate.AdaptiveRateLimitTracker", transport.KeyHandshakeRateLimiter, val)
+		}
+	}
+
+	return &handlerConfig{
+		serverNames:      serverNames,
+		fallback:         fallback,
+		handshakeTimeout: timeout,
+		rateLimiter:      rateLimiter,
+	}, nil
+}
+
+// ListenUnix is like Listen, but accepts tls connections on the unix domain socket at the given path. As with tcp
+// bind addresses, multiple handlers may share the same socket path, selected by ALPN protocol. A stale socket file
+// left at the path by a previous process is removed.
+func ListenUnix(path, name string, i *identity.TokenId, acceptF func(transport.Conn), protocols ...string) (transport.Listener, error) {
+	return listen("unix", path, name, i, acceptF, nil, nil, protocols...)
+}
+
+func listen(network, bindAddress, name string, i *identity.TokenId, acceptF func(transport.Conn), cfg *sharedListenerConfig, handlerCfg *handlerConfig, protocols ...string) (transport.Listener, error) {
+	log := pfxlog.ContextLogger(name + "/" + addressType(network) + ":" + bindAddress).Entry
 
 	config := i.ServerTLSConfig().Clone()
 	if len(protocols) > 0 {
 		config.NextProtos = append(config.NextProtos, protocols...)
 	}
-	result := &protocolHandler{
-		name:    name,
-		tls:     config,
-		acceptF: acceptF,
+
+	result, err := newProtocolHandler(name, config, acceptF, handlerCfg)
+	if err != nil {
+		return nil, err
 	}
 
-	err := registerWithSharedListener(bindAddress, result)
+	err = registerWithSharedListener(network, bindAddress, result, cfg)
 	if err != nil {
 		log.WithError(err).Error("failed to register with shared listener")
 		return nil, err
@@ -96,49 +219,81 @@ type tlsListener struct {
 	connCh  chan *Connection
 	handler *protocolHandler
 	closed  atomic.Bool
+	done    chan struct{}
 }
 
 func (self *tlsListener) Accept() (net.Conn, error) {
-	conn := <-self.connCh
-	if conn == nil {
+	select {
+	case conn := <-self.connCh:
+		return conn.Conn, nil
+	case <-self.done:
 		return nil, net.ErrClosed
 	}
-	return conn.Conn, nil
 }
 
 func (self *tlsListener) Close() error {
 	var err error
 	if self.closed.CompareAndSwap(false, true) {
 		err = self.handler.Close()
-		close(self.connCh)
+		close(self.done)
 	}
 	return err
 }
 
+// Shutdown stops routing new connections to the listener and waits until handshakes which were already routed to
+// it have been queued for Accept. The listener stays open, so that the queued connections can still be accepted
+// before it is closed
+func (self *tlsListener) Shutdown(ctx context.Context) error {
+	return self.handler.Shutdown(ctx)
+}
+
 func (self *tlsListener) Addr() net.Addr {
-	return self.handler.listener.sock.Addr()
+	return self.handler.listener.socks[0].Addr()
 }
 
 func (self *tlsListener) tlsAccept(conn transport.Conn) {
 	c := conn.(*Connection)
-	self.connCh <- c
+	select {
+	case self.connCh <- c:
+	case <-self.done:
+		_ = c.Close()
+	}
 }
 
 // ListenTLS returns net.Listener that is attached to shared listener with protocols (ALPN)
 // specified by config.NextProtos
 // It can be used in http.Server or other standard components
 func ListenTLS(bindAddress, name string, config *tls.Config) (net.Listener, error) {
+	return listenTLS(bindAddress, name, config, nil, nil)
+}
+
+// ListenTLSWithConfig is like ListenTLS, but takes the handler and shared listener settings from the transport
+// configuration, as with ListenWithConfig
+func ListenTLSWithConfig(bindAddress, name string, config *tls.Config, tcfg transport.Configuration) (net.Listener, error) {
+	cfg, err := loadSharedListenerConfig(tcfg)
+	if err != nil {
+		return nil, err
+	}
+	handlerCfg, err := loadHandlerConfig(tcfg)
+	if err != nil {
+		return nil, err
+	}
+	return listenTLS(bindAddress, name, config, cfg, handlerCfg)
+}
+
+func listenTLS(bindAddress, name string, config *tls.Config, cfg *sharedListenerConfig, handlerCfg *handlerConfig) (net.Listener, error) {
 	log := pfxlog.ContextLogger(name + "/" + Type + ":" + bindAddress).Entry
 
-	l := &tlsListener{}
+	l := &tlsListener{
+		done: make(chan struct{}),
+	}
 
-	handler := &protocolHandler{
-		name:    name,
-		tls:     config,
-		acceptF: l.tlsAccept,
+	handler, err := newProtocolHandler(name, config, l.tlsAccept, handlerCfg)
+	if err != nil {
+		return nil, err
 	}
 
-	err := registerWithSharedListener(bindAddress, handler)
+	err = registerWithSharedListener("tcp", bindAddress, handler, cfg)
 	if err != nil {
 		log.WithError(err).Error("failed to register with shared listener")
 		return nil, err
@@ -150,90 +305,272 @@ func ListenTLS(bindAddress, name string, config *tls.Config) (net.Listener, erro
 	return l, nil
 }
 
+func newProtocolHandler(name string, config *tls.Config, acceptF func(transport.Conn), handlerCfg *handlerConfig) (*protocolHandler, error) {
+	result := &protocolHandler{
+		name:    name,
+		tls:     config,
+		acceptF: acceptF,
+	}
+
+	if handlerCfg != nil {
+		result.fallback = handlerCfg.fallback
+		result.handshakeTimeout = handlerCfg.handshakeTimeout
+		result.rateLimiter = handlerCfg.rateLimiter
+		for _, pattern := range handlerCfg.serverNames {
+			serverName, err := normalizeServerNamePattern(pattern)
+			if err != nil {
+				return nil, err
+			}
+			result.serverNames = append(result.serverNames, serverName)
+		}
+	}
+
+	return result, nil
+}
+
 type protocolHandler struct {
-	name     string
-	listener *sharedListener
-	tls      *tls.Config
-	acceptF  func(conn transport.Conn)
-	closed   atomic.Bool
+	transport.ListenerCounters
+	name        string
+	listener    *sharedListener
+	tls         *tls.Config
+	serverNames []string
+	fallback    bool
+	acceptF     func(conn transport.Conn)
+	closed      atomic.Bool
+
+	// handshakeTimeout and rateLimiter apply to the handshakes which select this handler. If unset, the shared
+	// listener defaults are used
+	handshakeTimeout time.Duration
+	rateLimiter      rate.AdaptiveRateLimitTracker
+
+	// handshakes tracks the handshakes which selected this handler and haven't yet been passed to acceptF or failed
+	handshakes sync.WaitGroup
+}
+
+// routes returns every server name pattern and ALPN protocol pair the handler is selected for. A fallback handler
+// without protocols of its own has none, as it is only selected when no other handler is
+func (self *protocolHandler) routes() []route {
+	protos := self.tls.NextProtos
+	if len(protos) == 0 {
+		if self.fallback {
+			return nil
+		}
+		protos = []string{noProtocol}
+	}
+
+	var result []route
+	for _, serverName := range self.serverNamePatterns() {
+		for _, proto := range protos {
+			result = append(result, route{serverName: serverName, proto: proto})
+		}
+	}
+	return result
+}
+
+// serverNamePatterns returns the server name patterns the handler is selected for, where empty matches any server name
+func (self *protocolHandler) serverNamePatterns() []string {
+	if len(self.serverNames) == 0 {
+		return []string{""}
+	}
+	return self.serverNames
+}
+
+// Addr returns the address of the shared listener which the handler is registered with
+func (self *protocolHandler) Addr() string {
+	return addressType(self.listener.network) + ":" + self.listener.socks[0].Addr().String()
 }
 
 func (self *protocolHandler) Close() error {
 	if self.closed.CompareAndSwap(false, true) {
 		self.listener.remove(self)
-		return nil
+		if self.listener.ctx.Err() != nil {
+			self.listener.cancelHandshakes()
+		}
+	}
+	return nil
+}
+
+// Shutdown removes the handler from the shared listener, then waits for handshakes which had already selected the
+// handler to complete. If it was the last handler, the shared listener stops, and Shutdown also waits for its accept
+// loops and any other handshakes which were still running
+func (self *protocolHandler) Shutdown(ctx context.Context) error {
+	if self.closed.CompareAndSwap(false, true) {
+		self.listener.remove(self)
+	}
+
+	if err := transport.WaitForShutdown(ctx, &self.handshakes); err != nil {
+		return err
 	}
+
+	if self.listener.ctx.Err() != nil {
+		// no handler is left to select, so any remaining handshakes can't succeed
+		self.listener.cancelHandshakes()
+		return transport.WaitForShutdown(ctx, &self.listener.active)
+	}
+
 	return nil
 }
 
 var sharedListeners sync.Map
 
-func registerWithSharedListener(bindAddress string, acc *protocolHandler) error {
+// sharedListenerKey returns the key under which the shared listener for the given bind address is registered. tcp
+// listeners are keyed by their bind address alone, other networks are prefixed with the address type
+func sharedListenerKey(network, bindAddress string) string {
+	if network == "tcp" {
+		return bindAddress
+	}
+	return addressType(network) + ":" + bindAddress
+}
+
+func addressType(network string) string {
+	if network == "unix" {
+		return UnixType
+	}
+	return Type
+}
+
+// registerWithSharedListener adds the handler to the shared listener for the bind address, creating it if needed. The
+// configuration given when the shared listener is created applies to all of its connections. If it is nil, the
+// default socket options are used, without the PROXY protocol. Later registrations can't change it
+func registerWithSharedListener(network, bindAddress string, acc *protocolHandler, cfg *sharedListenerConfig) error {
+	key := sharedListenerKey(network, bindAddress)
 	sl := &sharedListener{
-		address:  bindAddress,
-		handlers: make(map[string]*protocolHandler),
+		key:           key,
+		network:       network,
+		address:       bindAddress,
+		handlers:      make(map[route]*protocolHandler),
+		fallbacks:     make(map[string]*protocolHandler),
+		socketOptions: transport.DefaultSocketOptions(),
 	}
-	el, found := sharedListeners.LoadOrStore(bindAddress, sl)
+	if cfg != nil {
+		sl.socketOptions = cfg.socketOptions
+		sl.proxyProtocol = cfg.proxyProtocol
+		sl.protocolPreference = cfg.protocolPreference
+	}
+	el, found := sharedListeners.LoadOrStore(key, sl)
 	sl = el.(*sharedListener)
 
+	if found && cfg != nil {
+		if *cfg.socketOptions != *sl.socketOptions {
+			pfxlog.ContextLogger(key).Warnf("socket options for handler %s differ from those of the shared listener, which were set by the first handler", acc.name)
+		}
+		if !cfg.proxyProtocol.Equal(sl.proxyProtocol) {
+			pfxlog.ContextLogger(key).Warnf("proxy protocol configuration for handler %s differs from that of the shared listener, which was set by the first handler", acc.name)
+		}
+		if !slices.Equal(cfg.protocolPreference, sl.protocolPreference) {
+			pfxlog.ContextLogger(key).Warnf("protocol preference for handler %s differs from that of the shared listener, which was set by the first handler", acc.name)
+		}
+	}
+
 	if !found {
-		sl.log = pfxlog.ContextLogger(Type + ":" + bindAddress).Entry
+		sl.log = pfxlog.ContextLogger(key).Entry
 
 		sl.tlsCfg = &tls.Config{
 			GetConfigForClient: sl.getConfig,
 		}
 
 		sl.ctx, sl.done = context.WithCancel(context.Background())
-		sock, err := tls.Listen("tcp", bindAddress, sl.tlsCfg)
+		sl.handshakeCtx, sl.cancelHandshakes = context.WithCancel(context.Background())
+
+		if network == "unix" && !transport.IsInheritedAddress(bindAddress) {
+			if err := transportunix.RemoveStaleSocket(bindAddress); err != nil {
+				sharedListeners.Delete(key)
+				return err
+			}
+		}
+
+		socks, err := sl.socketOptions.Listen(context.Background(), network, bindAddress)
 		if err != nil {
-			sharedListeners.Delete(bindAddress)
+			sharedListeners.Delete(key)
 			return err
 		}
-		sl.sock = sock
-		go sl.runAccept()
-	}
 
-	protos := acc.tls.NextProtos
-	if protos == nil {
-		protos = append(protos, "")
+		for _, sock := range socks {
+			sl.socks = append(sl.socks, tls.NewListener(sl.proxyProtocol.Listener(sock), sl.tlsCfg))
+		}
+
+		sl.active.Add(len(sl.socks))
+		for _, sock := range sl.socks {
+			go sl.runAccept(sock)
+		}
 	}
 
+	routes := acc.routes()
+
 	sl.mtx.Lock()
 	defer sl.mtx.Unlock()
 
 	// check for conflict
-	for _, proto := range protos {
-		if _, exists := sl.handlers[proto]; exists {
-			return fmt.Errorf("handler for protocol[%s] already exists", proto)
+	for _, r := range routes {
+		if existing, exists := sl.handlers[r]; exists {
+			return fmt.Errorf("handler for %s already exists, registered by [%s]", r, existing.name)
+		}
+	}
+	if acc.fallback {
+		for _, serverName := range acc.serverNamePatterns() {
+			if existing, exists := sl.fallbacks[serverName]; exists {
+				return fmt.Errorf("fallback handler for server name[%s] already exists, registered by [%s]",
+					serverNamePatternString(serverName), existing.name)
+			}
 		}
 	}
 
 	acc.listener = sl
-	for _, proto := range protos {
-		sl.handlers[proto] = acc
+	for _, r := range routes {
+		sl.handlers[r] = acc
+	}
+	if acc.fallback {
+		for _, serverName := range acc.serverNamePatterns() {
+			sl.fallbacks[serverName] = acc
+		}
 	}
 
 	return nil
 }
 
 type sharedListener struct {
-	log      logrus.FieldLogger
-	address  string
-	tlsCfg   *tls.Config
-	mtx      sync.RWMutex
-	handlers map[string]*protocolHandler // proto -> protocolHandler
-	ctx      context.Context
-	done     context.CancelFunc
-	sock     net.Listener
+	log           logrus.FieldLogger
+	key           string
+	network       string
+	address       string
+	tlsCfg        *tls.Config
+	socketOptions *transport.SocketOptions
+	proxyProtocol *transport.ProxyProtocolConfig
+	mtx           sync.RWMutex
+	handlers      map[route]*protocolHandler
+	fallbacks     map[string]*protocolHandler // server name pattern -> fallback protocolHandler
+	ctx           context.Context
+	done          context.CancelFunc
+	socks         []net.Listener
+
+	// protocolPreference is the order in which the listener chooses among the protocols offered by a client. If
+	// empty, the last of the client's protocols with a handler is chosen
+	protocolPreference []string
+
+	// handshakeCtx is kept separate from ctx, so that stopping the listener during a graceful shutdown doesn't abort
+	// handshakes which are still in progress
+	handshakeCtx     context.Context
+	cancelHandshakes context.CancelFunc
+
+	// active tracks the accept loops and the handshakes they have started
+	active sync.WaitGroup
 }
 
 func (self *sharedListener) processConn(conn *tls.Conn) {
+	if proxyConn, ok := conn.NetConn().(*transport.ProxyProtocolConn); ok {
+		if _, err := proxyConn.Header(); err != nil {
+			self.log.WithError(err).WithField("remote", proxyConn.NetConn().RemoteAddr().String()).Error("invalid proxy protocol header, closing connection")
+			_ = conn.Close()
+			return
+		}
+	}
+
 	log := self.log.WithField("remote", conn.RemoteAddr().String())
 
-	if tcpConn, ok := conn.NetConn().(*net.TCPConn); ok {
-		_ = tcpConn.SetNoDelay(true)
-		_ = tcpConn.SetKeepAlive(true)
-		_ = tcpConn.SetKeepAlivePeriod(keepAlive)
+	if err := self.socketOptions.Apply(conn); err != nil {
+		log.WithError(err).Error("unable to set socket options, closing connection")
+		_ = conn.Close()
+		return
 	}
 
 	timeout := handshakeTimeout.Load()
@@ -241,33 +578,62 @@ func (self *sharedListener) processConn(conn *tls.Conn) {
 		timeout = 5 * time.Second
 	}
 
+	var peerCredentials *transport.PeerCredentials
+	if unixConn, ok := conn.NetConn().(*net.UnixConn); ok {
+		creds, err := transportunix.GetPeerCredentials(unixConn)
+		if err != nil {
+			log.WithError(err).Debug("unable to get peer credentials")
+		}
+		peerCredentials = creds
+	}
+
 	rateLimiter := *rateLimiterAtomic.Load()
 
 	// sharedListener.getConfig will select the right handler during handshake based on ClientHelloInfo
-	// no need to do another look up here
-	var handler *protocolHandler
-	hsCtx, cancelF := context.WithTimeout(context.WithValue(self.ctx, handlerKey, &handler), timeout)
-	defer cancelF()
+	// no need to do another look up here. It also applies the handler's timeout and rate limiter
+	hs := &handshake{
+		start: time.Now(),
+	}
+	hsCtx, cancelF := context.WithCancelCause(context.WithValue(self.handshakeCtx, handshakeKey, hs))
+	defer cancelF(nil)
+	hs.timer = time.AfterFunc(timeout, func() {
+		cancelF(context.DeadlineExceeded)
+	})
+	defer hs.timer.Stop()
 
 	handshakeF := func(control rate.RateLimitControl) error {
+		hs.control = control
 		err := conn.HandshakeContext(hsCtx)
-		if err != nil {
-			if io.EOF == err {
-				control.Backoff()
+		if err != nil && hsCtx.Err() != nil {
+			err = context.Cause(hsCtx)
+		}
+
+		// getConfig may have handed the handshake over to the selected handler's rate limiter
+		if hs.control != nil {
+			if err == nil {
+				hs.control.Success()
+			} else if io.EOF == err {
+				hs.control.Backoff()
 			} else {
-				control.Failed()
+				hs.control.Failed()
 			}
-			return err
 		}
-		control.Success()
-		return nil
+		return err
 	}
 
 	err := rateLimiter.RunRateLimitedF(fmt.Sprintf("tls handshake from %s", conn.RemoteAddr().String()), handshakeF)
 
+	handler := hs.handler
+	if handler != nil {
+		defer handler.handshakes.Done()
+	}
+
 	if err != nil {
 		log.WithError(err).Error("handshake failed")
 		_ = conn.Close()
+		if handler != nil {
+			handler.IncrementFailed()
+		}
 		return
 	}
 
@@ -276,20 +642,36 @@ func (self *sharedListener) processConn(conn *tls.Conn) {
 
 	connection := &Connection{
 		detail: &transport.ConnectionDetail{
-			Address: Type + ":" + conn.RemoteAddr().String(),
-			InBound: true,
-			Name:    handler.name,
+			Address:         self.remoteAddress(conn),
+			InBound:         true,
+			Name:            handler.name,
+			PeerCredentials: peerCredentials,
+			MultipathTCP:    transport.MultipathTCPActive(conn),
+			ProxiedBy:       transport.ProxiedBy(conn),
 		},
 		Conn: conn,
 	}
+	handler.IncrementAccepted()
 	handler.acceptF(connection)
 }
 
-func (self *sharedListener) runAccept() {
+// remoteAddress returns the transport address to report for an accepted connection. Unix domain socket clients are
+// usually unnamed, so those connections are reported against the listening socket path instead
+func (self *sharedListener) remoteAddress(conn net.Conn) string {
+	if self.network == "unix" {
+		return UnixType + ":" + self.address
+	}
+	return Type + ":" + conn.RemoteAddr().String()
+}
+
+// runAccept accepts connections on one of the listening sockets. When the shared listener has several sockets, each
+// runs its own accept loop, so that a burst of new connections doesn't queue up behind a single loop
+func (self *sharedListener) runAccept(sock net.Listener) {
+	defer self.active.Done()
 	log := self.log
 	defer log.Info("exited")
 	for {
-		c, err := self.sock.Accept()
+		c, err := sock.Accept()
 		if err != nil {
 			if self.ctx.Err() != nil {
 				log.WithError(err).Info("listener closed, exiting")
@@ -301,46 +683,87 @@ func (self *sharedListener) runAccept() {
 
 		conn := c.(*tls.Conn)
 
-		go self.processConn(conn)
+		self.active.Add(1)
+		go func() {
+			defer self.active.Done()
+			self.processConn(conn)
+		}()
 	}
 }
 
+// handshake is the state of a handshake in progress, shared between processConn and getConfig, which selects the
+// handler once the client hello is received
+type handshake struct {
+	start   time.Time
+	timer   *time.Timer
+	handler *protocolHandler
+	control rate.RateLimitControl
+}
+
 func (self *sharedListener) getConfig(info *tls.ClientHelloInfo) (*tls.Config, error) {
-	log := self.log.WithField("client", info.Conn.RemoteAddr())
+	log := self.log.WithField("client", info.Conn.RemoteAddr()).WithField("serverName", info.ServerName)
 
 	protos := info.SupportedProtos
 	log.Debug("client requesting protocols = ", protos)
 
 	ctx := info.Context()
-	handlerOut := ctx.Value(handlerKey).(**protocolHandler)
+	hs := ctx.Value(handshakeKey).(*handshake)
 
 	self.mtx.RLock()
 	defer self.mtx.RUnlock()
 
 	var handler *protocolHandler
 	var proto string
-	if protos == nil && len(self.handlers) == 1 {
-		log.Debugf("using single protocol as default")
-		for p, h := range self.handlers {
-			proto, handler = p, h
-		}
-	} else {
-		if protos == nil {
-			protos = append(protos, noProtocol)
-		}
-
-		for _, p := range protos {
-			h, found := self.handlers[p]
-			if found {
-				log.Debugf("found handler for proto[%s]", p)
-				handler = h
-				proto = p
+	fallback := false
+	if protos == nil {
+		if handler, proto = self.defaultHandler(info.ServerName); handler != nil {
+			log.Debugf("using single handler as default with proto[%s]", proto)
+		}
+		protos = append(protos, noProtocol)
+	}
+
+	if handler == nil {
+		// an exact server name takes precedence over a wildcard, which takes precedence over handlers registered
+		// for any server name. The protocol is chosen within the first of those with a handler for any of the
+		// requested protocols
+		for _, serverName := range serverNameCandidates(info.ServerName) {
+			if handler, proto = self.selectHandler(serverName, protos); handler != nil {
+				log.Debugf("found handler for server name[%s] proto[%s]", serverName, proto)
+				break
+			}
+		}
+	}
+
+	if handler == nil {
+		for _, serverName := range serverNameCandidates(info.ServerName) {
+			if h, found := self.fallbacks[serverName]; found {
+				log.Debugf("using fallback handler for server name[%s]", serverName)
+				handler, fallback = h, true
+				break
 			}
 		}
 	}
 
 	if handler != nil {
-		*handlerOut = handler
+		handler.handshakes.Add(1)
+		hs.handler = handler
+
+		if handler.handshakeTimeout > 0 {
+			hs.timer.Reset(time.Until(hs.start.Add(handler.handshakeTimeout)))
+		}
+
+		// the default rate limiter covers the handshake until the client hello is received. A handler with its own
+		// takes over from there, so the rest of the handshake is only counted against the handler's
+		if handler.rateLimiter != nil {
+			hs.control.Success()
+			hs.control = nil
+			control, err := handler.rateLimiter.RunRateLimited(fmt.Sprintf("tls handshake from %s", info.Conn.RemoteAddr().String()))
+			if err != nil {
+				return nil, err
+			}
+			hs.control = control
+		}
+
 		cfg := handler.tls
 		if cfg.GetConfigForClient != nil {
 			c, _ := cfg.GetConfigForClient(info)
@@ -349,32 +772,86 @@ func (self *sharedListener) getConfig(info *tls.ClientHelloInfo) (*tls.Config, e
 			}
 		}
 		cfg = cfg.Clone()
-		cfg.NextProtos = []string{proto}
+		if fallback {
+			// none of the requested protocols are supported, so the connection proceeds without one
+			cfg.NextProtos = nil
+		} else {
+			cfg.NextProtos = []string{proto}
+		}
 		return cfg, nil
 	}
 
-	return nil, fmt.Errorf("not handler for requested protocols %+v", protos)
+	return nil, fmt.Errorf("not handler for requested server name [%s] and protocols %+v", info.ServerName, protos)
+}
+
+// selectHandler returns the handler registered with the server name pattern for one of the requested protocols, and
+// that protocol. The protocol preference is followed if the listener has one, and then the client's list
+// defaultHandler returns the handler to use for clients which don't request a protocol, if only one handler is
+// registered, along with the protocol it was registered for under the best matching server name
+func (self *sharedListener) defaultHandler(serverName string) (*protocolHandler, string) {
+	var handler *protocolHandler
+	for _, h := range self.handlers {
+		if handler != nil && h != handler {
+			return nil, ""
+		}
+		handler = h
+	}
+
+	if handler == nil {
+		return nil, ""
+	}
+
+	routes := handler.routes()
+	for _, candidate := range serverNameCandidates(serverName) {
+		for _, r := range routes {
+			if r.serverName == candidate {
+				return handler, r.proto
+			}
+		}
+	}
+	return nil, ""
+}
+
+func (self *sharedListener) selectHandler(serverName string, protos []string) (*protocolHandler, string) {
+	for _, p := range self.protocolPreference {
+		if slices.Contains(protos, p) {
+			if h, found := self.handlers[route{serverName: serverName, proto: p}]; found {
+				return h, p
+			}
+		}
+	}
+
+	var handler *protocolHandler
+	var proto string
+	for _, p := range protos {
+		if h, found := self.handlers[route{serverName: serverName, proto: p}]; found {
+			handler, proto = h, p
+		}
+	}
+	return handler, proto
 }
 
 func (self *sharedListener) remove(h *protocolHandler) {
 	self.log.WithField("name", h.name).Debug("removing handler")
 
-	protos := h.tls.NextProtos
-	if protos == nil {
-		protos = append(protos, noProtocol)
-	}
+	routes := h.routes()
 
 	self.mtx.Lock()
 	defer self.mtx.Unlock()
 
-	for _, p := range protos {
-		delete(self.handlers, p)
+	for _, r := range routes {
+		delete(self.handlers, r)
+	}
+	for serverName, fallback := range self.fallbacks {
+		if fallback == h {
+			delete(self.fallbacks, serverName)
+		}
 	}
 
-	if len(self.handlers) == 0 {
+	if len(self.handlers) == 0 && len(self.fallbacks) == 0 {
 		self.log.Debug("no handlers left. stopping")
-		sharedListeners.Delete(self.address)
+		sharedListeners.Delete(self.key)
 		self.done()
-		_ = self.sock.Close()
+		_ = transport.CloseListeners(self.socks)
 	}
 }
@@ -1,19 +1,26 @@
 package tls
 
 import (
+	"context"
 	"crypto/ecdsa"
 	"crypto/elliptic"
 	"crypto/rand"
 	"crypto/tls"
 	"crypto/x509"
 	"crypto/x509/pkix"
+	"errors"
 	"fmt"
 	"math/big"
 	"net"
 	"net/http"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"sync/atomic"
 	"testing"
 	"time"
 
+	"github.com/openziti/foundation/v2/rate"
 	"github.com/openziti/identity"
 	"github.com/openziti/transport/v2"
 	"github.com/stretchr/testify/require"
@@ -322,7 +329,7 @@ func TestListen(t *testing.T) {
 	sl = el.(*sharedListener)
 	req.Equal(3, len(sl.handlers), "should have a three handled protocols")
 
-	req.Same(sl.handlers[""], sl.handlers["bar"], "should be handled by the same protocolHandler")
+	req.Same(sl.handlers[route{proto: ""}], sl.handlers[route{proto: "bar"}], "should be handled by the same protocolHandler")
 
 	req.NoError(checkClient(testAddress, "foo", "foo", t))
 	req.NoError(checkClient(testAddress, "bar", "bar", t))
@@ -435,3 +442,738 @@ func TestListenSingleProto(t *testing.T) {
 
 	req.NoError(fooListener.Close())
 }
+
+func TestListenWithConfigListenerSockets(t *testing.T) {
+	if runtime.GOOS != "linux" {
+		t.Skip("multiple listener sockets are only supported on linux")
+	}
+
+	req := require.New(t)
+
+	ident := &identity.TokenId{
+		Identity: serverId,
+		Token:    "test",
+		Data:     nil,
+	}
+
+	testAddress := "127.0.0.1:14446"
+
+	tcfg := transport.Configuration{
+		transport.KeyProtocol: "foo",
+		transport.KeySocket: map[interface{}]interface{}{
+			"listenerSockets": 4,
+		},
+	}
+
+	listener, err := ListenWithConfig(testAddress, "fooListener", ident, makeGreeter("foo"), tcfg)
+	req.NoError(err)
+
+	el, ok := sharedListeners.Load(testAddress)
+	req.True(ok, "should have shared listener")
+	req.Len(el.(*sharedListener).socks, 4)
+
+	for range 8 {
+		req.NoError(checkClient(testAddress, "foo", "foo", t))
+	}
+
+	req.NoError(listener.Close())
+	req.Error(checkClient(testAddress, "foo", "foo", t), "listen sockets should be closed")
+}
+
+func TestListenReportsBoundAddress(t *testing.T) {
+	req := require.New(t)
+
+	ident := &identity.TokenId{
+		Identity: serverId,
+		Token:    "test",
+		Data:     nil,
+	}
+
+	bindAddr, err := AddressParser{}.Parse("tls:127.0.0.1:0")
+	req.NoError(err)
+
+	listener, err := bindAddr.Listen("fooListener", ident, makeGreeter("foo"), transport.Configuration{transport.KeyProtocol: "foo"})
+	req.NoError(err)
+	defer func() { _ = listener.Close() }()
+
+	addr, err := AddressParser{}.Parse(listener.Addr())
+	req.NoError(err)
+	req.NotZero(addr.(*address).port)
+
+	req.NoError(checkClient(strings.TrimPrefix(listener.Addr(), Type+":"), "foo", "foo", t))
+	req.Error(checkClient(strings.TrimPrefix(listener.Addr(), Type+":"), "bar", "bar", t))
+	req.Equal(transport.ListenerStats{Accepted: 1}, listener.Stats())
+}
+
+// gatedConn lets the first write through, then blocks writes until released
+type gatedConn struct {
+	net.Conn
+	writes  atomic.Int32
+	release chan struct{}
+}
+
+func (self *gatedConn) Write(b []byte) (int, error) {
+	if self.writes.Add(1) > 1 {
+		<-self.release
+	}
+	return self.Conn.Write(b)
+}
+
+func TestShutdownWaitsForHandshake(t *testing.T) {
+	req := require.New(t)
+
+	ident := &identity.TokenId{
+		Identity: serverId,
+		Token:    "test",
+		Data:     nil,
+	}
+
+	accepted := make(chan transport.Conn, 1)
+	listener, err := Listen("127.0.0.1:0", "fooListener", ident, func(conn transport.Conn) {
+		accepted <- conn
+	}, "foo")
+	req.NoError(err)
+
+	sock, err := net.Dial("tcp", strings.TrimPrefix(listener.Addr(), Type+":"))
+	req.NoError(err)
+	defer func() { _ = sock.Close() }()
+
+	// the client hello is sent, which selects the handler, but the handshake can't complete until released
+	gated := &gatedConn{Conn: sock, release: make(chan struct{})}
+	tlsCfg := clientId.ClientTLSConfig()
+	tlsCfg.ServerName = "127.0.0.1"
+	tlsCfg.NextProtos = []string{"foo"}
+	client := tls.Client(gated, tlsCfg)
+
+	handshakeErr := make(chan error, 1)
+	go func() {
+		handshakeErr <- client.Handshake()
+	}()
+
+	req.Eventually(func() bool {
+		return gated.writes.Load() > 1
+	}, 5*time.Second, 10*time.Millisecond)
+
+	shutdownErr := make(chan error, 1)
+	go func() {
+		ctx, cancelF := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancelF()
+		shutdownErr <- listener.Shutdown(ctx)
+	}()
+
+	select {
+	case err = <-shutdownErr:
+		req.Failf("shutdown returned before handshake completed", "err: %v", err)
+	case <-time.After(100 * time.Millisecond):
+	}
+
+	close(gated.release)
+	req.NoError(<-handshakeErr)
+	req.NoError(<-shutdownErr)
+
+	select {
+	case conn := <-accepted:
+		_ = conn.Close()
+	default:
+		req.Fail("connection should have been accepted before shutdown returned")
+	}
+
+	req.Equal(transport.ListenerStats{Accepted: 1}, listener.Stats())
+
+	_, found := sharedListeners.Load("127.0.0.1:0")
+	req.False(found, "shared listener should be removed")
+}
+
+func TestListenUnix(t *testing.T) {
+	req := require.New(t)
+
+	ident := &identity.TokenId{
+		Identity: serverId,
+		Token:    "test",
+		Data:     nil,
+	}
+
+	path := filepath.Join(t.TempDir(), "tls.sock")
+
+	fooListener, err := ListenUnix(path, "fooListener", ident, makeGreeter("foo"), "foo")
+	req.NoError(err)
+
+	_, ok := sharedListeners.Load(UnixType + ":" + path)
+	req.True(ok, "should have shared listener")
+
+	dialUnix := func(proto string) (string, error) {
+		cfg := clientId.ClientTLSConfig()
+		cfg.ServerName = "localhost"
+		cfg.NextProtos = []string{proto}
+
+		conn, err := tls.DialWithDialer(&net.Dialer{Timeout: time.Second}, "unix", path, cfg)
+		if err != nil {
+			return "", err
+		}
+		defer func() { _ = conn.Close() }()
+
+		buf := make([]byte, 1024)
+		n, err := conn.Read(buf)
+		if err != nil {
+			return "", err
+		}
+		return string(buf[:n]), nil
+	}
+
+	msg, err := dialUnix("foo")
+	req.NoError(err)
+	req.Equal("Hello from foo", msg)
+
+	_, err = dialUnix("bar")
+	req.Error(err, "should have no handler")
+
+	req.NoError(fooListener.Close())
+
+	_, ok = sharedListeners.Load(UnixType + ":" + path)
+	req.False(ok, "failed to shutdown shared listener")
+
+	_, err = dialUnix("foo")
+	req.Error(err, "listen socket should be closed")
+}
+
+func TestListenWithProxyProtocol(t *testing.T) {
+	req := require.New(t)
+
+	ident := &identity.TokenId{
+		Identity: serverId,
+		Token:    "test",
+		Data:     nil,
+	}
+
+	tcfg := transport.Configuration{
+		transport.KeyProtocol: "foo",
+		transport.KeyProxyProtocol: map[interface{}]interface{}{
+			"trustedSources": []interface{}{"127.0.0.0/8"},
+		},
+	}
+
+	accepted := make(chan transport.Conn, 1)
+	listener, err := ListenWithConfig("127.0.0.1:0", "fooListener", ident, func(conn transport.Conn) {
+		accepted <- conn
+	}, tcfg)
+	req.NoError(err)
+	defer func() { _ = listener.Close() }()
+
+	sock, err := net.Dial("tcp", strings.TrimPrefix(listener.Addr(), Type+":"))
+	req.NoError(err)
+	defer func() { _ = sock.Close() }()
+
+	// v2 header for a tcp over ipv4 connection from 203.0.113.7:4000 to 10.0.0.1:443
+	header := []byte("\r\n\r\n\x00\r\nQUIT\n")
+	header = append(header, 0x21, 0x11, 0x00, 0x0c, 203, 0, 113, 7, 10, 0, 0, 1, 0x0f, 0xa0, 0x01, 0xbb)
+	_, err = sock.Write(header)
+	req.NoError(err)
+
+	tlsCfg := clientId.ClientTLSConfig()
+	tlsCfg.ServerName = "127.0.0.1"
+	tlsCfg.NextProtos = []string{"foo"}
+	client := tls.Client(sock, tlsCfg)
+	req.NoError(client.Handshake())
+
+	select {
+	case inbound := <-accepted:
+		defer func() { _ = inbound.Close() }()
+		req.Equal("tls:203.0.113.7:4000", inbound.Detail().Address)
+		req.Equal(sock.LocalAddr().String(), inbound.Detail().ProxiedBy)
+		req.Equal("203.0.113.7:4000", inbound.RemoteAddr().String())
+	case <-time.After(5 * time.Second):
+		req.Fail("connection not accepted")
+	}
+}
+
+func checkServerName(addr, serverName, proto, expected string, t *testing.T) error {
+	var protos []string
+	if proto != "" {
+		protos = []string{proto}
+	}
+	greeting, _, err := dialSharedListener(addr, serverName, protos...)
+	if err != nil {
+		return err
+	}
+	require.Equal(t, "Hello from "+expected, greeting)
+	return nil
+}
+
+// dialSharedListener connects with the given server name and protocols, returning the greeting sent by the handler
+// and the negotiated protocol
+func dialSharedListener(addr, serverName string, protos ...string) (string, string, error) {
+	tlsCfg := clientId.ClientTLSConfig().Clone()
+	tlsCfg.ServerName = serverName
+	tlsCfg.InsecureSkipVerify = true // the test certificate is only valid for localhost
+	tlsCfg.NextProtos = protos
+
+	conn, err := tls.DialWithDialer(&net.Dialer{Timeout: time.Second}, "tcp", addr, tlsCfg)
+	if err != nil {
+		return "", "", err
+	}
+	defer func() { _ = conn.Close() }()
+
+	buf := make([]byte, 1024)
+	n, err := conn.Read(buf)
+	if err != nil {
+		return "", "", err
+	}
+	return string(buf[:n]), conn.ConnectionState().NegotiatedProtocol, nil
+}
+
+func TestListenRoutesByServerName(t *testing.T) {
+	req := require.New(t)
+
+	ident := &identity.TokenId{
+		Identity: serverId,
+		Token:    "test",
+		Data:     nil,
+	}
+
+	bindAddress := "127.0.0.1:0"
+
+	listen := func(name string, serverNames interface{}, protocols ...string) (transport.Listener, error) {
+		tcfg := transport.Configuration{}
+		if serverNames != nil {
+			tcfg[transport.KeyServerNames] = serverNames
+		}
+		if len(protocols) > 0 {
+			tcfg[transport.KeyProtocol] = protocols
+		}
+		return ListenWithConfig(bindAddress, name, ident, makeGreeter(name), tcfg)
+	}
+
+	apiListener, err := listen("api", "api.example.com")
+	req.NoError(err)
+	defer func() { _ = apiListener.Close() }()
+
+	testAddress := strings.TrimPrefix(apiListener.Addr(), Type+":")
+
+	wildcardListener, err := listen("wildcard", []interface{}{"*.example.com", "*.example.org"})
+	req.NoError(err)
+	defer func() { _ = wildcardListener.Close() }()
+
+	defaultListener, err := listen("default", nil)
+	req.NoError(err)
+	defer func() { _ = defaultListener.Close() }()
+
+	fooListener, err := listen("foo", nil, "foo")
+	req.NoError(err)
+	defer func() { _ = fooListener.Close() }()
+
+	req.NoError(checkServerName(testAddress, "api.example.com", "", "api", t))
+	req.NoError(checkServerName(testAddress, "API.Example.com.", "", "api", t))
+	req.NoError(checkServerName(testAddress, "web.example.com", "", "wildcard", t))
+	req.NoError(checkServerName(testAddress, "web.example.org", "", "wildcard", t))
+	req.NoError(checkServerName(testAddress, "a.web.example.com", "", "default", t))
+	req.NoError(checkServerName(testAddress, "example.net", "", "default", t))
+	req.NoError(checkServerName(testAddress, "api.example.com", "foo", "foo", t))
+	req.Error(checkServerName(testAddress, "api.example.com", "bar", "", t))
+
+	_, err = listen("conflict", []string{"other.example.com", "*.EXAMPLE.com"})
+	req.ErrorContains(err, "server name[*.example.com] protocol[]")
+	req.ErrorContains(err, "[wildcard]")
+
+	_, err = listen("conflict", nil, "bar", "foo")
+	req.ErrorContains(err, "server name[*] protocol[foo]")
+
+	_, err = listen("invalid", "api.*.example.com")
+	req.ErrorContains(err, "invalid server name pattern")
+
+	req.NoError(checkServerName(testAddress, "other.example.com", "", "wildcard", t), "failed registration should leave no routes")
+
+	req.NoError(apiListener.Close())
+	req.NoError(checkServerName(testAddress, "api.example.com", "", "wildcard", t))
+}
+
+func TestListenDefaultsToSingleHandler(t *testing.T) {
+	req := require.New(t)
+
+	ident := &identity.TokenId{
+		Identity: serverId,
+		Token:    "test",
+		Data:     nil,
+	}
+
+	tcfg := transport.Configuration{
+		transport.KeyServerNames: []string{"api.example.com", "*.example.org"},
+		transport.KeyProtocol:    []string{"foo", "bar"},
+	}
+	fooListener, err := ListenWithConfig("127.0.0.1:0", "foo", ident, makeGreeter("foo"), tcfg)
+	req.NoError(err)
+	defer func() { _ = fooListener.Close() }()
+
+	testAddress := strings.TrimPrefix(fooListener.Addr(), Type+":")
+
+	// the only handler is used for clients which don't request a protocol, as long as the server name matches
+	greeting, proto, err := dialSharedListener(testAddress, "api.example.com")
+	req.NoError(err)
+	req.Equal("Hello from foo", greeting)
+	req.Empty(proto)
+
+	greeting, _, err = dialSharedListener(testAddress, "web.example.org")
+	req.NoError(err)
+	req.Equal("Hello from foo", greeting)
+
+	_, _, err = dialSharedListener(testAddress, "example.net")
+	req.Error(err)
+
+	// once there is another handler, clients have to pick one
+	bazListener, err := ListenWithConfig("127.0.0.1:0", "baz", ident, makeGreeter("baz"), transport.Configuration{
+		transport.KeyServerNames: "api.example.com",
+		transport.KeyProtocol:    "baz",
+	})
+	req.NoError(err)
+	defer func() { _ = bazListener.Close() }()
+	req.Equal(fooListener.Addr(), bazListener.Addr())
+
+	_, _, err = dialSharedListener(testAddress, "api.example.com")
+	req.Error(err)
+	req.NoError(checkServerName(testAddress, "api.example.com", "baz", "baz", t))
+}
+
+func TestListenWithFallbackHandler(t *testing.T) {
+	req := require.New(t)
+
+	ident := &identity.TokenId{
+		Identity: serverId,
+		Token:    "test",
+		Data:     nil,
+	}
+
+	bindAddress := "127.0.0.1:0"
+
+	fooListener, err := Listen(bindAddress, "foo", ident, makeGreeter("foo"), "foo")
+	req.NoError(err)
+	defer func() { _ = fooListener.Close() }()
+
+	testAddress := strings.TrimPrefix(fooListener.Addr(), Type+":")
+
+	_, _, err = dialSharedListener(testAddress, "localhost", "baz")
+	req.Error(err, "unknown protocols should fail without a fallback handler")
+
+	fallbackCfg := transport.Configuration{transport.KeyFallback: true}
+	fallbackListener, err := ListenWithConfig(bindAddress, "fallback", ident, makeGreeter("fallback"), fallbackCfg)
+	req.NoError(err)
+
+	_, err = ListenWithConfig(bindAddress, "other", ident, makeGreeter("other"), fallbackCfg)
+	req.ErrorContains(err, "fallback handler for server name[*] already exists, registered by [fallback]")
+
+	wildcardCfg := transport.Configuration{transport.KeyFallback: true, transport.KeyServerNames: "*.example.com"}
+	wildcardListener, err := ListenWithConfig(bindAddress, "wildcard", ident, makeGreeter("wildcard"), wildcardCfg)
+	req.NoError(err)
+	defer func() { _ = wildcardListener.Close() }()
+
+	greeting, proto, err := dialSharedListener(testAddress, "localhost", "foo", "baz")
+	req.NoError(err)
+	req.Equal("Hello from foo", greeting)
+	req.Equal("foo", proto)
+
+	greeting, proto, err = dialSharedListener(testAddress, "localhost", "baz", "qux")
+	req.NoError(err)
+	req.Equal("Hello from fallback", greeting)
+	req.Equal("", proto, "no protocol should be negotiated for the fallback handler")
+
+	// a client without protocols still gets the single protocol handler as default
+	greeting, _, err = dialSharedListener(testAddress, "localhost")
+	req.NoError(err)
+	req.Equal("Hello from foo", greeting)
+
+	greeting, _, err = dialSharedListener(testAddress, "api.example.com", "baz")
+	req.NoError(err)
+	req.Equal("Hello from wildcard", greeting)
+
+	req.NoError(fallbackListener.Close())
+	_, _, err = dialSharedListener(testAddress, "localhost", "baz")
+	req.Error(err, "unknown protocols should fail after the fallback handler is closed")
+
+	req.NoError(fooListener.Close())
+	greeting, _, err = dialSharedListener(testAddress, "api.example.com", "foo")
+	req.NoError(err, "a fallback handler alone should keep the shared listener open")
+	req.Equal("Hello from wildcard", greeting)
+
+	req.NoError(wildcardListener.Close())
+	_, found := sharedListeners.Load(bindAddress)
+	req.False(found, "shared listener should be removed")
+}
+
+func TestListenWithProtocolPreference(t *testing.T) {
+	req := require.New(t)
+
+	ident := &identity.TokenId{
+		Identity: serverId,
+		Token:    "test",
+		Data:     nil,
+	}
+
+	bindAddress := "127.0.0.1:0"
+
+	tcfg := transport.Configuration{
+		transport.KeyProtocol:           []string{"foo", "bar"},
+		transport.KeyProtocolPreference: []interface{}{"bar", "foo"},
+	}
+	fooBarListener, err := ListenWithConfig(bindAddress, "foobar", ident, makeGreeter("foobar"), tcfg)
+	req.NoError(err)
+	defer func() { _ = fooBarListener.Close() }()
+
+	testAddress := strings.TrimPrefix(fooBarListener.Addr(), Type+":")
+
+	bazListener, err := Listen(bindAddress, "baz", ident, makeGreeter("baz"), "baz")
+	req.NoError(err)
+	defer func() { _ = bazListener.Close() }()
+
+	for _, protos := range [][]string{{"foo", "bar"}, {"bar", "foo"}, {"baz", "foo", "bar"}} {
+		_, proto, err := dialSharedListener(testAddress, "localhost", protos...)
+		req.NoError(err)
+		req.Equal("bar", proto, "server preference should be used for %v", protos)
+	}
+
+	// protocols without a preference are chosen by the client's list
+	greeting, proto, err := dialSharedListener(testAddress, "localhost", "qux", "baz")
+	req.NoError(err)
+	req.Equal("Hello from baz", greeting)
+	req.Equal("baz", proto)
+
+	_, proto, err = dialSharedListener(testAddress, "localhost", "foo", "baz")
+	req.NoError(err)
+	req.Equal("foo", proto)
+}
+
+// startGatedHandshake starts a handshake for the protocol which stalls after the client hello until released
+func startGatedHandshake(t *testing.T, addr, proto string) (*gatedConn, chan error) {
+	sock, err := net.Dial("tcp", addr)
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = sock.Close() })
+
+	gated := &gatedConn{Conn: sock, release: make(chan struct{})}
+	tlsCfg := clientId.ClientTLSConfig().Clone()
+	tlsCfg.ServerName = "127.0.0.1"
+	tlsCfg.NextProtos = []string{proto}
+	client := tls.Client(gated, tlsCfg)
+
+	handshakeErr := make(chan error, 1)
+	go func() {
+		handshakeErr <- client.Handshake()
+	}()
+
+	require.Eventually(t, func() bool {
+		return gated.writes.Load() > 1
+	}, 5*time.Second, 10*time.Millisecond)
+
+	return gated, handshakeErr
+}
+
+func TestListenWithHandlerHandshakeTimeout(t *testing.T) {
+	req := require.New(t)
+
+	defaultTimeout := GetSharedListenerHandshakeTimeout()
+	SetSharedListenerHandshakeTimeout(500 * time.Millisecond)
+	defer SetSharedListenerHandshakeTimeout(defaultTimeout)
+
+	ident := &identity.TokenId{
+		Identity: serverId,
+		Token:    "test",
+		Data:     nil,
+	}
+
+	bindAddress := "127.0.0.1:0"
+
+	shortListener, err := ListenWithConfig(bindAddress, "short", ident, makeGreeter("short"), transport.Configuration{
+		transport.KeyProtocol:            "short",
+		transport.KeyTLSHandshakeTimeout: "50ms",
+	})
+	req.NoError(err)
+	defer func() { _ = shortListener.Close() }()
+
+	testAddress := strings.TrimPrefix(shortListener.Addr(), Type+":")
+
+	longListener, err := ListenWithConfig(bindAddress, "long", ident, makeGreeter("long"), transport.Configuration{
+		transport.KeyProtocol:            "long",
+		transport.KeyTLSHandshakeTimeout: "5s",
+	})
+	req.NoError(err)
+	defer func() { _ = longListener.Close() }()
+
+	_, err = ListenWithConfig(bindAddress, "invalid", ident, makeGreeter("invalid"), transport.Configuration{
+		transport.KeyProtocol:            "invalid",
+		transport.KeyTLSHandshakeTimeout: 5,
+	})
+	req.Error(err)
+
+	_, shortErr := startGatedHandshake(t, testAddress, "short")
+	req.Eventually(func() bool {
+		return shortListener.Stats().Failed == 1
+	}, 300*time.Millisecond, 5*time.Millisecond, "handshake should time out with the handler timeout")
+	select {
+	case err = <-shortErr:
+		req.Fail("client handshake should still be stalled", "err: %v", err)
+	default:
+	}
+
+	// the handshake timeout used by other transports doesn't apply to tls handshakes
+	otherListener, err := ListenWithConfig(bindAddress, "other", ident, makeGreeter("other"), transport.Configuration{
+		transport.KeyProtocol:         "other",
+		transport.KeyHandshakeTimeout: "10ms",
+	})
+	req.NoError(err)
+	defer func() { _ = otherListener.Close() }()
+
+	gated, otherErr := startGatedHandshake(t, testAddress, "other")
+	time.Sleep(100 * time.Millisecond)
+	close(gated.release)
+	req.NoError(<-otherErr)
+
+	gated, longErr := startGatedHandshake(t, testAddress, "long")
+	time.Sleep(700 * time.Millisecond)
+	close(gated.release)
+	req.NoError(<-longErr, "handshake should outlast the default timeout with the handler timeout")
+	req.Eventually(func() bool {
+		return longListener.Stats() == transport.ListenerStats{Accepted: 1}
+	}, time.Second, 10*time.Millisecond)
+}
+
+// testRateLimiter rejects handshakes when reject is set and records the outcome of the others
+type testRateLimiter struct {
+	reject    atomic.Bool
+	successes atomic.Int32
+	backoffs  atomic.Int32
+	failures  atomic.Int32
+}
+
+func (self *testRateLimiter) RunRateLimited(string) (rate.RateLimitControl, error) {
+	if self.reject.Load() {
+		return nil, errors.New("rate limited")
+	}
+	return self, nil
+}
+
+func (self *testRateLimiter) RunRateLimitedF(label string, f func(control rate.RateLimitControl) error) error {
+	control, err := self.RunRateLimited(label)
+	if err != nil {
+		return err
+	}
+	return f(control)
+}
+
+func (self *testRateLimiter) IsRateLimited() bool {
+	return self.reject.Load()
+}
+
+func (self *testRateLimiter) Success() {
+	self.successes.Add(1)
+}
+
+func (self *testRateLimiter) Backoff() {
+	self.backoffs.Add(1)
+}
+
+func (self *testRateLimiter) Failed() {
+	self.failures.Add(1)
+}
+
+func TestListenWithHandlerRateLimiter(t *testing.T) {
+	req := require.New(t)
+
+	ident := &identity.TokenId{
+		Identity: serverId,
+		Token:    "test",
+		Data:     nil,
+	}
+
+	bindAddress := "127.0.0.1:0"
+
+	limiter := &testRateLimiter{}
+	limitedListener, err := ListenWithConfig(bindAddress, "limited", ident, makeGreeter("limited"), transport.Configuration{
+		transport.KeyProtocol:             "limited",
+		transport.KeyHandshakeRateLimiter: limiter,
+	})
+	req.NoError(err)
+	defer func() { _ = limitedListener.Close() }()
+
+	testAddress := strings.TrimPrefix(limitedListener.Addr(), Type+":")
+
+	fooListener, err := Listen(bindAddress, "foo", ident, makeGreeter("foo"), "foo")
+	req.NoError(err)
+	defer func() { _ = fooListener.Close() }()
+
+	_, err = ListenWithConfig(bindAddress, "invalid", ident, makeGreeter("invalid"), transport.Configuration{
+		transport.KeyProtocol:             "invalid",
+		transport.KeyHandshakeRateLimiter: "limiter",
+	})
+	req.ErrorContains(err, "must be rate.AdaptiveRateLimitTracker")
+
+	_, _, err = dialSharedListener(testAddress, "localhost", "limited")
+	req.NoError(err)
+	req.Equal(int32(1), limiter.successes.Load())
+
+	limiter.reject.Store(true)
+	_, _, err = dialSharedListener(testAddress, "localhost", "limited")
+	req.Error(err, "handshake should be rejected by the handler rate limiter")
+	req.Eventually(func() bool {
+		return limitedListener.Stats().Failed == 1
+	}, time.Second, 10*time.Millisecond)
+
+	greeting, _, err := dialSharedListener(testAddress, "localhost", "foo")
+	req.NoError(err, "other handlers should use the default rate limiter")
+	req.Equal("Hello from foo", greeting)
+	req.Equal(int32(1), limiter.successes.Load())
+	req.Equal(int32(0), limiter.failures.Load())
+}
+
+func TestDefaultRateLimiterCoversWholeHandshake(t *testing.T) {
+	req := require.New(t)
+
+	defaultLimiter := &testRateLimiter{}
+	SetSharedListenerRateLimiter(defaultLimiter)
+	defer SetSharedListenerRateLimiter(rate.NoOpAdaptiveRateLimitTracker{})
+
+	ident := &identity.TokenId{
+		Identity: serverId,
+		Token:    "test",
+		Data:     nil,
+	}
+
+	bindAddress := "127.0.0.1:0"
+
+	fooListener, err := Listen(bindAddress, "foo", ident, makeGreeter("foo"), "foo")
+	req.NoError(err)
+	defer func() { _ = fooListener.Close() }()
+
+	testAddress := strings.TrimPrefix(fooListener.Addr(), Type+":")
+
+	handlerLimiter := &testRateLimiter{}
+	limitedListener, err := ListenWithConfig(bindAddress, "limited", ident, makeGreeter("limited"), transport.Configuration{
+		transport.KeyProtocol:             "limited",
+		transport.KeyHandshakeRateLimiter: handlerLimiter,
+	})
+	req.NoError(err)
+	defer func() { _ = limitedListener.Close() }()
+
+	// a client which goes away before sending its hello still counts against the default rate limiter
+	sock, err := net.Dial("tcp", testAddress)
+	req.NoError(err)
+	req.NoError(sock.Close())
+	req.Eventually(func() bool {
+		return defaultLimiter.backoffs.Load() == 1
+	}, time.Second, 10*time.Millisecond)
+
+	_, _, err = dialSharedListener(testAddress, "localhost", "foo")
+	req.NoError(err)
+	req.Eventually(func() bool {
+		return defaultLimiter.successes.Load() == 1
+	}, time.Second, 10*time.Millisecond)
+
+	// once the hello selects a handler with its own rate limiter, the rest of the handshake is counted against it
+	_, _, err = dialSharedListener(testAddress, "localhost", "limited")
+	req.NoError(err)
+	req.Eventually(func() bool {
+		return handlerLimiter.successes.Load() == 1
+	}, time.Second, 10*time.Millisecond)
+	req.Equal(int32(2), defaultLimiter.successes.Load())
+	req.Equal(int32(1), defaultLimiter.backoffs.Load())
+	req.Equal(int32(0), defaultLimiter.failures.Load())
+
+	defaultLimiter.reject.Store(true)
+	_, _, err = dialSharedListener(testAddress, "localhost", "foo")
+	req.Error(err, "handshake should be rejected by the default rate limiter before the hello")
+}
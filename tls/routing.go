@@ -0,0 +1,76 @@
+/*
+	Copyright NetFoundry Inc.
+
+	Licensed under the Apache License, Version 2.0 (the "License");
+	you may not use this file except in compliance with the License.
+	You may obtain a copy of the License at
+
+	https://www.apache.org/licenses/LICENSE-2.0
+
+	Unless required by applicable law or agreed to in writing, software
+	distributed under the License is distributed on an "AS IS" BASIS,
+	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+	See the License for the specific language governing permissions and
+	limitations under the License.
+*/
+
+package tls
+
+import (
+	"fmt"
+	"strings"
+)
+
+// route identifies the connections a handler of a shared listener is selected for, by the server name pattern it was
+// registered with and an ALPN protocol. An empty server name matches connections for any server name
+type route struct {
+	serverName string
+	proto      string
+}
+
+func (self route) String() string {
+	return fmt.Sprintf("server name[%s] protocol[%s]", serverNamePatternString(self.serverName), self.proto)
+}
+
+// serverNamePatternString returns the pattern for display, where * is shown for the pattern matching any server name
+func serverNamePatternString(pattern string) string {
+	if pattern == "" {
+		return "*"
+	}
+	return pattern
+}
+
+// normalizeServerNamePattern validates a server name pattern and returns it in the form used for routing. Names are
+// matched case-insensitively and without a trailing dot. A pattern may be an exact name, a name with a *. wildcard
+// as its first label, which matches exactly one label, or * or empty, which matches any server name
+func normalizeServerNamePattern(pattern string) (string, error) {
+	pattern = strings.TrimSuffix(strings.ToLower(strings.TrimSpace(pattern)), ".")
+	if pattern == "" || pattern == "*" {
+		return "", nil
+	}
+
+	rest, wildcard := strings.CutPrefix(pattern, "*.")
+	if rest == "" || strings.Contains(rest, "*") || strings.HasPrefix(rest, ".") || strings.Contains(rest, "..") {
+		return "", fmt.Errorf("invalid server name pattern [%s]", pattern)
+	}
+
+	if wildcard {
+		return "*." + rest, nil
+	}
+	return rest, nil
+}
+
+// serverNameCandidates returns the patterns which could match the server name requested by a client, in order of
+// precedence: the exact name, the wildcard for its first label and then the pattern matching any server name
+func serverNameCandidates(serverName string) []string {
+	serverName = strings.TrimSuffix(strings.ToLower(serverName), ".")
+	if serverName == "" {
+		return []string{""}
+	}
+
+	result := []string{serverName}
+	if _, parent, found := strings.Cut(serverName, "."); found && parent != "" {
+		result = append(result, "*."+parent)
+	}
+	return append(result, "")
+}
@@ -0,0 +1,46 @@
+package tls
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestNormalizeServerNamePattern(t *testing.T) {
+	tests := []struct {
+		pattern  string
+		expected string
+		wantErr  bool
+	}{
+		{pattern: "", expected: ""},
+		{pattern: "*", expected: ""},
+		{pattern: "API.Example.com.", expected: "api.example.com"},
+		{pattern: "*.Example.com", expected: "*.example.com"},
+		{pattern: "*..", wantErr: true},
+		{pattern: "*.*.example.com", wantErr: true},
+		{pattern: "api.*.com", wantErr: true},
+		{pattern: ".example.com", wantErr: true},
+		{pattern: "api..example.com", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.pattern, func(t *testing.T) {
+			req := require.New(t)
+			result, err := normalizeServerNamePattern(tt.pattern)
+			if tt.wantErr {
+				req.Error(err)
+				return
+			}
+			req.NoError(err)
+			req.Equal(tt.expected, result)
+		})
+	}
+}
+
+func TestServerNameCandidates(t *testing.T) {
+	req := require.New(t)
+	req.Equal([]string{""}, serverNameCandidates(""))
+	req.Equal([]string{"localhost", ""}, serverNameCandidates("localhost"))
+	req.Equal([]string{"api.example.com", "*.example.com", ""}, serverNameCandidates("API.example.com."))
+	req.Equal([]string{"a.b.example.com", "*.b.example.com", ""}, serverNameCandidates("a.b.example.com"))
+}
@@ -0,0 +1,126 @@
+/*
+	Copyright NetFoundry Inc.
+
+	Licensed under the Apache License, Version 2.0 (the "License");
+	you may not use this file except in compliance with the License.
+	You may obtain a copy of the License at
+
+	https://www.apache.org/licenses/LICENSE-2.0
+
+	Unless required by applicable law or agreed to in writing, software
+	distributed under the License is distributed on an "AS IS" BASIS,
+	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+	See the License for the specific language governing permissions and
+	limitations under the License.
+*/
+
+package tlsunix
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/openziti/identity"
+	"github.com/openziti/transport/v2"
+	transporttls "github.com/openziti/transport/v2/tls"
+	"github.com/pkg/errors"
+)
+
+var _ transport.Address = &address{} // enforce that address implements transport.Address
+
+const (
+	Type = transporttls.UnixType
+
+	// DefaultServerName is the name the server certificate is verified against, unless overridden by the
+	// tlsunix.serverName transport configuration value
+	DefaultServerName = "localhost"
+)
+
+// address is a unix domain socket address carrying tls. A path starting with @ denotes a socket in the Linux
+// abstract namespace
+type address struct {
+	path string
+}
+
+func (a address) Dial(name string, i *identity.TokenId, timeout time.Duration, tcfg transport.Configuration) (transport.Conn, error) {
+	ctx, cancelF := transport.TimeoutContext(timeout)
+	defer cancelF()
+	return a.DialContext(ctx, name, i, tcfg)
+}
+
+func (a address) DialWithLocalBinding(name string, localBinding string, i *identity.TokenId, timeout time.Duration, tcfg transport.Configuration) (transport.Conn, error) {
+	ctx, cancelF := transport.TimeoutContext(timeout)
+	defer cancelF()
+	return a.DialWithLocalBindingContext(ctx, name, localBinding, i, tcfg)
+}
+
+func (a address) DialContext(ctx context.Context, name string, i *identity.TokenId, tcfg transport.Configuration) (transport.Conn, error) {
+	return a.DialWithLocalBindingContext(ctx, name, "", i, tcfg)
+}
+
+func (a address) DialWithLocalBindingContext(ctx context.Context, name string, localBinding string, i *identity.TokenId, tcfg transport.Configuration) (transport.Conn, error) {
+	serverName, err := getServerName(tcfg)
+	if err != nil {
+		return nil, err
+	}
+	return DialWithLocalBindingContext(ctx, a.path, name, localBinding, serverName, i, tcfg.Protocols()...)
+}
+
+func (a address) Listen(name string, i *identity.TokenId, acceptF func(transport.Conn), tcfg transport.Configuration) (transport.Listener, error) {
+	return transporttls.ListenUnix(a.path, name, i, acceptF, tcfg.Protocols()...)
+}
+
+func (a address) MustListen(name string, i *identity.TokenId, acceptF func(transport.Conn), tcfg transport.Configuration) transport.Listener {
+	closer, err := a.Listen(name, i, acceptF, tcfg)
+	if err != nil {
+		panic(err)
+	}
+	return closer
+}
+
+func (a address) String() string {
+	return fmt.Sprintf("%s:%s", Type, a.path)
+}
+
+func (a address) Type() string {
+	return Type
+}
+
+func (a address) Path() string {
+	return a.path
+}
+
+func getServerName(tcfg transport.Configuration) (string, error) {
+	val, err := tcfg.GetValue(Type, "serverName")
+	if err != nil {
+		return "", err
+	}
+
+	if val == nil {
+		return DefaultServerName, nil
+	}
+
+	serverName, ok := val.(string)
+	if !ok {
+		return "", errors.Errorf("invalid value for %s:serverName [%v], must be string", Type, val)
+	}
+	return serverName, nil
+}
+
+type AddressParser struct{}
+
+func (ap AddressParser) Parse(s string) (transport.Address, error) {
+	prefix := Type + ":"
+	if !strings.HasPrefix(s, prefix) {
+		return nil, fmt.Errorf("invalid %s address, doesn't start with %s", Type, prefix)
+	}
+
+	path := s[len(prefix):]
+	if path == "" || path == "@" {
+		return nil, fmt.Errorf("invalid %s address '%s', no socket path given", Type, s)
+	}
+
+	return &address{path: path}, nil
+}
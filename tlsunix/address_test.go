@@ -0,0 +1,68 @@
+package tlsunix
+
+import (
+	"testing"
+
+	"github.com/openziti/transport/v2"
+)
+
+func TestParseAndString(t *testing.T) {
+	parser := AddressParser{}
+
+	tests := []struct {
+		name    string
+		input   string
+		want    string
+		wantErr bool
+	}{
+		{"absolute path", "tlsunix:/var/run/ziti.sock", "tlsunix:/var/run/ziti.sock", false},
+		{"abstract", "tlsunix:@ziti", "tlsunix:@ziti", false},
+		{"empty path", "tlsunix:", "", true},
+		{"wrong prefix", "unix:/var/run/ziti.sock", "", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			addr, err := parser.Parse(tt.input)
+			if tt.wantErr {
+				if err == nil {
+					t.Errorf("Parse(%q) expected error, got nil", tt.input)
+				}
+				return
+			}
+			if err != nil {
+				t.Errorf("Parse(%q) unexpected error: %v", tt.input, err)
+				return
+			}
+			if got := addr.String(); got != tt.want {
+				t.Errorf("Parse(%q).String() = %q, want %q", tt.input, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestGetServerName(t *testing.T) {
+	serverName, err := getServerName(nil)
+	if err != nil || serverName != DefaultServerName {
+		t.Errorf("getServerName(nil) = %q, %v, want %q", serverName, err, DefaultServerName)
+	}
+
+	tcfg := transport.Configuration{
+		Type: map[interface{}]interface{}{
+			"serverName": "router.local",
+		},
+	}
+	serverName, err = getServerName(tcfg)
+	if err != nil || serverName != "router.local" {
+		t.Errorf("getServerName() = %q, %v, want %q", serverName, err, "router.local")
+	}
+
+	tcfg = transport.Configuration{
+		Type: map[interface{}]interface{}{
+			"serverName": 5,
+		},
+	}
+	if _, err = getServerName(tcfg); err == nil {
+		t.Error("getServerName() expected error for non-string value")
+	}
+}
@@ -0,0 +1,80 @@
+/*
+	Copyright NetFoundry Inc.
+
+	Licensed under the Apache License, Version 2.0 (the "License");
+	you may not use this file except in compliance with the License.
+	You may obtain a copy of the License at
+
+	https://www.apache.org/licenses/LICENSE-2.0
+
+	Unless required by applicable law or agreed to in writing, software
+	distributed under the License is distributed on an "AS IS" BASIS,
+	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+	See the License for the specific language governing permissions and
+	limitations under the License.
+*/
+
+package tlsunix
+
+import (
+	"context"
+	"crypto/tls"
+	"net"
+
+	"github.com/michaelquigley/pfxlog"
+	"github.com/openziti/identity"
+	"github.com/openziti/transport/v2"
+	transporttls "github.com/openziti/transport/v2/tls"
+	transportunix "github.com/openziti/transport/v2/unix"
+)
+
+func DialContext(ctx context.Context, path, name, serverName string, i *identity.TokenId, protocols ...string) (transport.Conn, error) {
+	return DialWithLocalBindingContext(ctx, path, name, "", serverName, i, protocols...)
+}
+
+// DialWithLocalBindingContext dials the unix domain socket at the given path and performs a tls handshake using the
+// client configuration of the given identity. The server certificate is verified against serverName. The local
+// binding, if given, is the path (or abstract name) the client side of the connection is bound to.
+func DialWithLocalBindingContext(ctx context.Context, path, name, localBinding, serverName string, i *identity.TokenId, protocols ...string) (transport.Conn, error) {
+	log := pfxlog.Logger().WithField("dest", path)
+
+	dialer := &net.Dialer{}
+	if localBinding != "" {
+		dialer.LocalAddr = &net.UnixAddr{Name: localBinding, Net: "unix"}
+	}
+
+	conn, err := dialer.DialContext(ctx, "unix", path)
+	if err != nil {
+		return nil, err
+	}
+
+	tlsCfg := i.ClientTLSConfig().Clone()
+	tlsCfg.ServerName = serverName
+	if len(protocols) > 0 {
+		tlsCfg.NextProtos = append(tlsCfg.NextProtos, protocols...)
+	}
+
+	tlsConn := tls.Client(conn, tlsCfg)
+	if err = tlsConn.HandshakeContext(ctx); err != nil {
+		_ = conn.Close()
+		return nil, err
+	}
+
+	log.Debugf("server provided [%d] certificates", len(tlsConn.ConnectionState().PeerCertificates))
+
+	detail := &transport.ConnectionDetail{
+		Address: Type + ":" + path,
+		InBound: false,
+		Name:    name,
+	}
+
+	if unixConn, ok := conn.(*net.UnixConn); ok {
+		creds, err := transportunix.GetPeerCredentials(unixConn)
+		if err != nil {
+			log.WithError(err).Debug("unable to get peer credentials")
+		}
+		detail.PeerCredentials = creds
+	}
+
+	return transporttls.NewConnection(detail, tlsConn), nil
+}
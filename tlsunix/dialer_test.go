@@ -0,0 +1,72 @@
+package tlsunix
+
+import (
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+	"time"
+
+	"github.com/openziti/transport/v2"
+	"github.com/openziti/transport/v2/internal/testutil"
+	transporttls "github.com/openziti/transport/v2/tls"
+	"github.com/stretchr/testify/require"
+)
+
+func TestListenAndDial(t *testing.T) {
+	req := require.New(t)
+
+	serverId, clientId := testutil.NewIdentities(t)
+
+	path := filepath.Join(t.TempDir(), "test.sock")
+	tcfg := transport.Configuration{transport.KeyProtocol: "ziti-test"}
+
+	addr, err := AddressParser{}.Parse(Type + ":" + path)
+	req.NoError(err)
+
+	accepted := make(chan transport.Conn, 1)
+	listener, err := addr.Listen("test", serverId, func(conn transport.Conn) {
+		accepted <- conn
+	}, tcfg)
+	req.NoError(err)
+	defer func() { _ = listener.Close() }()
+
+	conn, err := addr.Dial("test", clientId, 5*time.Second, tcfg)
+	req.NoError(err)
+	defer func() { _ = conn.Close() }()
+
+	var inbound transport.Conn
+	select {
+	case inbound = <-accepted:
+	case <-time.After(5 * time.Second):
+		req.Fail("connection not accepted")
+	}
+	defer func() { _ = inbound.Close() }()
+
+	req.True(inbound.Detail().InBound)
+	req.False(conn.Detail().InBound)
+
+	// both sides presented certificates
+	req.Len(inbound.PeerCertificates(), 1)
+	req.Equal("testClient", inbound.PeerCertificates()[0].Subject.CommonName)
+	req.NotEmpty(conn.PeerCertificates())
+
+	inboundTls := inbound.(*transporttls.Connection)
+	req.Equal("ziti-test", inboundTls.Protocol())
+	req.Equal("ziti-test", conn.(*transporttls.Connection).Protocol())
+	req.Equal(DefaultServerName, inboundTls.ConnectionState().ServerName)
+
+	if runtime.GOOS == "linux" {
+		creds := inbound.Detail().PeerCredentials
+		req.NotNil(creds)
+		req.Equal(uint32(os.Getuid()), creds.Uid)
+		req.Equal(int32(os.Getpid()), creds.Pid)
+	}
+
+	_, err = conn.Write([]byte("hello"))
+	req.NoError(err)
+	buf := make([]byte, 5)
+	_, err = inbound.Read(buf)
+	req.NoError(err)
+	req.Equal("hello", string(buf))
+}
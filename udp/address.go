@@ -17,8 +17,8 @@
 package udp
 
 import (
+	"context"
 	"fmt"
-	"io"
 	"net"
 	"time"
 
@@ -33,25 +33,43 @@ const Type = "udp"
 type address struct {
 	hostname string
 	port     uint16
+
+	// inherited is set to the bind address of an inherited socket, such as fd:3, which can only be listened on
+	inherited string
 }
 
-func (a address) Dial(name string, i *identity.TokenId, timeout time.Duration, _ transport.Configuration) (transport.Conn, error) {
-	addr, err := a.bindableAddress()
+func (a address) Dial(name string, i *identity.TokenId, timeout time.Duration, tcfg transport.Configuration) (transport.Conn, error) {
+	ctx, cancelF := transport.TimeoutContext(timeout)
+	defer cancelF()
+	return a.DialContext(ctx, name, i, tcfg)
+}
+
+func (a address) DialWithLocalBinding(name string, localBinding string, i *identity.TokenId, timeout time.Duration, tcfg transport.Configuration) (transport.Conn, error) {
+	ctx, cancelF := transport.TimeoutContext(timeout)
+	defer cancelF()
+	return a.DialWithLocalBindingContext(ctx, name, localBinding, i, tcfg)
+}
+
+func (a address) DialContext(ctx context.Context, name string, i *identity.TokenId, _ transport.Configuration) (transport.Conn, error) {
+	addr, err := a.resolve(ctx)
 	if err != nil {
 		return nil, err
 	}
-	return Dial(addr, name, i, timeout)
+	return DialContext(ctx, addr, name, i)
 }
 
-func (a address) DialWithLocalBinding(name string, localBinding string, _ *identity.TokenId, timeout time.Duration, _ transport.Configuration) (transport.Conn, error) {
-	addr, err := a.bindableAddress()
+func (a address) DialWithLocalBindingContext(ctx context.Context, name string, localBinding string, _ *identity.TokenId, _ transport.Configuration) (transport.Conn, error) {
+	addr, err := a.resolve(ctx)
 	if err != nil {
 		return nil, err
 	}
-	return DialWithLocalBinding(addr, name, localBinding, timeout)
+	return DialWithLocalBindingContext(ctx, addr, name, localBinding)
 }
 
-func (a address) Listen(name string, i *identity.TokenId, acceptF func(transport.Conn), _ transport.Configuration) (io.Closer, error) {
+func (a address) Listen(name string, i *identity.TokenId, acceptF func(transport.Conn), _ transport.Configuration) (transport.Listener, error) {
+	if a.inherited != "" {
+		return ListenInherited(a.inherited, name, i, acceptF)
+	}
 	addr, err := a.bindableAddress()
 	if err != nil {
 		return nil, err
@@ -59,7 +77,7 @@ func (a address) Listen(name string, i *identity.TokenId, acceptF func(transport
 	return Listen(addr, name, i, acceptF)
 }
 
-func (a address) MustListen(name string, i *identity.TokenId, acceptF func(transport.Conn), tcfg transport.Configuration) io.Closer {
+func (a address) MustListen(name string, i *identity.TokenId, acceptF func(transport.Conn), tcfg transport.Configuration) transport.Listener {
 	closer, err := a.Listen(name, i, acceptF, tcfg)
 	if err != nil {
 		panic(err)
@@ -68,11 +86,23 @@ func (a address) MustListen(name string, i *identity.TokenId, acceptF func(trans
 }
 
 func (a address) String() string {
+	if a.inherited != "" {
+		return fmt.Sprintf("%s:%s", Type, a.inherited)
+	}
 	return fmt.Sprintf("%s:%s", Type, transport.HostPortString(a.hostname, a.port))
 }
 
 func (a address) bindableAddress() (*net.UDPAddr, error) {
-	return net.ResolveUDPAddr("udp", transport.HostPortString(a.hostname, a.port))
+	return a.resolve(context.Background())
+}
+
+// resolve looks up the address to dial or bind to. The host name is resolved each time, so that DNS changes are
+// picked up by long-running processes
+func (a address) resolve(ctx context.Context) (*net.UDPAddr, error) {
+	if a.inherited != "" {
+		return nil, fmt.Errorf("unable to dial %v, inherited sockets can only be listened on", a)
+	}
+	return transport.ResolveUDPAddress(ctx, a.hostname, a.port)
 }
 
 func (a address) Type() string {
@@ -90,6 +120,9 @@ func (a address) Port() uint16 {
 type AddressParser struct{}
 
 func (ap AddressParser) Parse(s string) (transport.Address, error) {
+	if inherited, ok := transport.ParseInheritedAddress(s, Type); ok {
+		return &address{inherited: inherited}, nil
+	}
 	host, port, err := transport.ParseAddressHostPort(s, Type)
 	if err != nil {
 		return nil, err
@@ -17,6 +17,8 @@ func TestParseAndString(t *testing.T) {
 		{"ipv4 ip", "udp:127.0.0.1:8080", "udp:127.0.0.1:8080", false},
 		{"ipv6 loopback", "udp:[::1]:8080", "udp:[::1]:8080", false},
 		{"ipv6 full", "udp:[fe80::1]:443", "udp:[fe80::1]:443", false},
+		{"inherited fd", "udp:fd:3", "udp:fd:3", false},
+		{"inherited name", "udp:fd:router", "udp:fd:router", false},
 		{"wrong prefix", "tcp:localhost:8080", "", true},
 	}
 
@@ -18,6 +18,7 @@ package udp
 
 import (
 	"bufio"
+	"context"
 	"math"
 	"net"
 	"time"
@@ -26,32 +27,35 @@ import (
 	"github.com/openziti/transport/v2"
 )
 
-func Dial(destination *net.UDPAddr, name string, _ *identity.TokenId, timeout time.Duration) (transport.Conn, error) {
-	socket, err := net.DialTimeout("udp", destination.String(), timeout)
-	if err != nil {
-		return nil, err
-	}
-
-	return &Connection{
-		detail: &transport.ConnectionDetail{
-			Address: Type + ":" + destination.String(),
-			InBound: false,
-			Name:    name,
-		},
-		Conn:   socket,
-		reader: bufio.NewReaderSize(socket, math.MaxUint16),
-	}, nil
+func Dial(destination *net.UDPAddr, name string, i *identity.TokenId, timeout time.Duration) (transport.Conn, error) {
+	ctx, cancelF := transport.TimeoutContext(timeout)
+	defer cancelF()
+	return DialContext(ctx, destination, name, i)
 }
 
 func DialWithLocalBinding(destination *net.UDPAddr, name, localBinding string, timeout time.Duration) (transport.Conn, error) {
-	dialer, err := transport.NewDialerWithLocalBinding("udp", timeout, localBinding)
+	ctx, cancelF := transport.TimeoutContext(timeout)
+	defer cancelF()
+	return DialWithLocalBindingContext(ctx, destination, name, localBinding)
+}
+
+func DialContext(ctx context.Context, destination *net.UDPAddr, name string, _ *identity.TokenId) (transport.Conn, error) {
+	return DialWithLocalBindingContext(ctx, destination, name, "")
+}
 
+func DialWithLocalBindingContext(ctx context.Context, destination *net.UDPAddr, name, localBinding string) (transport.Conn, error) {
+	binding, err := transport.ParseLocalBinding(localBinding)
 	if err != nil {
 		return nil, err
 	}
 
-	socket, err := dialer.Dial("udp", destination.String())
-
+	socket, err := transport.BindLocalAddr(binding, "udp", destination.IP, func(localAddr net.Addr) (net.Conn, error) {
+		dialer := &net.Dialer{
+			LocalAddr: localAddr,
+			Control:   binding.Control(localAddr),
+		}
+		return dialer.DialContext(ctx, "udp", destination.String())
+	})
 	if err != nil {
 		return nil, err
 	}
@@ -18,9 +18,11 @@ package udp
 
 import (
 	"bufio"
-	"io"
+	"context"
 	"math"
 	"net"
+	"sync"
+	"sync/atomic"
 
 	"github.com/michaelquigley/pfxlog"
 	"github.com/openziti/identity"
@@ -29,25 +31,78 @@ import (
 	"github.com/sirupsen/logrus"
 )
 
-func Listen(bindAddress *net.UDPAddr, name string, i *identity.TokenId, acceptF func(transport.Conn)) (io.Closer, error) {
-	log := pfxlog.ContextLogger(name + "/" + Type + ":" + bindAddress.String())
+func Listen(bindAddress *net.UDPAddr, name string, i *identity.TokenId, acceptF func(transport.Conn)) (transport.Listener, error) {
+	sock, err := udpconn.Listen("udp", bindAddress)
+	if err != nil {
+		return nil, err
+	}
+	return listen(sock, name+"/"+Type+":"+bindAddress.String(), name, acceptF), nil
+}
 
-	listener, err := udpconn.Listen("udp", bindAddress)
+// ListenInherited is like Listen, but uses an inherited socket, given by a bind address such as fd:3, instead of
+// binding a new one
+func ListenInherited(bindAddress, name string, i *identity.TokenId, acceptF func(transport.Conn)) (transport.Listener, error) {
+	sock, err := udpconn.ListenInherited(bindAddress, udpconn.NewUnlimitedConnectionPolicy(), udpconn.NewDefaultExpirationPolicy())
 	if err != nil {
 		return nil, err
 	}
+	return listen(sock, name+"/"+Type+":"+bindAddress, name, acceptF), nil
+}
+
+func listen(sock net.Listener, logContext, name string, acceptF func(transport.Conn)) transport.Listener {
+	log := pfxlog.ContextLogger(logContext)
+
+	result := &listener{
+		name:    name,
+		sock:    sock,
+		acceptF: acceptF,
+	}
 
-	go acceptLoop(log.Entry, name, listener, acceptF)
+	result.acceptLoops.Add(1)
+	go result.acceptLoop(log.Entry)
+
+	return result
+}
 
-	return listener, nil
+type listener struct {
+	transport.ListenerCounters
+	name        string
+	sock        net.Listener
+	acceptF     func(transport.Conn)
+	acceptLoops sync.WaitGroup
+	closed      atomic.Bool
 }
 
-func acceptLoop(log *logrus.Entry, name string, listener net.Listener, acceptF func(transport.Conn)) {
+func (self *listener) Addr() string {
+	return Type + ":" + self.sock.Addr().String()
+}
+
+func (self *listener) Close() error {
+	if self.closed.CompareAndSwap(false, true) {
+		return self.sock.Close()
+	}
+	return nil
+}
+
+// Shutdown closes the listening socket and waits for the accept loop to exit
+func (self *listener) Shutdown(ctx context.Context) error {
+	if err := self.Close(); err != nil {
+		return err
+	}
+	return transport.WaitForShutdown(ctx, &self.acceptLoops)
+}
+
+func (self *listener) acceptLoop(log *logrus.Entry) {
+	defer self.acceptLoops.Done()
 	defer log.Error("exited")
 
 	for {
-		socket, err := listener.Accept()
+		socket, err := self.sock.Accept()
 		if err != nil {
+			if self.closed.Load() {
+				log.WithError(err).Info("listener closed, exiting")
+				return
+			}
 			log.WithField("err", err).Error("accept failed. Failure not recoverable. Exiting listen loop")
 			return
 		} else {
@@ -56,12 +111,13 @@ func acceptLoop(log *logrus.Entry, name string, listener net.Listener, acceptF f
 				detail: &transport.ConnectionDetail{
 					Address: Type + ":" + socket.RemoteAddr().String(),
 					InBound: true,
-					Name:    name,
+					Name:    self.name,
 				},
 				Conn:   socket,
 				reader: bufio.NewReaderSize(socket, math.MaxUint16),
 			}
-			acceptF(connection)
+			self.IncrementAccepted()
+			self.acceptF(connection)
 		}
 	}
 }
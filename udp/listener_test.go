@@ -0,0 +1,206 @@
+package udp
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/openziti/transport/v2"
+	"github.com/openziti/transport/v2/internal/testutil"
+	"github.com/stretchr/testify/require"
+)
+
+func TestListenerShutdown(t *testing.T) {
+	req := require.New(t)
+
+	accepted := make(chan transport.Conn, 1)
+	listener, err := Listen(&net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)}, "test", nil, func(conn transport.Conn) {
+		accepted <- conn
+	})
+	req.NoError(err)
+
+	addr, err := AddressParser{}.Parse(listener.Addr())
+	req.NoError(err)
+
+	conn, err := addr.Dial("test", nil, time.Second, nil)
+	req.NoError(err)
+	defer func() { _ = conn.Close() }()
+
+	_, err = conn.Write([]byte("hello"))
+	req.NoError(err)
+
+	select {
+	case inbound := <-accepted:
+		_ = inbound.Close()
+	case <-time.After(5 * time.Second):
+		req.Fail("connection not accepted")
+	}
+
+	ctx, cancelF := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancelF()
+	req.NoError(listener.Shutdown(ctx))
+	req.NoError(listener.Close())
+}
+
+func TestListenInherited(t *testing.T) {
+	req := require.New(t)
+
+	sock, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	req.NoError(err)
+	defer func() { _ = sock.Close() }()
+
+	fd := testutil.InheritableFd(t, sock)
+
+	bindAddr, err := AddressParser{}.Parse(fmt.Sprintf("udp:fd:%d", fd))
+	req.NoError(err)
+
+	accepted := make(chan transport.Conn, 1)
+	listener, err := bindAddr.Listen("test", nil, func(conn transport.Conn) {
+		accepted <- conn
+	}, nil)
+	req.NoError(err)
+	defer func() { _ = listener.Close() }()
+
+	req.Equal(Type+":"+sock.LocalAddr().String(), listener.Addr())
+
+	addr, err := AddressParser{}.Parse(listener.Addr())
+	req.NoError(err)
+
+	conn, err := addr.Dial("test", nil, time.Second, nil)
+	req.NoError(err)
+	defer func() { _ = conn.Close() }()
+
+	_, err = conn.Write([]byte("hello"))
+	req.NoError(err)
+
+	select {
+	case inbound := <-accepted:
+		buf := make([]byte, 5)
+		_, err = inbound.Read(buf)
+		req.NoError(err)
+		req.Equal("hello", string(buf))
+		_ = inbound.Close()
+	case <-time.After(5 * time.Second):
+		req.Fail("connection not accepted")
+	}
+
+	req.NoError(listener.Close())
+	_, err = bindAddr.Listen("test", nil, func(transport.Conn) {}, nil)
+	req.ErrorContains(err, "was closed when its last listener was closed")
+}
+
+// blockingResolver doesn't answer lookups until they're cancelled
+type blockingResolver struct{}
+
+func (blockingResolver) LookupIPAddr(ctx context.Context, _ string) ([]net.IPAddr, error) {
+	<-ctx.Done()
+	return nil, ctx.Err()
+}
+
+func TestDialTimeoutBoundsResolution(t *testing.T) {
+	req := require.New(t)
+
+	transport.SetResolver(blockingResolver{})
+	defer transport.SetResolver(nil)
+
+	addr, err := AddressParser{}.Parse("udp:peer.example.com:1234")
+	req.NoError(err)
+
+	start := time.Now()
+	_, err = addr.Dial("test", nil, 100*time.Millisecond, nil)
+	req.ErrorIs(err, context.DeadlineExceeded)
+	req.Less(time.Since(start), 2*time.Second)
+
+	start = time.Now()
+	_, err = addr.(*address).DialWithLocalBinding("test", "", nil, 100*time.Millisecond, nil)
+	req.ErrorIs(err, context.DeadlineExceeded)
+	req.Less(time.Since(start), 2*time.Second)
+}
+
+func TestConnSurvivesListenerClose(t *testing.T) {
+	req := require.New(t)
+
+	accepted := make(chan transport.Conn, 1)
+	listener, err := Listen(&net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)}, "test", nil, func(conn transport.Conn) {
+		accepted <- conn
+	})
+	req.NoError(err)
+	defer func() { _ = listener.Close() }()
+
+	addr, err := AddressParser{}.Parse(listener.Addr())
+	req.NoError(err)
+
+	conn, err := addr.Dial("test", nil, time.Second, nil)
+	req.NoError(err)
+	defer func() { _ = conn.Close() }()
+
+	_, err = conn.Write([]byte("hello"))
+	req.NoError(err)
+
+	var inbound transport.Conn
+	select {
+	case inbound = <-accepted:
+	case <-time.After(5 * time.Second):
+		req.Fail("connection not accepted")
+	}
+	defer func() { _ = inbound.Close() }()
+
+	buf := make([]byte, 5)
+	_, err = inbound.Read(buf)
+	req.NoError(err)
+
+	req.NoError(listener.Close())
+
+	_, err = conn.Write([]byte("again"))
+	req.NoError(err)
+	req.NoError(inbound.SetReadDeadline(time.Now().Add(5 * time.Second)))
+	_, err = inbound.Read(buf)
+	req.NoError(err)
+	req.Equal("again", string(buf))
+
+	_, err = inbound.Write([]byte("reply"))
+	req.NoError(err)
+	req.NoError(conn.SetReadDeadline(time.Now().Add(5 * time.Second)))
+	_, err = conn.Read(buf)
+	req.NoError(err)
+	req.Equal("reply", string(buf))
+}
+
+func TestRedialFromSameAddress(t *testing.T) {
+	req := require.New(t)
+
+	accepted := make(chan transport.Conn, 2)
+	listener, err := Listen(&net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)}, "test", nil, func(conn transport.Conn) {
+		accepted <- conn
+	})
+	req.NoError(err)
+	defer func() { _ = listener.Close() }()
+
+	addr, err := AddressParser{}.Parse(listener.Addr())
+	req.NoError(err)
+	dest, err := addr.(*address).bindableAddress()
+	req.NoError(err)
+
+	client, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	req.NoError(err)
+	defer func() { _ = client.Close() }()
+
+	for _, msg := range []string{"first", "again"} {
+		_, err = client.WriteTo([]byte(msg), dest)
+		req.NoError(err)
+
+		select {
+		case inbound := <-accepted:
+			req.Equal(client.LocalAddr().String(), inbound.RemoteAddr().String())
+			buf := make([]byte, 5)
+			_, err = inbound.Read(buf)
+			req.NoError(err)
+			req.Equal(msg, string(buf))
+			req.NoError(inbound.Close())
+		case <-time.After(5 * time.Second):
+			req.Fail("connection not accepted")
+		}
+	}
+}
@@ -40,6 +40,12 @@ type NewConnPolicy interface {
 	NewConnection(currentCount uint32) NewConnAcceptResult
 }
 
+// NewConnFilter may be implemented by a NewConnPolicy to drop packets from unknown addresses which can't start a
+// connection, rather than creating one for them
+type NewConnFilter interface {
+	AcceptFirstPacket(payload []byte) bool
+}
+
 type ConnExpirationPolicy interface {
 	IsExpired(now, lastUsed time.Time) bool
 	PollFrequency() time.Duration
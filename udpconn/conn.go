@@ -27,6 +27,10 @@ import (
 	"github.com/sirupsen/logrus"
 )
 
+// readQueueSize is the number of datagrams buffered for each connection. Datagrams which arrive while it is full are
+// dropped, so that a slow reader can't stall the other connections sharing the socket
+const readQueueSize = 64
+
 type udpConn struct {
 	readC       chan mempool.PooledBuffer
 	closeNotify chan struct{}
@@ -36,15 +40,24 @@ type udpConn struct {
 	closed      atomic.Bool
 	leftOver    []byte
 	leftOverBuf mempool.PooledBuffer
+
+	// onClose lets the listener forget the connection as soon as it is closed
+	onClose func(conn *udpConn)
 }
 
 func (conn *udpConn) Accept(buffer mempool.PooledBuffer) {
 	logrus.WithField("udpConnId", conn.srcAddr.String()).Debugf("udp->ziti: queuing")
+	if conn.closed.Load() {
+		buffer.Release()
+		logrus.WithField("udpConnId", conn.srcAddr.String()).Debugf("udp->ziti: closed, cancelling accept")
+		return
+	}
+
 	select {
 	case conn.readC <- buffer:
-	case <-conn.closeNotify:
+	default:
 		buffer.Release()
-		logrus.WithField("udpConnId", conn.srcAddr.String()).Debugf("udp->ziti: closed, cancelling accept")
+		logrus.WithField("udpConnId", conn.srcAddr.String()).Debugf("udp->ziti: read queue full, dropping packet")
 	}
 }
 
@@ -143,6 +156,9 @@ func (conn *udpConn) Write(b []byte) (int, error) {
 func (conn *udpConn) Close() error {
 	if conn.closed.CompareAndSwap(false, true) {
 		close(conn.closeNotify)
+		if conn.onClose != nil {
+			conn.onClose(conn)
+		}
 	}
 
 	return nil
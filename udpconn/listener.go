@@ -3,12 +3,14 @@ package udpconn
 import (
 	"io"
 	"net"
+	"sync"
 	"sync/atomic"
 	"time"
 
 	"github.com/michaelquigley/pfxlog"
 	"github.com/openziti/foundation/v2/info"
 	"github.com/openziti/foundation/v2/mempool"
+	"github.com/openziti/transport/v2"
 	"github.com/pkg/errors"
 )
 
@@ -16,16 +18,78 @@ func Listen(network string, addr *net.UDPAddr) (net.Listener, error) {
 	return ListenWithPolicies(network, addr, unlimitedConnections{}, defaultExpirationPolicy{})
 }
 
+// ListenWithPolicies binds a udp socket and returns a listener for it. If a socket for the same network and address
+// was adopted from another process, that socket is used instead. The socket is registered to be handed over by
+// transport.ExportListeners until the listener is closed
 func ListenWithPolicies(network string, addr *net.UDPAddr, newConnPolicy NewConnPolicy, expirationPolicy ConnExpirationPolicy) (net.Listener, error) {
+	address := addr.String()
 
-	socket, err := net.ListenUDP(network, addr)
+	socket, err := transport.AdoptedUDPConn(network, address)
 	if err != nil {
 		return nil, err
 	}
+
+	if socket == nil {
+		if socket, err = net.ListenUDP(network, addr); err != nil {
+			return nil, err
+		}
+	}
+
+	return newRegisteredListener(network, address, socket, newConnPolicy, expirationPolicy), nil
+}
+
+// ListenInherited is like ListenWithPolicies, but uses an inherited socket, given by a bind address such as fd:3,
+// instead of binding a new one
+func ListenInherited(address string, newConnPolicy NewConnPolicy, expirationPolicy ConnExpirationPolicy) (net.Listener, error) {
+	socket, err := transport.AdoptedUDPConn("udp", address)
+	if err != nil {
+		return nil, err
+	}
+
+	if socket != nil {
+		return newRegisteredListener("udp", address, socket, newConnPolicy, expirationPolicy), nil
+	}
+
+	socket, release, err := transport.InheritedUDPConn(address)
+	if err != nil {
+		return nil, err
+	}
+
+	listener := newListener(socket, newConnPolicy, expirationPolicy)
+	unregister := transport.RegisterListenerSocket("udp", address, socket)
+	listener.unregister = func() {
+		unregister()
+		release()
+	}
+	return listener, nil
+}
+
+func newRegisteredListener(network, address string, socket *net.UDPConn, newConnPolicy NewConnPolicy, expirationPolicy ConnExpirationPolicy) net.Listener {
+	listener := newListener(socket, newConnPolicy, expirationPolicy)
+	listener.unregister = transport.RegisterListenerSocket(network, address, socket)
+	return listener
+}
+
+// NewListener returns a listener which demultiplexes the datagrams received on an already bound socket into
+// connections, one per remote address. The socket is closed when the listener is closed
+func NewListener(socket *net.UDPConn) net.Listener {
+	return NewListenerWithPolicies(socket, unlimitedConnections{}, defaultExpirationPolicy{})
+}
+
+func NewListenerWithPolicies(socket *net.UDPConn, newConnPolicy NewConnPolicy, expirationPolicy ConnExpirationPolicy) net.Listener {
+	return newListener(socket, newConnPolicy, expirationPolicy)
+}
+
+// acceptQueueSize is the number of new connections which can wait to be accepted. Datagrams which would start further
+// connections are dropped until there is room, rather than stalling the connections which already exist
+const acceptQueueSize = 64
+
+func newListener(socket *net.UDPConn, newConnPolicy NewConnPolicy, expirationPolicy ConnExpirationPolicy) *udpListener {
 	listener := &udpListener{
-		addr:             addr,
 		socket:           socket,
-		acceptChannel:    make(chan net.Conn, 1),
+		acceptChannel:    make(chan net.Conn, acceptQueueSize),
+		closeNotify:      make(chan struct{}),
+		done:             make(chan struct{}),
 		eventC:           make(chan listenerEvent, 16),
 		connMap:          map[string]*udpConn{},
 		newConnPolicy:    newConnPolicy,
@@ -34,37 +98,81 @@ func ListenWithPolicies(network string, addr *net.UDPAddr, newConnPolicy NewConn
 	go listener.readLoop()
 	go listener.eventLoop()
 
-	return listener, nil
+	return listener
 }
 
+// udpListener demultiplexes the datagrams received on a socket into connections. Closing the listener stops new
+// connections from being accepted, but the socket stays open until the connections already accepted are closed too
 type udpListener struct {
-	addr             *net.UDPAddr
 	socket           *net.UDPConn
 	closed           atomic.Bool
 	acceptChannel    chan net.Conn
+	closeNotify      chan struct{}
 	eventC           chan listenerEvent
+	connLock         sync.Mutex
 	connMap          map[string]*udpConn
 	newConnPolicy    NewConnPolicy
 	expirationPolicy ConnExpirationPolicy
+	unregister       func()
+
+	// done is closed along with the socket, once the listener and all of its connections are closed
+	done        chan struct{}
+	closeSocket sync.Once
 }
 
 func (self *udpListener) Accept() (net.Conn, error) {
-	conn, ok := <-self.acceptChannel
-	if !ok {
-		return nil, errors.New("listener closed")
+	select {
+	case conn := <-self.acceptChannel:
+		return conn, nil
+	case <-self.closeNotify:
+		return nil, net.ErrClosed
 	}
-	return conn, nil
 }
 
 func (self *udpListener) Close() error {
 	if self.closed.CompareAndSwap(false, true) {
-		return self.socket.Close()
+		if self.unregister != nil {
+			self.unregister()
+		}
+		close(self.closeNotify)
+		return self.closeSocketIfUnused()
 	}
 	return nil
 }
 
+// closeSocketIfUnused closes the socket once the listener has been closed and none of its connections are left
+func (self *udpListener) closeSocketIfUnused() error {
+	if !self.closed.Load() {
+		return nil
+	}
+
+	self.connLock.Lock()
+	unused := len(self.connMap) == 0
+	self.connLock.Unlock()
+
+	var err error
+	if unused {
+		self.closeSocket.Do(func() {
+			close(self.done)
+			err = self.socket.Close()
+		})
+	}
+	return err
+}
+
+// removeConn is called when a connection is closed, so that a new connection from the same address can be accepted
+func (self *udpListener) removeConn(conn *udpConn) {
+	self.connLock.Lock()
+	if self.connMap[conn.srcAddr.String()] == conn {
+		delete(self.connMap, conn.srcAddr.String())
+	}
+	self.connLock.Unlock()
+
+	_ = self.closeSocketIfUnused()
+}
+
 func (self *udpListener) Addr() net.Addr {
-	return self.addr
+	return self.socket.LocalAddr()
 }
 
 func (self *udpListener) readLoop() {
@@ -73,21 +181,35 @@ func (self *udpListener) readLoop() {
 	defer log.Info("stopping udp listener read loop")
 
 	bufPool := mempool.NewPool(16, info.MaxUdpPacketSize)
-	for !self.closed.Load() {
+	for {
 		buf := bufPool.AcquireBuffer()
 		n, srcAddr, err := self.socket.ReadFromUDP(buf.Buf)
 		if err != nil {
+			buf.Release()
+			select {
+			case <-self.done:
+				return
+			default:
+			}
 			log.WithError(err).Error("failure while reading udp message. stopping UDP read loop")
-			self.eventC <- errorEvent{error: err}
+			self.queueEvent(errorEvent{error: err})
 			return
 		}
 
 		log.Debugf("read %v bytes from udp, queuing", len(buf.GetPayload()))
 		buf.Buf = buf.Buf[:n]
-		self.eventC <- &udpReadEvent{
+		self.queueEvent(&udpReadEvent{
 			buf:     buf,
 			srcAddr: srcAddr,
-		}
+		})
+	}
+}
+
+// queueEvent passes an event to the event loop, unless the socket has been closed
+func (self *udpListener) queueEvent(event listenerEvent) {
+	select {
+	case self.eventC <- event:
+	case <-self.done:
 	}
 }
 
@@ -116,10 +238,13 @@ func (self *udpListener) eventLoop() {
 			}
 		case <-timer.C:
 			self.dropExpired()
+		case <-self.done:
+			return
 		}
 	}
 }
 
+// getWriteQueue must be called with connLock held
 func (self *udpListener) getWriteQueue(srcAddr net.Addr) WriteQueue {
 	pfxlog.Logger().Debugf("Looking up address %v", srcAddr)
 	result := self.connMap[srcAddr.String()]
@@ -129,6 +254,7 @@ func (self *udpListener) getWriteQueue(srcAddr net.Addr) WriteQueue {
 	return result
 }
 
+// createWriteQueue must be called with connLock held
 func (self *udpListener) createWriteQueue(srcAddr net.Addr) (WriteQueue, error) {
 	switch self.newConnPolicy.NewConnection(uint32(len(self.connMap))) {
 	case AllowDropLRU:
@@ -137,15 +263,22 @@ func (self *udpListener) createWriteQueue(srcAddr net.Addr) (WriteQueue, error)
 		return nil, errors.New("max connections exceeded")
 	}
 	conn := &udpConn{
-		readC:       make(chan mempool.PooledBuffer),
+		readC:       make(chan mempool.PooledBuffer, readQueueSize),
 		closeNotify: make(chan struct{}),
 		srcAddr:     srcAddr,
 		writeConn:   self.socket,
+		onClose:     self.removeConn,
 	}
 	conn.markUsed()
-	self.connMap[srcAddr.String()] = conn
 
-	self.acceptChannel <- conn
+	select {
+	case self.acceptChannel <- conn:
+	case <-self.closeNotify:
+		return nil, errors.New("listener closed")
+	default:
+		return nil, errors.Errorf("accept queue full, dropping connection from %v", srcAddr)
+	}
+	self.connMap[srcAddr.String()] = conn
 
 	pfxlog.Logger().WithField("udpConnId", srcAddr.String()).Debug("created new virtual UDP connection")
 
@@ -155,16 +288,24 @@ func (self *udpListener) createWriteQueue(srcAddr net.Addr) (WriteQueue, error)
 func (self *udpListener) dropExpired() {
 	log := pfxlog.Logger()
 	now := time.Now()
+
+	var expired []*udpConn
+	self.connLock.Lock()
 	for key, conn := range self.connMap {
-		if conn.closed.Load() {
-			delete(self.connMap, conn.srcAddr.String())
-		} else if self.expirationPolicy.IsExpired(now, conn.GetLastUsed()) {
+		if self.expirationPolicy.IsExpired(now, conn.GetLastUsed()) {
 			log.WithField("udpConnId", key).Debug("connection expired. removing from UDP vconn manager")
-			self.close(conn)
+			delete(self.connMap, key)
+			expired = append(expired, conn)
 		}
 	}
+	self.connLock.Unlock()
+
+	for _, conn := range expired {
+		_ = conn.Close()
+	}
 }
 
+// dropLRU must be called with connLock held
 func (self *udpListener) dropLRU() {
 	if len(self.connMap) < 1 {
 		return
@@ -180,9 +321,10 @@ func (self *udpListener) dropLRU() {
 	self.close(oldest)
 }
 
+// close must be called with connLock held. The connection is closed outside the lock, as closing it removes it
 func (self *udpListener) close(conn *udpConn) {
-	_ = conn.Close()
 	delete(self.connMap, conn.srcAddr.String())
+	go func() { _ = conn.Close() }()
 }
 
 type udpReadEvent struct {
@@ -193,16 +335,34 @@ type udpReadEvent struct {
 func (event *udpReadEvent) handle(listener *udpListener) error {
 	log := pfxlog.Logger()
 
+	listener.connLock.Lock()
 	writeQueue := listener.getWriteQueue(event.srcAddr)
 
 	if writeQueue == nil {
+		if listener.closed.Load() {
+			listener.connLock.Unlock()
+			log.Debugf("listener closed, dropping packet from %v", event.srcAddr)
+			event.buf.Release()
+			return nil
+		}
+
+		if filter, ok := listener.newConnPolicy.(NewConnFilter); ok && !filter.AcceptFirstPacket(event.buf.GetPayload()) {
+			listener.connLock.Unlock()
+			log.Debugf("dropping packet from %v which can't start a connection", event.srcAddr)
+			event.buf.Release()
+			return nil
+		}
+
 		log.Debugf("received connection for %v --> %v", event.srcAddr, listener.socket.LocalAddr())
 		var err error
 		writeQueue, err = listener.createWriteQueue(event.srcAddr)
 		if err != nil {
+			listener.connLock.Unlock()
+			event.buf.Release()
 			return err
 		}
 	}
+	listener.connLock.Unlock()
 
 	log.Tracef("received %v bytes from %v", len(event.buf.Buf), writeQueue.LocalAddr())
 	writeQueue.Accept(event.buf)
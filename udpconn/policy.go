@@ -50,6 +50,22 @@ func (policy *limitedConnections) NewConnection(count uint32) NewConnAcceptResul
 	return Allow
 }
 
+// NewNoExpirationPolicy returns a policy which never expires idle connections. Connections are still removed once
+// they're closed
+func NewNoExpirationPolicy() ConnExpirationPolicy {
+	return noExpirationPolicy{}
+}
+
+type noExpirationPolicy struct{}
+
+func (policy noExpirationPolicy) IsExpired(time.Time, time.Time) bool {
+	return false
+}
+
+func (policy noExpirationPolicy) PollFrequency() time.Duration {
+	return time.Second * 30
+}
+
 func NewDefaultExpirationPolicy() ConnExpirationPolicy {
 	return defaultExpirationPolicy{}
 }
@@ -0,0 +1,92 @@
+/*
+	Copyright NetFoundry Inc.
+
+	Licensed under the Apache License, Version 2.0 (the "License");
+	you may not use this file except in compliance with the License.
+	You may obtain a copy of the License at
+
+	https://www.apache.org/licenses/LICENSE-2.0
+
+	Unless required by applicable law or agreed to in writing, software
+	distributed under the License is distributed on an "AS IS" BASIS,
+	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+	See the License for the specific language governing permissions and
+	limitations under the License.
+*/
+
+package unix
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/openziti/identity"
+	"github.com/openziti/transport/v2"
+)
+
+var _ transport.Address = &address{} // enforce that address implements transport.Address
+
+const Type = "unix"
+
+// address is a unix domain socket address. A path starting with @ denotes a socket in the Linux abstract namespace
+type address struct {
+	path string
+}
+
+func (a address) Dial(name string, _ *identity.TokenId, timeout time.Duration, _ transport.Configuration) (transport.Conn, error) {
+	return Dial(a.path, name, timeout)
+}
+
+func (a address) DialWithLocalBinding(name string, localBinding string, _ *identity.TokenId, timeout time.Duration, _ transport.Configuration) (transport.Conn, error) {
+	return DialWithLocalBinding(a.path, name, localBinding, timeout)
+}
+
+func (a address) DialContext(ctx context.Context, name string, _ *identity.TokenId, _ transport.Configuration) (transport.Conn, error) {
+	return DialContext(ctx, a.path, name)
+}
+
+func (a address) DialWithLocalBindingContext(ctx context.Context, name string, localBinding string, _ *identity.TokenId, _ transport.Configuration) (transport.Conn, error) {
+	return DialWithLocalBindingContext(ctx, a.path, name, localBinding)
+}
+
+func (a address) Listen(name string, _ *identity.TokenId, acceptF func(transport.Conn), _ transport.Configuration) (transport.Listener, error) {
+	return Listen(a.path, name, acceptF)
+}
+
+func (a address) MustListen(name string, i *identity.TokenId, acceptF func(transport.Conn), tcfg transport.Configuration) transport.Listener {
+	closer, err := a.Listen(name, i, acceptF, tcfg)
+	if err != nil {
+		panic(err)
+	}
+	return closer
+}
+
+func (a address) String() string {
+	return fmt.Sprintf("%s:%s", Type, a.path)
+}
+
+func (a address) Type() string {
+	return Type
+}
+
+func (a address) Path() string {
+	return a.path
+}
+
+type AddressParser struct{}
+
+func (ap AddressParser) Parse(s string) (transport.Address, error) {
+	prefix := Type + ":"
+	if !strings.HasPrefix(s, prefix) {
+		return nil, fmt.Errorf("invalid %s address, doesn't start with %s", Type, prefix)
+	}
+
+	path := s[len(prefix):]
+	if path == "" || path == "@" {
+		return nil, fmt.Errorf("invalid %s address '%s', no socket path given", Type, s)
+	}
+
+	return &address{path: path}, nil
+}
@@ -0,0 +1,46 @@
+package unix
+
+import (
+	"testing"
+)
+
+func TestParseAndString(t *testing.T) {
+	parser := AddressParser{}
+
+	tests := []struct {
+		name     string
+		input    string
+		want     string
+		wantPath string
+		wantErr  bool
+	}{
+		{"absolute path", "unix:/var/run/ziti.sock", "unix:/var/run/ziti.sock", "/var/run/ziti.sock", false},
+		{"relative path", "unix:ziti.sock", "unix:ziti.sock", "ziti.sock", false},
+		{"abstract", "unix:@ziti", "unix:@ziti", "@ziti", false},
+		{"empty path", "unix:", "", "", true},
+		{"empty abstract", "unix:@", "", "", true},
+		{"wrong prefix", "tcp:localhost:8080", "", "", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result, err := parser.Parse(tt.input)
+			if tt.wantErr {
+				if err == nil {
+					t.Errorf("Parse(%q) expected error, got nil", tt.input)
+				}
+				return
+			}
+			if err != nil {
+				t.Errorf("Parse(%q) unexpected error: %v", tt.input, err)
+				return
+			}
+			if got := result.String(); got != tt.want {
+				t.Errorf("Parse(%q).String() = %q, want %q", tt.input, got, tt.want)
+			}
+			if got := result.(*address).Path(); got != tt.wantPath {
+				t.Errorf("Parse(%q).Path() = %q, want %q", tt.input, got, tt.wantPath)
+			}
+		})
+	}
+}
@@ -0,0 +1,43 @@
+/*
+	Copyright NetFoundry Inc.
+
+	Licensed under the Apache License, Version 2.0 (the "License");
+	you may not use this file except in compliance with the License.
+	You may obtain a copy of the License at
+
+	https://www.apache.org/licenses/LICENSE-2.0
+
+	Unless required by applicable law or agreed to in writing, software
+	distributed under the License is distributed on an "AS IS" BASIS,
+	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+	See the License for the specific language governing permissions and
+	limitations under the License.
+*/
+
+package unix
+
+import (
+	"crypto/x509"
+	"net"
+
+	"github.com/openziti/transport/v2"
+)
+
+type Connection struct {
+	detail *transport.ConnectionDetail
+	net.Conn
+}
+
+func (self *Connection) Detail() *transport.ConnectionDetail {
+	return self.detail
+}
+
+func (self *Connection) PeerCertificates() []*x509.Certificate {
+	return nil
+}
+
+// PeerCredentials returns the credentials of the process on the other end of the socket, or nil if they are not
+// available on this platform
+func (self *Connection) PeerCredentials() *transport.PeerCredentials {
+	return self.detail.PeerCredentials
+}
@@ -0,0 +1,79 @@
+/*
+	Copyright NetFoundry Inc.
+
+	Licensed under the Apache License, Version 2.0 (the "License");
+	you may not use this file except in compliance with the License.
+	You may obtain a copy of the License at
+
+	https://www.apache.org/licenses/LICENSE-2.0
+
+	Unless required by applicable law or agreed to in writing, software
+	distributed under the License is distributed on an "AS IS" BASIS,
+	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+	See the License for the specific language governing permissions and
+	limitations under the License.
+*/
+
+package unix
+
+import (
+	"context"
+	"net"
+	"time"
+
+	"github.com/michaelquigley/pfxlog"
+	"github.com/openziti/transport/v2"
+)
+
+func Dial(path, name string, timeout time.Duration) (transport.Conn, error) {
+	ctx, cancelF := transport.TimeoutContext(timeout)
+	defer cancelF()
+	return DialContext(ctx, path, name)
+}
+
+func DialWithLocalBinding(path, name, localBinding string, timeout time.Duration) (transport.Conn, error) {
+	ctx, cancelF := transport.TimeoutContext(timeout)
+	defer cancelF()
+	return DialWithLocalBindingContext(ctx, path, name, localBinding)
+}
+
+func DialContext(ctx context.Context, path, name string) (transport.Conn, error) {
+	return DialWithLocalBindingContext(ctx, path, name, "")
+}
+
+// DialWithLocalBindingContext dials the socket at the given path. For unix sockets, the local binding, if given, is
+// the path (or abstract name) the client side of the connection is bound to
+func DialWithLocalBindingContext(ctx context.Context, path, name, localBinding string) (transport.Conn, error) {
+	dialer := &net.Dialer{}
+	if localBinding != "" {
+		dialer.LocalAddr = &net.UnixAddr{Name: localBinding, Net: "unix"}
+	}
+
+	socket, err := dialer.DialContext(ctx, "unix", path)
+	if err != nil {
+		return nil, err
+	}
+
+	return newConnection(socket, path, name, false), nil
+}
+
+func newConnection(socket net.Conn, path, name string, inBound bool) *Connection {
+	detail := &transport.ConnectionDetail{
+		Address: Type + ":" + path,
+		InBound: inBound,
+		Name:    name,
+	}
+
+	if unixConn, ok := socket.(*net.UnixConn); ok {
+		creds, err := GetPeerCredentials(unixConn)
+		if err != nil {
+			pfxlog.Logger().WithError(err).WithField("path", path).Debug("unable to get peer credentials")
+		}
+		detail.PeerCredentials = creds
+	}
+
+	return &Connection{
+		detail: detail,
+		Conn:   socket,
+	}
+}
@@ -0,0 +1,152 @@
+/*
+	Copyright NetFoundry Inc.
+
+	Licensed under the Apache License, Version 2.0 (the "License");
+	you may not use this file except in compliance with the License.
+	You may obtain a copy of the License at
+
+	https://www.apache.org/licenses/LICENSE-2.0
+
+	Unless required by applicable law or agreed to in writing, software
+	distributed under the License is distributed on an "AS IS" BASIS,
+	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+	See the License for the specific language governing permissions and
+	limitations under the License.
+*/
+
+package unix
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+
+	"github.com/michaelquigley/pfxlog"
+	"github.com/openziti/transport/v2"
+	"github.com/sirupsen/logrus"
+)
+
+func Listen(path, name string, acceptF func(transport.Conn)) (transport.Listener, error) {
+	log := pfxlog.ContextLogger(name + "/" + Type + ":" + path)
+
+	if err := RemoveStaleSocket(path); err != nil {
+		return nil, err
+	}
+
+	sock, err := net.Listen("unix", path)
+	if err != nil {
+		return nil, err
+	}
+
+	result := &listener{
+		name:    name,
+		path:    path,
+		sock:    sock,
+		acceptF: acceptF,
+	}
+
+	result.acceptLoops.Add(1)
+	go result.acceptLoop(log.Entry)
+
+	return result, nil
+}
+
+type listener struct {
+	transport.ListenerCounters
+	name        string
+	path        string
+	sock        net.Listener
+	acceptF     func(transport.Conn)
+	acceptLoops sync.WaitGroup
+	closed      atomic.Bool
+}
+
+func (self *listener) Addr() string {
+	return Type + ":" + self.path
+}
+
+func (self *listener) Close() error {
+	if self.closed.CompareAndSwap(false, true) {
+		return self.sock.Close()
+	}
+	return nil
+}
+
+// Shutdown closes the listening socket and waits for the accept loop to exit
+func (self *listener) Shutdown(ctx context.Context) error {
+	if err := self.Close(); err != nil {
+		return err
+	}
+	return transport.WaitForShutdown(ctx, &self.acceptLoops)
+}
+
+// RemoveStaleSocket removes a socket file left behind by a process which exited without closing its listener. It
+// fails if the path exists but isn't a socket, or if something is still accepting connections on it. Abstract
+// socket names are never backed by a file and are ignored.
+func RemoveStaleSocket(path string) error {
+	if strings.HasPrefix(path, "@") {
+		return nil
+	}
+
+	info, err := os.Lstat(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+
+	if info.Mode()&os.ModeSocket == 0 {
+		return fmt.Errorf("unable to listen on %s, file exists and is not a socket", path)
+	}
+
+	conn, err := net.DialTimeout("unix", path, time.Second)
+	if err == nil {
+		_ = conn.Close()
+		return fmt.Errorf("unable to listen on %s, socket is in use", path)
+	}
+
+	if !errors.Is(err, syscall.ECONNREFUSED) {
+		return fmt.Errorf("unable to determine if socket %s is stale (%w)", path, err)
+	}
+
+	pfxlog.Logger().WithField("path", path).Info("removing stale socket file")
+	if err = os.Remove(path); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+func (self *listener) acceptLoop(log *logrus.Entry) {
+	defer self.acceptLoops.Done()
+	defer log.Error("exited")
+
+	for {
+		socket, err := self.sock.Accept()
+		if err != nil {
+			if self.closed.Load() {
+				log.WithError(err).Info("listener closed, exiting")
+				return
+			}
+			log.WithField("err", err).Error("accept failed. failure not recoverable. exiting listen loop")
+			return
+		} else {
+			connection := newConnection(socket, self.path, self.name, true)
+			self.IncrementAccepted()
+			self.acceptF(connection)
+
+			if creds := connection.PeerCredentials(); creds != nil {
+				log.WithField("peer", creds.String()).Info("accepted connection")
+			} else {
+				log.Info("accepted connection")
+			}
+		}
+	}
+}
@@ -0,0 +1,98 @@
+package unix
+
+import (
+	"context"
+	"net"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+	"time"
+
+	"github.com/openziti/transport/v2"
+	"github.com/stretchr/testify/require"
+)
+
+func TestListenAndDial(t *testing.T) {
+	req := require.New(t)
+
+	path := filepath.Join(t.TempDir(), "test.sock")
+
+	accepted := make(chan transport.Conn, 1)
+	closer, err := Listen(path, "test", func(conn transport.Conn) {
+		accepted <- conn
+	})
+	req.NoError(err)
+
+	conn, err := Dial(path, "test", time.Second)
+	req.NoError(err)
+	defer func() { _ = conn.Close() }()
+
+	var inbound transport.Conn
+	select {
+	case inbound = <-accepted:
+	case <-time.After(time.Second):
+		req.Fail("connection not accepted")
+	}
+	defer func() { _ = inbound.Close() }()
+
+	req.True(inbound.Detail().InBound)
+	req.Equal("unix:"+path, inbound.Detail().Address)
+	req.Equal("unix:"+path, closer.Addr())
+	req.Equal(transport.ListenerStats{Accepted: 1}, closer.Stats())
+
+	if runtime.GOOS == "linux" {
+		creds := inbound.Detail().PeerCredentials
+		req.NotNil(creds)
+		req.Equal(uint32(os.Getuid()), creds.Uid)
+		req.Equal(uint32(os.Getgid()), creds.Gid)
+		req.Equal(int32(os.Getpid()), creds.Pid)
+		req.NotNil(conn.Detail().PeerCredentials)
+	}
+
+	_, err = conn.Write([]byte("hello"))
+	req.NoError(err)
+	buf := make([]byte, 5)
+	_, err = inbound.Read(buf)
+	req.NoError(err)
+	req.Equal("hello", string(buf))
+
+	ctx, cancelF := context.WithTimeout(context.Background(), time.Second)
+	defer cancelF()
+	req.NoError(closer.Shutdown(ctx))
+	_, err = os.Stat(path)
+	req.True(os.IsNotExist(err), "socket file should be removed on close")
+}
+
+func TestListenRemovesStaleSocket(t *testing.T) {
+	req := require.New(t)
+
+	path := filepath.Join(t.TempDir(), "stale.sock")
+
+	// leave a socket file behind without anything listening on it
+	stale, err := net.ListenUnix("unix", &net.UnixAddr{Name: path, Net: "unix"})
+	req.NoError(err)
+	stale.SetUnlinkOnClose(false)
+	req.NoError(stale.Close())
+
+	_, err = os.Stat(path)
+	req.NoError(err)
+
+	closer, err := Listen(path, "test", func(conn transport.Conn) {
+		_ = conn.Close()
+	})
+	req.NoError(err)
+
+	_, err = Listen(path, "test", func(conn transport.Conn) {
+		_ = conn.Close()
+	})
+	req.Error(err, "socket in use should not be removed")
+
+	req.NoError(closer.Close())
+
+	req.NoError(os.WriteFile(path, []byte("not a socket"), 0600))
+	_, err = Listen(path, "test", func(conn transport.Conn) {
+		_ = conn.Close()
+	})
+	req.Error(err, "regular files should not be removed")
+}
@@ -0,0 +1,31 @@
+//go:build !linux
+
+/*
+	Copyright NetFoundry Inc.
+
+	Licensed under the Apache License, Version 2.0 (the "License");
+	you may not use this file except in compliance with the License.
+	You may obtain a copy of the License at
+
+	https://www.apache.org/licenses/LICENSE-2.0
+
+	Unless required by applicable law or agreed to in writing, software
+	distributed under the License is distributed on an "AS IS" BASIS,
+	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+	See the License for the specific language governing permissions and
+	limitations under the License.
+*/
+
+package unix
+
+import (
+	"errors"
+	"net"
+
+	"github.com/openziti/transport/v2"
+)
+
+// GetPeerCredentials is only supported on Linux
+func GetPeerCredentials(*net.UnixConn) (*transport.PeerCredentials, error) {
+	return nil, errors.New("peer credentials are not supported on this platform")
+}
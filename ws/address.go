@@ -17,9 +17,9 @@
 package ws
 
 import (
+	"context"
 	"errors"
 	"fmt"
-	"io"
 	"time"
 
 	"github.com/openziti/identity"
@@ -44,11 +44,19 @@ func (address) DialWithLocalBinding(name string, binding string, i *identity.Tok
 	return nil, errors.New(unsupportedErr)
 }
 
-func (address) Listen(name string, i *identity.TokenId, acceptF func(transport.Conn), tcfg transport.Configuration) (io.Closer, error) {
+func (address) DialContext(ctx context.Context, name string, i *identity.TokenId, tcfg transport.Configuration) (transport.Conn, error) {
 	return nil, errors.New(unsupportedErr)
 }
 
-func (address) MustListen(name string, i *identity.TokenId, acceptF func(transport.Conn), tcfg transport.Configuration) io.Closer {
+func (address) DialWithLocalBindingContext(ctx context.Context, name string, binding string, i *identity.TokenId, tcfg transport.Configuration) (transport.Conn, error) {
+	return nil, errors.New(unsupportedErr)
+}
+
+func (address) Listen(name string, i *identity.TokenId, acceptF func(transport.Conn), tcfg transport.Configuration) (transport.Listener, error) {
+	return nil, errors.New(unsupportedErr)
+}
+
+func (address) MustListen(name string, i *identity.TokenId, acceptF func(transport.Conn), tcfg transport.Configuration) transport.Listener {
 	panic(unsupportedErr)
 }
 
@@ -17,8 +17,8 @@
 package wss
 
 import (
+	"context"
 	"fmt"
-	"io"
 	"net/url"
 	"time"
 
@@ -45,7 +45,17 @@ func (a address) DialWithLocalBinding(name string, localBinding string, i *ident
 	return DialWithLocalBinding(name, u, localBinding, i, t, c)
 }
 
-func (a address) Listen(name string, i *identity.TokenId, acceptF func(transport.Conn), tcfg transport.Configuration) (io.Closer, error) {
+func (a address) DialContext(ctx context.Context, name string, i *identity.TokenId, c transport.Configuration) (transport.Conn, error) {
+	u := url.URL{Scheme: "wss", Host: a.bindableAddress(), Path: "/ws"}
+	return DialContext(ctx, name, u, i, c)
+}
+
+func (a address) DialWithLocalBindingContext(ctx context.Context, name string, localBinding string, i *identity.TokenId, c transport.Configuration) (transport.Conn, error) {
+	u := url.URL{Scheme: "wss", Host: a.bindableAddress(), Path: "/ws"}
+	return DialWithLocalBindingContext(ctx, name, u, localBinding, i, c)
+}
+
+func (a address) Listen(name string, i *identity.TokenId, acceptF func(transport.Conn), tcfg transport.Configuration) (transport.Listener, error) {
 	var wssConfig map[interface{}]interface{}
 	if tcfg != nil {
 		if v, found := tcfg[Type]; found {
@@ -55,7 +65,7 @@ func (a address) Listen(name string, i *identity.TokenId, acceptF func(transport
 	return Listen(a.bindableAddress(), name, i, acceptF, wssConfig)
 }
 
-func (a address) MustListen(name string, i *identity.TokenId, acceptF func(transport.Conn), tcfg transport.Configuration) io.Closer {
+func (a address) MustListen(name string, i *identity.TokenId, acceptF func(transport.Conn), tcfg transport.Configuration) transport.Listener {
 	closer, err := a.Listen(name, i, acceptF, tcfg)
 	if err != nil {
 		panic(err)
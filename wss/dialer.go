@@ -19,35 +19,72 @@
 package wss
 
 import (
+	"context"
 	"crypto/tls"
+	"net"
 	"net/url"
 	"time"
 
 	"github.com/gorilla/websocket"
 	"github.com/openziti/identity"
 	"github.com/openziti/transport/v2"
+	"github.com/openziti/transport/v2/proxies"
 	transporttls "github.com/openziti/transport/v2/tls"
+	"github.com/pkg/errors"
 	log "github.com/sirupsen/logrus"
 )
 
-func Dial(name string, u url.URL, i *identity.TokenId, _ time.Duration, _ transport.Configuration) (transport.Conn, error) {
+func Dial(name string, u url.URL, i *identity.TokenId, timeout time.Duration, tcfg transport.Configuration) (transport.Conn, error) {
+	ctx, cancelF := transport.TimeoutContext(timeout)
+	defer cancelF()
+	return DialContext(ctx, name, u, i, tcfg)
+}
+
+func DialWithLocalBinding(name string, u url.URL, localBinding string, i *identity.TokenId, timeout time.Duration, tcfg transport.Configuration) (transport.Conn, error) {
+	return Dial(name, u, i, timeout, tcfg)
+}
+
+func DialContext(ctx context.Context, name string, u url.URL, i *identity.TokenId, tcfg transport.Configuration) (transport.Conn, error) {
 	tlsConfig := ClientTLSConfig(u, i)
-	websocket.DefaultDialer.TLSClientConfig = tlsConfig
 
-	wsConn, httpResp, err := websocket.DefaultDialer.Dial(u.String(), nil)
+	dialer := *websocket.DefaultDialer
+	dialer.TLSClientConfig = tlsConfig
+
+	proxyConf, err := tcfg.GetProxyConfiguration()
+	if err != nil {
+		return nil, errors.Wrap(err, "unable to get proxy configuration")
+	}
+
+	// an explicitly configured proxy replaces the proxy environment variables honored by the default dialer
+	if proxyConf != nil {
+		contextDialer, err := proxies.NewContextDialer(proxyConf, &net.Dialer{})
+		if err != nil {
+			return nil, err
+		}
+		dialer.Proxy = nil
+		dialer.NetDialContext = contextDialer.DialContext
+	}
+
+	wsConn, httpResp, err := dialer.DialContext(ctx, u.String(), nil)
 	if err != nil {
 		return nil, err
 	}
 	log.Debugf("httpResp %s", httpResp.Status)
 
+	tlsConn := tls.Client(&connImpl{ws: wsConn}, tlsConfig)
+	if err = tlsConn.HandshakeContext(ctx); err != nil {
+		_ = wsConn.Close()
+		return nil, err
+	}
+
 	detail := &transport.ConnectionDetail{
 		Address: Type + ":" + u.Host,
 		InBound: false,
 		Name:    name,
 	}
-	return transporttls.NewConnection(detail, tls.Client(&connImpl{ws: wsConn}, tlsConfig)), nil
+	return transporttls.NewConnection(detail, tlsConn), nil
 }
 
-func DialWithLocalBinding(name string, u url.URL, localBinding string, i *identity.TokenId, timeout time.Duration, tcfg transport.Configuration) (transport.Conn, error) {
-	return Dial(name, u, i, timeout, tcfg)
+func DialWithLocalBindingContext(ctx context.Context, name string, u url.URL, localBinding string, i *identity.TokenId, tcfg transport.Configuration) (transport.Conn, error) {
+	return DialContext(ctx, name, u, i, tcfg)
 }
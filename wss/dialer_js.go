@@ -32,18 +32,31 @@ import (
 	log "github.com/sirupsen/logrus"
 )
 
-func Dial(name string, u url.URL, i *identity.TokenId, to time.Duration, _ transport.Configuration) (transport.Conn, error) {
-	ctx, _ := context.WithTimeout(context.Background(), time.Minute) //cancel //time.Minute)
+func Dial(name string, u url.URL, i *identity.TokenId, to time.Duration, tcfg transport.Configuration) (transport.Conn, error) {
+	ctx, cancelF := context.WithTimeout(context.Background(), time.Minute)
+	defer cancelF()
+	return DialContext(ctx, name, u, i, tcfg)
+}
+
+func DialWithLocalBinding(name string, u url.URL, localBinding string, i *identity.TokenId, timeout time.Duration, tcfg transport.Configuration) (transport.Conn, error) {
+	return Dial(name, u, i, timeout, tcfg)
+}
 
-	log.Debugf("Dialing websocket: %", u.String())
+func DialContext(ctx context.Context, name string, u url.URL, i *identity.TokenId, _ transport.Configuration) (transport.Conn, error) {
+	log.Debugf("Dialing websocket: %s", u.String())
 	c, httpResp, err := websocket.Dial(ctx, u.String(), nil)
 	if err != nil {
 		return nil, err
 	}
 	log.Debugf("httpResp %v", httpResp)
 
-	conn := websocket.NetConn(ctx, c, websocket.MessageBinary)
+	// the context given to NetConn governs the lifetime of the connection, so it must not be the dial context
+	conn := websocket.NetConn(context.Background(), c, websocket.MessageBinary)
 	tlsConn := tls.Client(conn, ClientTLSConfig(u, i))
+	if err = tlsConn.HandshakeContext(ctx); err != nil {
+		_ = conn.Close()
+		return nil, err
+	}
 
 	detail := &transport.ConnectionDetail{
 		Address: Type + ":" + u.Host,
@@ -53,6 +66,6 @@ func Dial(name string, u url.URL, i *identity.TokenId, to time.Duration, _ trans
 	return transporttls.NewConnection(detail, tlsConn), nil
 }
 
-func DialWithLocalBinding(name string, u url.URL, localBinding string, i *identity.TokenId, timeout time.Duration, tcfg transport.Configuration) (transport.Conn, error) {
-	return Dial(name, u, i, timeout, tcfg)
+func DialWithLocalBindingContext(ctx context.Context, name string, u url.URL, localBinding string, i *identity.TokenId, tcfg transport.Configuration) (transport.Conn, error) {
+	return DialContext(ctx, name, u, i, tcfg)
 }
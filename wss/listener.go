@@ -17,11 +17,13 @@
 package wss
 
 import (
+	"context"
 	"crypto/tls"
 	"errors"
 	"fmt"
-	"io"
+	"net"
 	"net/http"
+	"sync"
 	"time"
 
 	"github.com/openziti/identity"
@@ -45,17 +47,49 @@ var (
 )
 
 type wssListener struct {
+	transport.ListenerCounters
 	log      *logrus.Entry
 	acceptF  func(transport.Conn)
 	cfg      *Config
 	ctr      int64
 	upgrader websocket.Upgrader
+	server   *http.Server
+	sock     net.Listener
+	handlers sync.WaitGroup
+}
+
+func (listener *wssListener) Addr() string {
+	return Type + ":" + listener.sock.Addr().String()
+}
+
+func (listener *wssListener) Close() error {
+	return listener.server.Close()
+}
+
+// Shutdown first waits for tls handshakes in progress on the shared tls listener to be handed to the http server,
+// then shuts down the http server and waits for websocket upgrades and the tls handshakes over them to finish
+func (listener *wssListener) Shutdown(ctx context.Context) error {
+	if sock, ok := listener.sock.(interface{ Shutdown(context.Context) error }); ok {
+		if err := sock.Shutdown(ctx); err != nil {
+			return err
+		}
+	}
+
+	if err := listener.server.Shutdown(ctx); err != nil {
+		return err
+	}
+
+	// upgraded connections are hijacked, so the http server doesn't wait for their handlers
+	return transport.WaitForShutdown(ctx, &listener.handlers)
 }
 
 /**
  *	Accept acceptF HTTP connection, and upgrade it to a websocket suitable for communication between ziti-browzer-runtime and Ziti Edge Router
  */
 func (listener *wssListener) handleWebsocket(w http.ResponseWriter, r *http.Request) {
+	listener.handlers.Add(1)
+	defer listener.handlers.Done()
+
 	log := listener.log
 	log.Info("entered")
 
@@ -63,6 +97,7 @@ func (listener *wssListener) handleWebsocket(w http.ResponseWriter, r *http.Requ
 
 	if err != nil {
 		log.WithError(err).Error("websocket upgrade failed. Failure not recoverable.")
+		listener.IncrementFailed()
 	} else {
 
 		var zero time.Time
@@ -89,16 +124,19 @@ func (listener *wssListener) handleWebsocket(w http.ResponseWriter, r *http.Requ
 		if err = tlsConn.Handshake(); err != nil {
 			log.WithError(err).Error("unable to establish tls over websocket")
 			_ = c.Close()
+			listener.IncrementFailed()
 			return
 		}
 
 		detail := &transport.ConnectionDetail{
-			Address: Type + ":" + c.NetConn().RemoteAddr().String(),
-			InBound: true,
-			Name:    Type,
+			Address:   Type + ":" + c.NetConn().RemoteAddr().String(),
+			InBound:   true,
+			Name:      Type,
+			ProxiedBy: transport.ProxiedBy(c.NetConn()),
 		}
 
 		connection := transporttls.NewConnection(detail, tlsConn)
+		listener.IncrementAccepted()
 		listener.acceptF(connection) // pass the Websocket to the goroutine that will validate the HELLO handshake
 
 		// keep the Websocket alive via ping/pong control-frame msgs
@@ -109,7 +147,7 @@ func (listener *wssListener) handleWebsocket(w http.ResponseWriter, r *http.Requ
 	}
 }
 
-func Listen(bindAddress string, name string, i *identity.TokenId, acceptF func(transport.Conn), tcfg transport.Configuration) (io.Closer, error) {
+func Listen(bindAddress string, name string, i *identity.TokenId, acceptF func(transport.Conn), tcfg transport.Configuration) (transport.Listener, error) {
 	log := pfxlog.ContextLogger(name + "/" + Type + ":" + bindAddress)
 
 	cfg := NewDefaultConfig()
@@ -156,16 +194,19 @@ func Listen(bindAddress string, name string, i *identity.TokenId, acceptF func(t
 		TLSConfig:    tlsConfig,
 	}
 
-	nl, err := transporttls.ListenTLS(bindAddress, "wss", tlsConfig)
+	nl, err := transporttls.ListenTLSWithConfig(bindAddress, "wss", tlsConfig, tcfg)
 	if err != nil {
 		return nil, fmt.Errorf("listen TLS error: %w", err)
 	}
 
+	listener.server = httpServer
+	listener.sock = nl
+
 	go func() {
 		if err := httpServer.Serve(nl); err != nil && !errors.Is(err, http.ErrServerClosed) {
 			log.Entry.WithError(err).Error("HTTP server failed")
 		}
 	}()
 
-	return httpServer, nil
+	return listener, nil
 }